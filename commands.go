@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	authService "sahamrakyat_test/auth/service"
+	"sahamrakyat_test/database"
+	"sahamrakyat_test/database/migrations"
+	"sahamrakyat_test/database/seeds"
+	exportsService "sahamrakyat_test/exports/service"
+	"sahamrakyat_test/helpers"
+	ordersService "sahamrakyat_test/orders/service"
+	stocksService "sahamrakyat_test/stocks/service"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator"
+)
+
+func migrate(args []string) {
+	if len(args) < 1 {
+		fail("migrate up | down [steps] | status | create <name>")
+	}
+
+	if args[0] == "create" {
+		if len(args) != 2 {
+			fail("migrate create <name>")
+		}
+
+		paths, err := migrations.Create(migrations.Dir, args[1])
+
+		if err != nil {
+			log.Fatalf("Failed to create migration: %s", err)
+		}
+
+		log.Printf("Created %s", strings.Join(paths, " and "))
+
+		return
+	}
+
+	deps := open()
+	defer deps.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(deps.DB, deps.Config.Auth.AdminEmail)
+
+		for _, migration := range applied {
+			log.Printf("Applied %s", migration)
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Applied %d migrations", len(applied))
+	case "down":
+		steps := 1
+
+		if len(args) > 1 {
+			var err error
+
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fail("migrate down [steps]")
+			}
+		}
+
+		reverted, err := migrations.Down(deps.DB, steps)
+
+		for _, migration := range reverted {
+			log.Printf("Reverted %s", migration)
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		state, err := migrations.Status(deps.DB)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("Version: %s\n", state.Version)
+
+		for _, pending := range state.Pending {
+			fmt.Printf("Pending: %s\n", pending)
+		}
+	default:
+		fail("migrate up | down [steps] | status | create <name>")
+	}
+}
+
+func seed(args []string) {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	profile := flags.String("profile", "small", "amount of data: small, medium or load-test")
+	randomSeed := flags.Int64("seed", 1, "random seed, the same seed always produces the same data")
+	truncate := flags.Bool("truncate", false, "empty the seeded tables first, refused in production")
+	flags.Parse(args)
+
+	if _, ok := seeds.Profiles[*profile]; !ok {
+		fail("seed [-profile small|medium|load-test] [-seed n] [-truncate]")
+	}
+
+	deps := open()
+	defer deps.Close()
+
+	ctx := context.Background()
+
+	report, err := seeds.Seed(ctx, deps.DB, seeds.Options{
+		Profile:  *profile,
+		Seed:     *randomSeed,
+		Truncate: *truncate,
+		Env:      deps.Config.App.Env,
+	})
+
+	if err != nil {
+		log.Fatalf("Failed to seed database: %s", err)
+	}
+
+	// Cached lists no longer match the tables.
+	if _, err := helpers.ClearCache(ctx, deps.Redis); err != nil {
+		log.Printf("Failed to flush cache: %s", err)
+	}
+
+	log.Printf("Seeded %d stocks, %d users and %d orders", report.Stocks, report.Users, report.Orders)
+}
+
+func cache(args []string) {
+	if len(args) < 1 || args[0] != "flush" || len(args) > 2 {
+		fail("cache flush [prefix]")
+	}
+
+	deps := open()
+	defer deps.Close()
+
+	ctx := context.Background()
+
+	if len(args) == 1 {
+		deleted, err := helpers.ClearCache(ctx, deps.Redis)
+
+		if err != nil {
+			log.Fatalf("Failed to flush cache: %s", err)
+		}
+
+		log.Printf("Flushed the cache, deleted %d keys", deleted)
+
+		return
+	}
+
+	deleted, err := helpers.FlushCachePrefix(ctx, deps.Redis, args[1])
+
+	if err != nil {
+		log.Fatalf("Failed to flush cache: %s", err)
+	}
+
+	log.Printf("Deleted %d keys starting with %s", deleted, args[1])
+}
+
+func users(args []string) {
+	if len(args) < 1 || args[0] != "create" {
+		fail("users create -name <full name> -email <email> -password <password> [-phone <phone>] [-role user|admin]")
+	}
+
+	flags := flag.NewFlagSet("users create", flag.ExitOnError)
+	name := flags.String("name", "", "full name")
+	email := flags.String("email", "", "email address")
+	phone := flags.String("phone", "", "phone number in E.164 format")
+	password := flags.String("password", "", "password, at least 8 characters")
+	role := flags.String("role", database.RoleUser, "role, user or admin")
+	flags.Parse(args[1:])
+
+	if *role != database.RoleUser && *role != database.RoleAdmin {
+		fail("users create -role user|admin")
+	}
+
+	user := &database.Users{FullName: strings.TrimSpace(*name), PlainPassword: *password}
+
+	if *email != "" {
+		normalized := strings.ToLower(strings.TrimSpace(*email))
+		user.Email = &normalized
+	}
+
+	if *phone != "" {
+		user.Phone = phone
+	}
+
+	if *password == "" || (user.Email == nil && user.Phone == nil) {
+		fail("users create needs -password and -email or -phone")
+	}
+
+	if err := validator.New().Struct(user); err != nil {
+		log.Fatalf("Invalid user: %s", err)
+	}
+
+	hash, err := authService.HashPassword(user.PlainPassword)
+
+	if err != nil {
+		log.Fatalf("Failed to hash password: %s", err)
+	}
+
+	now := time.Now()
+
+	user.Password = hash
+	user.PasswordChangedAt = &now
+	user.PlainPassword = ""
+
+	deps := open()
+	defer deps.Close()
+
+	if err := authService.Register(deps.DB, user, *role); err != nil {
+		log.Fatalf("Failed to create user: %s", err)
+	}
+
+	log.Printf("Created %s user %d", *role, user.ID)
+}
+
+func orders(args []string) {
+	if len(args) != 1 || args[0] != "expire" {
+		fail("orders expire")
+	}
+
+	deps := open()
+	defer deps.Close()
+
+	count, err := ordersService.Expire(helpers.WithDependencies(context.Background(), deps), deps.DB)
+
+	if err != nil {
+		log.Fatalf("Failed to expire orders: %s", err)
+	}
+
+	log.Printf("Expired %d orders", count)
+}
+
+// stocks imports an instrument listing file. It needs a header row with at
+// least ticker, company_name and board columns, sector, lot_size and
+// listing_status are optional.
+func stocks(args []string) {
+	if len(args) < 1 || args[0] != "import" {
+		fail("stocks import -file listing.csv")
+	}
+
+	flags := flag.NewFlagSet("stocks import", flag.ExitOnError)
+	file := flags.String("file", "", "path to the listing CSV file")
+	flags.Parse(args[1:])
+
+	if *file == "" {
+		fail("stocks import -file listing.csv")
+	}
+
+	f, err := os.Open(*file)
+
+	if err != nil {
+		log.Fatalf("Failed to open listing file: %s", err)
+	}
+
+	defer f.Close()
+
+	deps := open()
+	defer deps.Close()
+
+	count, err := stocksService.Import(helpers.WithDependencies(context.Background(), deps), deps.DB, f)
+
+	if err != nil {
+		log.Fatalf("Failed to import listing file: %s", err)
+	}
+
+	log.Printf("Imported %d stocks from %s", count, *file)
+}
+
+func export(args []string) {
+	if len(args) < 1 {
+		fail("export orders|users|histories [-out file.csv]")
+	}
+
+	resource := args[0]
+
+	if _, ok := exportsService.Models[resource]; !ok {
+		fail("export orders|users|histories [-out file.csv]")
+	}
+
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", "", "file to write, standard output when empty")
+	flags.Parse(args[1:])
+
+	var w io.Writer = os.Stdout
+
+	if *out != "" {
+		f, err := os.Create(*out)
+
+		if err != nil {
+			log.Fatalf("Failed to create %s: %s", *out, err)
+		}
+
+		defer f.Close()
+
+		w = f
+	}
+
+	deps := open()
+	defer deps.Close()
+
+	count, err := exportsService.CSV(context.Background(), deps.DB, resource, w)
+
+	if err != nil {
+		log.Fatalf("Failed to export %s: %s", resource, err)
+	}
+
+	log.Printf("Exported %d %s", count, resource)
+}
+
+func fail(usage string) {
+	fmt.Fprintf(os.Stderr, "usage: %s %s\n", os.Args[0], usage)
+	os.Exit(2)
+}
@@ -1,10 +1,426 @@
 package migrations
 
 import (
-	"sahamrakyat_test/database"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sahamrakyat_test/database"
+	"sahamrakyat_test/helpers"
 )
 
-func Migrate(db *gorm.DB) {
-	db.AutoMigrate(&database.Histories{}, &database.Orders{}, &database.Users{})
-}
\ No newline at end of file
+// Permissions every role can be granted, see database.Permissions.
+var permissions = map[string]string{
+	"users:read":        "Read own user",
+	"users:write":       "Update own user",
+	"users:manage":      "Create, read, update and delete any user and assign roles",
+	"orders:read":       "Read own orders",
+	"orders:write":      "Create, update and delete own orders",
+	"orders:manage":     "Read and modify orders of any user",
+	"histories:read":    "Read own history",
+	"histories:write":   "Create, update and delete histories",
+	"histories:manage":  "Read histories of any user",
+	"stocks:read":       "Read stocks",
+	"stocks:write":      "Create, update and delete stocks",
+	"trades:read":       "Read own trades",
+	"trades:manage":     "Read trades of any user",
+	"wallets:read":      "Read own wallet",
+	"wallets:manage":    "Read any wallet and deposit to or withdraw from it",
+	"portfolios:read":   "Read own portfolio",
+	"portfolios:manage": "Read portfolio of any user",
+	"api_keys:manage":   "Create, list and revoke API keys",
+	"status:read":       "Read the detailed operator status",
+}
+
+var rolePermissions = map[string][]string{
+	database.RoleUser: {
+		"users:read", "users:write",
+		"orders:read", "orders:write",
+		"histories:read",
+		"stocks:read",
+		"trades:read",
+		"wallets:read",
+		"portfolios:read",
+	},
+}
+
+// Dir is where the migrations live in the source tree, new ones are
+// created there. They are embedded into the binary, so a new migration
+// needs a rebuild.
+const Dir = "database/migrations/sql"
+
+//go:embed sql/*.sql
+var files embed.FS
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Key of the advisory lock held while migrating, so replicas starting at
+// the same time migrate one after the other.
+const lockID = 7256823001
+
+// Migration is a numbered schema change with the SQL applying and
+// reverting it.
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// SchemaMigrations records every migration applied to the database.
+type SchemaMigrations struct {
+	Version   uint      `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"size:255;notNull"`
+	AppliedAt time.Time `gorm:"notNull"`
+}
+
+// State describes how far the database schema is migrated.
+type State struct {
+	Version string   `json:"version"`
+	Pending []string `json:"pending"`
+}
+
+// Migrate applies the pending migrations and brings the built in roles in
+// line with the code.
+func Migrate(db *gorm.DB, config *helpers.Config) error {
+	_, err := Up(db, config.Auth.AdminEmail)
+	return err
+}
+
+// Load returns the embedded migrations ordered by version.
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[uint]*Migration{}
+
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+
+		if match == nil {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>.(up|down).sql", entry.Name())
+		}
+
+		version, _ := strconv.ParseUint(match[1], 10, 32)
+		content, err := files.ReadFile("sql/" + entry.Name())
+
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[uint(version)]
+
+		if !ok {
+			migration = &Migration{Version: uint(version), Name: match[2]}
+			byVersion[uint(version)] = migration
+		}
+
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %04d is named both %s and %s", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	all := []Migration{}
+
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %s has no up file", migration)
+		}
+
+		all = append(all, *migration)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Version < all[j].Version
+	})
+
+	return all, nil
+}
+
+// Up applies every pending migration in order, each in a transaction of its
+// own, then brings the built in roles in line with the code while still
+// holding the migration lock. It returns the migrations applied.
+//
+// A database auto migrated before migrations were versioned has part or all
+// of the schema of the first migration, which only creates what is missing
+// and so runs on it like on an empty one.
+func Up(db *gorm.DB, adminEmail string) ([]Migration, error) {
+	all, err := Load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []Migration{}
+
+	err = locked(db, func(conn *gorm.DB) error {
+		done, err := appliedVersions(conn)
+
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range all {
+			if done[migration.Version] {
+				continue
+			}
+
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.Up).Error; err != nil {
+					return err
+				}
+
+				return tx.Create(&SchemaMigrations{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+			})
+
+			if err != nil {
+				return fmt.Errorf("failed to apply migration %s: %w", migration, err)
+			}
+
+			applied = append(applied, migration)
+		}
+
+		return migrateRoles(conn, adminEmail)
+	})
+
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and
+// returns the ones reverted.
+func Down(db *gorm.DB, steps int) ([]Migration, error) {
+	all, err := Load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	reverted := []Migration{}
+
+	err = locked(db, func(conn *gorm.DB) error {
+		done, err := appliedVersions(conn)
+
+		if err != nil {
+			return err
+		}
+
+		for i := len(all) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := all[i]
+
+			if !done[migration.Version] {
+				continue
+			}
+
+			if migration.Down == "" {
+				return fmt.Errorf("migration %s has no down file", migration)
+			}
+
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.Down).Error; err != nil {
+					return err
+				}
+
+				return tx.Delete(&SchemaMigrations{}, migration.Version).Error
+			})
+
+			if err != nil {
+				return fmt.Errorf("failed to revert migration %s: %w", migration, err)
+			}
+
+			reverted = append(reverted, migration)
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status reports the version of the last migration applied and the
+// migrations still pending.
+func Status(db *gorm.DB) (*State, error) {
+	all, err := Load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	done := map[uint]bool{}
+
+	if db.Migrator().HasTable(&SchemaMigrations{}) {
+		if done, err = appliedVersions(db); err != nil {
+			return nil, err
+		}
+	}
+
+	state := &State{Version: "0000", Pending: []string{}}
+
+	for _, migration := range all {
+		if done[migration.Version] {
+			state.Version = fmt.Sprintf("%04d", migration.Version)
+		} else {
+			state.Pending = append(state.Pending, migration.String())
+		}
+	}
+
+	return state, nil
+}
+
+// Create writes empty up and down files for a new migration to dir,
+// numbered after the last one there, and returns their paths.
+func Create(dir string, name string) ([]string, error) {
+	name = strings.Trim(regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(name), "_"), "_")
+
+	if name == "" {
+		return nil, errors.New("migration name must contain letters or digits")
+	}
+
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var last uint64
+
+	for _, entry := range entries {
+		if match := fileName.FindStringSubmatch(entry.Name()); match != nil {
+			if version, _ := strconv.ParseUint(match[1], 10, 32); version > last {
+				last = version
+			}
+		}
+	}
+
+	paths := []string{}
+
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%04d_%s.%s.sql", last+1, name, direction))
+
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("-- %s: %s\n", name, direction)), 0644); err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// locked runs fn on a single connection holding the migration lock, with
+// the schema_migrations table in place.
+func locked(db *gorm.DB, fn func(conn *gorm.DB) error) error {
+	return db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", lockID).Error; err != nil {
+			return fmt.Errorf("failed to take the migration lock: %w", err)
+		}
+
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", lockID)
+
+		err := conn.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL, name varchar(255) NOT NULL, applied_at timestamptz NOT NULL, PRIMARY KEY (version))").Error
+
+		if err != nil {
+			return err
+		}
+
+		return fn(conn)
+	})
+}
+
+func appliedVersions(db *gorm.DB) (map[uint]bool, error) {
+	versions := []uint{}
+
+	if err := db.Model(&SchemaMigrations{}).Pluck("version", &versions).Error; err != nil {
+		return nil, err
+	}
+
+	done := map[uint]bool{}
+
+	for _, version := range versions {
+		done[version] = true
+	}
+
+	return done, nil
+}
+
+// migrateRoles keeps the permissions of the built in roles in line with the
+// code, admins get every permission. The user whose email is ADMIN_EMAIL is
+// made an admin so a fresh install has someone to manage it.
+func migrateRoles(db *gorm.DB, adminEmail string) error {
+	all := []database.Permissions{}
+
+	for name, description := range permissions {
+		all = append(all, database.Permissions{Name: name, Description: description})
+	}
+
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"description", "updated_at"}),
+	}).Create(&all).Error
+
+	if err != nil {
+		return err
+	}
+
+	if err := db.Find(&all).Error; err != nil {
+		return err
+	}
+
+	byName := map[string]database.Permissions{}
+
+	for _, permission := range all {
+		byName[permission.Name] = permission
+	}
+
+	for _, name := range []string{database.RoleAdmin, database.RoleUser} {
+		role := &database.Roles{Name: name}
+
+		if err := db.Where(role).FirstOrCreate(role).Error; err != nil {
+			return err
+		}
+
+		granted := all
+
+		if name != database.RoleAdmin {
+			granted = []database.Permissions{}
+
+			for _, permission := range rolePermissions[name] {
+				granted = append(granted, byName[permission])
+			}
+		}
+
+		if err := db.Model(role).Association("Permissions").Replace(granted); err != nil {
+			return err
+		}
+
+		if name == database.RoleAdmin && adminEmail != "" {
+			if err := db.Model(&database.Users{}).Where("email = ?", adminEmail).Update("roles_id", role.ID).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package migrations
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sahamrakyat_test/database"
+	"sort"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm/schema"
+)
+
+func TestLoadOrdersByVersion(t *testing.T) {
+	all, err := Load()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(all) == 0 || all[0].String() != "0001_initial" {
+		t.Fatalf("got %v, want the baseline first", all)
+	}
+
+	for i, migration := range all {
+		if i > 0 && migration.Version <= all[i-1].Version {
+			t.Errorf("%s comes after %s", migration, all[i-1])
+		}
+
+		if migration.Up == "" || migration.Down == "" {
+			t.Errorf("%s is missing its up or down SQL", migration)
+		}
+	}
+}
+
+// The baseline also runs on databases auto migrated before migrations were
+// versioned, so it may only add what is missing.
+func TestBaselineRunsOnAutoMigratedDatabases(t *testing.T) {
+	all, err := Load()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unguarded := regexp.MustCompile(`(?i)(CREATE (UNIQUE )?(TABLE|INDEX)|ADD COLUMN) (\w+)`)
+
+	for _, match := range unguarded.FindAllStringSubmatch(all[0].Up, -1) {
+		if match[4] != "IF" {
+			t.Errorf("%q fails when the database already has it, want IF NOT EXISTS", match[0])
+		}
+	}
+}
+
+func TestBaselineCoversTheSchema(t *testing.T) {
+	all, err := Load()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{}
+	cache := &sync.Map{}
+
+	for _, model := range []interface{}{
+		&database.Orders{}, &database.Users{}, &database.Histories{}, &database.Stocks{}, &database.Trades{},
+		&database.WalletTransactions{}, &database.WalletEntries{}, &database.Roles{}, &database.Permissions{}, &database.APIKeys{},
+	} {
+		parsed, err := schema.Parse(model, cache, schema.NamingStrategy{})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want = append(want, parsed.Table)
+
+		for _, relationship := range parsed.Relationships.Many2Many {
+			want = append(want, relationship.JoinTable.Table)
+		}
+	}
+
+	created := tables(`CREATE TABLE IF NOT EXISTS (\w+)`, all[0].Up)
+	dropped := tables(`DROP TABLE (\w+)`, all[0].Down)
+	sort.Strings(want)
+
+	if !reflect.DeepEqual(created, want) {
+		t.Errorf("the baseline creates %v, want %v", created, want)
+	}
+
+	if !reflect.DeepEqual(dropped, want) {
+		t.Errorf("the baseline drops %v, want %v", dropped, want)
+	}
+}
+
+// tables returns the sorted tables named by the first group of pattern.
+func tables(pattern string, sql string) []string {
+	names := []string{}
+
+	for _, match := range regexp.MustCompile(pattern).FindAllStringSubmatch(sql, -1) {
+		names = append(names, match[1])
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func TestCreateNumbersAfterTheLastMigration(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"0001_initial.up.sql", "0007_trades.up.sql", "README.md"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+
+	paths, err := Create(dir, "Add stock sectors!")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{filepath.Join(dir, "0008_add_stock_sectors.up.sql"), filepath.Join(dir, "0008_add_stock_sectors.down.sql")}
+
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("created %v, want %v", paths, want)
+	}
+
+	if _, err := Create(dir, "--"); err == nil {
+		t.Fatal("created a migration without a name")
+	}
+}
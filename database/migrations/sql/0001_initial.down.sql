@@ -0,0 +1,12 @@
+DROP TABLE api_key_permissions;
+DROP TABLE api_keys;
+DROP TABLE wallet_entries;
+DROP TABLE wallet_transactions;
+DROP TABLE trades;
+DROP TABLE users;
+DROP TABLE role_permissions;
+DROP TABLE roles;
+DROP TABLE permissions;
+DROP TABLE orders;
+DROP TABLE stocks;
+DROP TABLE histories;
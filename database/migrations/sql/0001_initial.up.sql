@@ -0,0 +1,205 @@
+-- Schema as it was auto migrated from the models before migrations were
+-- versioned. Every statement is a no-op on what already exists, so this also
+-- upgrades a database auto migrated by an earlier release, down to the first
+-- one that only had histories, orders and users.
+
+CREATE TABLE IF NOT EXISTS histories (
+    id bigserial NOT NULL,
+    descriptions varchar(255) NOT NULL,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS stocks (
+    id bigserial NOT NULL,
+    ticker varchar(16) NOT NULL,
+    company_name varchar(255) NOT NULL,
+    board varchar(32) NOT NULL,
+    sector varchar(64),
+    lot_size bigint NOT NULL DEFAULT 100,
+    listing_status varchar(16) NOT NULL DEFAULT 'listed',
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    PRIMARY KEY (id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_stocks_ticker ON stocks (ticker);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id bigserial NOT NULL,
+    name varchar(255) NOT NULL,
+    price bigint,
+    side varchar(4) NOT NULL DEFAULT 'buy',
+    quantity bigint NOT NULL DEFAULT 100,
+    filled_quantity bigint NOT NULL DEFAULT 0,
+    status varchar(16) NOT NULL DEFAULT 'open',
+    expired_at timestamptz,
+    stocks_id bigint DEFAULT NULL,
+    histories_id bigint DEFAULT NULL,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_histories_orders FOREIGN KEY (histories_id) REFERENCES histories (id),
+    CONSTRAINT fk_stocks_orders FOREIGN KEY (stocks_id) REFERENCES stocks (id)
+);
+
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS side varchar(4) NOT NULL DEFAULT 'buy',
+    ADD COLUMN IF NOT EXISTS quantity bigint NOT NULL DEFAULT 100,
+    ADD COLUMN IF NOT EXISTS filled_quantity bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS status varchar(16) NOT NULL DEFAULT 'open',
+    ADD COLUMN IF NOT EXISTS stocks_id bigint DEFAULT NULL;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_stocks_orders') THEN
+        ALTER TABLE orders ADD CONSTRAINT fk_stocks_orders FOREIGN KEY (stocks_id) REFERENCES stocks (id);
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_orders_status ON orders (status);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial NOT NULL,
+    name varchar(64) NOT NULL,
+    description varchar(255),
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_permissions_name ON permissions (name);
+
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial NOT NULL,
+    name varchar(64) NOT NULL,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_roles_name ON roles (name);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    roles_id bigint NOT NULL,
+    permissions_id bigint NOT NULL,
+    PRIMARY KEY (roles_id, permissions_id),
+    CONSTRAINT fk_role_permissions_permissions FOREIGN KEY (permissions_id) REFERENCES permissions (id),
+    CONSTRAINT fk_role_permissions_roles FOREIGN KEY (roles_id) REFERENCES roles (id)
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id bigserial NOT NULL,
+    full_name varchar(255) NOT NULL,
+    first_order boolean NOT NULL DEFAULT true,
+    email varchar(255),
+    phone varchar(32),
+    password varchar(255),
+    password_changed_at timestamptz,
+    failed_logins bigint NOT NULL DEFAULT 0,
+    locked_until timestamptz,
+    roles_id bigint DEFAULT NULL,
+    histories_id bigint DEFAULT NULL,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_histories_user FOREIGN KEY (histories_id) REFERENCES histories (id),
+    CONSTRAINT fk_roles_users FOREIGN KEY (roles_id) REFERENCES roles (id)
+);
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS email varchar(255),
+    ADD COLUMN IF NOT EXISTS phone varchar(32),
+    ADD COLUMN IF NOT EXISTS password varchar(255),
+    ADD COLUMN IF NOT EXISTS password_changed_at timestamptz,
+    ADD COLUMN IF NOT EXISTS failed_logins bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS locked_until timestamptz,
+    ADD COLUMN IF NOT EXISTS roles_id bigint DEFAULT NULL;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_roles_users') THEN
+        ALTER TABLE users ADD CONSTRAINT fk_roles_users FOREIGN KEY (roles_id) REFERENCES roles (id);
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_phone ON users (phone);
+
+CREATE TABLE IF NOT EXISTS trades (
+    id bigserial NOT NULL,
+    buy_order_id bigint NOT NULL,
+    sell_order_id bigint NOT NULL,
+    stocks_id bigint NOT NULL,
+    price bigint NOT NULL,
+    quantity bigint NOT NULL,
+    executed_at timestamptz NOT NULL,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_trades_stock FOREIGN KEY (stocks_id) REFERENCES stocks (id),
+    CONSTRAINT fk_trades_buy_order FOREIGN KEY (buy_order_id) REFERENCES orders (id),
+    CONSTRAINT fk_trades_sell_order FOREIGN KEY (sell_order_id) REFERENCES orders (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_trades_buy_order_id ON trades (buy_order_id);
+CREATE INDEX IF NOT EXISTS idx_trades_sell_order_id ON trades (sell_order_id);
+CREATE INDEX IF NOT EXISTS idx_trades_stocks_id ON trades (stocks_id);
+CREATE INDEX IF NOT EXISTS idx_trades_executed_at ON trades (executed_at);
+
+CREATE TABLE IF NOT EXISTS wallet_transactions (
+    id bigserial NOT NULL,
+    users_id bigint NOT NULL,
+    type varchar(16) NOT NULL,
+    amount bigint NOT NULL,
+    orders_id bigint DEFAULT NULL,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_wallet_transactions_user FOREIGN KEY (users_id) REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_wallet_transactions_users_id ON wallet_transactions (users_id);
+CREATE INDEX IF NOT EXISTS idx_wallet_transactions_orders_id ON wallet_transactions (orders_id);
+
+CREATE TABLE IF NOT EXISTS wallet_entries (
+    id bigserial NOT NULL,
+    wallet_transactions_id bigint NOT NULL,
+    users_id bigint NOT NULL,
+    account varchar(16) NOT NULL,
+    amount bigint NOT NULL,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_wallet_transactions_entries FOREIGN KEY (wallet_transactions_id) REFERENCES wallet_transactions (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_wallet_entries_wallet_transactions_id ON wallet_entries (wallet_transactions_id);
+CREATE INDEX IF NOT EXISTS idx_wallet_entries_account ON wallet_entries (users_id, account);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial NOT NULL,
+    name varchar(255) NOT NULL,
+    prefix varchar(16) NOT NULL,
+    hash varchar(64) NOT NULL,
+    rate_limit bigint NOT NULL DEFAULT 0,
+    expires_at timestamptz,
+    last_used_at timestamptz,
+    revoked_at timestamptz,
+    users_id bigint,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_hash ON api_keys (hash);
+
+CREATE TABLE IF NOT EXISTS api_key_permissions (
+    api_keys_id bigint NOT NULL,
+    permissions_id bigint NOT NULL,
+    PRIMARY KEY (api_keys_id, permissions_id),
+    CONSTRAINT fk_api_key_permissions_permissions FOREIGN KEY (permissions_id) REFERENCES permissions (id),
+    CONSTRAINT fk_api_key_permissions_api_keys FOREIGN KEY (api_keys_id) REFERENCES api_keys (id)
+);
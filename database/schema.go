@@ -7,24 +7,50 @@ import (
 )
 
 type Orders struct {
-	ID          uint           `gorm:"primaryKey;autoIncrement;notNull" json:"id" faker:"-"`
-	Name        string         `gorm:"size:255;notNull" json:"name" faker:"word" validate:"required"`
-	Price       uint           `json:"price" faker:"boundary_start=1, boundary_end=1000" validate:"required,min=1"`
-	ExpiredAt   time.Time      `json:"expired_at" faker:"-"`
-	HistoriesID *uint          `gorm:"default:null" faker:"-"`
-	CreatedAt   time.Time      `gorm:"autoCreateTime:milli" json:"created_at" faker:"-"`
-	UpdatedAt   time.Time      `gorm:"autoUpdateTime:milli" json:"updated_at" faker:"-"`
-	DeletedAt   gorm.DeletedAt `json:"deleted_at" faker:"-"`
+	ID             uint           `gorm:"primaryKey;autoIncrement;notNull" json:"id" faker:"-"`
+	Name           string         `gorm:"size:255;notNull" json:"name" faker:"word" validate:"required"`
+	Price          uint           `json:"price" faker:"boundary_start=1, boundary_end=1000" validate:"required,min=1"`
+	Side           string         `gorm:"size:4;default:buy;notNull" json:"side" faker:"oneof: buy, sell" validate:"omitempty,oneof=buy sell"`
+	Quantity       uint           `gorm:"default:100;notNull" json:"quantity" faker:"-" validate:"required,min=1"`
+	FilledQuantity uint           `gorm:"default:0;notNull" json:"filled_quantity" faker:"-"`
+	Status         string         `gorm:"size:16;default:open;notNull;index" json:"status" faker:"-" validate:"omitempty,oneof=open partial filled cancelled expired"`
+	ExpiredAt      time.Time      `json:"expired_at" faker:"-"`
+	StocksID       *uint          `gorm:"default:null" json:"stocks_id" faker:"-" validate:"required"`
+	HistoriesID    *uint          `gorm:"default:null" faker:"-"`
+	CreatedAt      time.Time      `gorm:"autoCreateTime:milli" json:"created_at" faker:"-"`
+	UpdatedAt      time.Time      `gorm:"autoUpdateTime:milli" json:"updated_at" faker:"-"`
+	DeletedAt      gorm.DeletedAt `json:"deleted_at" faker:"-"`
 }
 
+const (
+	OrderSideBuy  = "buy"
+	OrderSideSell = "sell"
+)
+
+const (
+	OrderStatusOpen      = "open"
+	OrderStatusPartial   = "partial"
+	OrderStatusFilled    = "filled"
+	OrderStatusCancelled = "cancelled"
+	OrderStatusExpired   = "expired"
+)
+
 type Users struct {
-	ID          uint           `gorm:"primaryKey;autoIncrement;notNull" json:"id" faker:"-"`
-	FullName    string         `gorm:"size:255;notNull" json:"full_name" faker:"name" validate:"required"`
-	FirstOrder  bool           `gorm:"default:true;notNull" json:"first_order"` // what is this? for now, I assume this is checking if user is first time ordering
-	HistoriesID *uint          `gorm:"default:null" faker:"-"`
-	CreatedAt   time.Time      `gorm:"autoCreateTime:milli" json:"created_at" faker:"-"`
-	UpdatedAt   time.Time      `gorm:"autoUpdateTime:milli" json:"updated_at" faker:"-"`
-	DeletedAt   gorm.DeletedAt `json:"deleted_at" faker:"-"`
+	ID                uint           `gorm:"primaryKey;autoIncrement;notNull" json:"id" faker:"-"`
+	FullName          string         `gorm:"size:255;notNull" json:"full_name" faker:"name" validate:"required"`
+	FirstOrder        bool           `gorm:"default:true;notNull" json:"first_order"` // what is this? for now, I assume this is checking if user is first time ordering
+	Email             *string        `gorm:"size:255;uniqueIndex" json:"email" faker:"-" validate:"omitempty,email,max=255"`
+	Phone             *string        `gorm:"size:32;uniqueIndex" json:"phone" faker:"-" validate:"omitempty,e164"`
+	Password          string         `gorm:"size:255" json:"-" faker:"-"`                                             // bcrypt hash, never serialized
+	PlainPassword     string         `gorm:"-" json:"password,omitempty" faker:"-" validate:"omitempty,min=8,max=72"` // only read from requests, hashed into Password
+	PasswordChangedAt *time.Time     `json:"-" faker:"-"`
+	FailedLogins      uint           `gorm:"default:0;notNull" json:"-" faker:"-"`
+	LockedUntil       *time.Time     `json:"-" faker:"-"`
+	RolesID           *uint          `gorm:"default:null" json:"roles_id" faker:"-"`
+	HistoriesID       *uint          `gorm:"default:null" faker:"-"`
+	CreatedAt         time.Time      `gorm:"autoCreateTime:milli" json:"created_at" faker:"-"`
+	UpdatedAt         time.Time      `gorm:"autoUpdateTime:milli" json:"updated_at" faker:"-"`
+	DeletedAt         gorm.DeletedAt `json:"deleted_at" faker:"-"`
 }
 
 type Histories struct {
@@ -36,3 +62,119 @@ type Histories struct {
 	UpdatedAt    time.Time      `gorm:"autoUpdateTime:milli" json:"updated_at" faker:"-"`
 	DeletedAt    gorm.DeletedAt `json:"deleted_at" faker:"-"`
 }
+
+const (
+	ListingStatusListed    = "listed"
+	ListingStatusSuspended = "suspended"
+	ListingStatusDelisted  = "delisted"
+)
+
+// DefaultLotSize is the lot size of a stock that is created without one.
+const DefaultLotSize = 100
+
+type Stocks struct {
+	ID            uint           `gorm:"primaryKey;autoIncrement;notNull" json:"id" faker:"-"`
+	Ticker        string         `gorm:"size:16;uniqueIndex;notNull" json:"ticker" faker:"-" validate:"required,alphanum,uppercase,max=16"`
+	CompanyName   string         `gorm:"size:255;notNull" json:"company_name" faker:"-" validate:"required"`
+	Board         string         `gorm:"size:32;notNull" json:"board" faker:"-" validate:"required,oneof=main development acceleration new_economy watchlist"`
+	Sector        string         `gorm:"size:64" json:"sector" faker:"-"`
+	LotSize       uint           `gorm:"default:100;notNull" json:"lot_size" faker:"-" validate:"min=1"`
+	ListingStatus string         `gorm:"size:16;default:listed;notNull" json:"listing_status" faker:"-" validate:"omitempty,oneof=listed suspended delisted"`
+	Orders        []Orders       `gorm:"foreignKey:StocksID" json:"-" faker:"-"`
+	CreatedAt     time.Time      `gorm:"autoCreateTime:milli" json:"created_at" faker:"-"`
+	UpdatedAt     time.Time      `gorm:"autoUpdateTime:milli" json:"updated_at" faker:"-"`
+	DeletedAt     gorm.DeletedAt `json:"deleted_at" faker:"-"`
+}
+
+type Trades struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement;notNull" json:"id"`
+	BuyOrderID  uint      `gorm:"notNull;index" json:"buy_order_id"`
+	BuyOrder    *Orders   `gorm:"foreignKey:BuyOrderID" json:"buy_order,omitempty"`
+	SellOrderID uint      `gorm:"notNull;index" json:"sell_order_id"`
+	SellOrder   *Orders   `gorm:"foreignKey:SellOrderID" json:"sell_order,omitempty"`
+	StocksID    uint      `gorm:"notNull;index" json:"stocks_id"`
+	Stock       *Stocks   `gorm:"foreignKey:StocksID" json:"stock,omitempty"`
+	Price       uint      `gorm:"notNull" json:"price"`
+	Quantity    uint      `gorm:"notNull" json:"quantity"`
+	ExecutedAt  time.Time `gorm:"notNull;index" json:"executed_at"`
+	CreatedAt   time.Time `gorm:"autoCreateTime:milli" json:"created_at"`
+}
+
+const (
+	WalletAccountAvailable = "available"
+	WalletAccountHeld      = "held"
+	WalletAccountExternal  = "external"
+)
+
+const (
+	WalletTransactionDeposit    = "deposit"
+	WalletTransactionWithdrawal = "withdrawal"
+	WalletTransactionHold       = "hold"
+	WalletTransactionRelease    = "release"
+	WalletTransactionSettlement = "settlement"
+)
+
+// WalletTransactions groups the double-entry rows of a single cash movement,
+// the amounts of its entries always add up to zero.
+type WalletTransactions struct {
+	ID        uint            `gorm:"primaryKey;autoIncrement;notNull" json:"id"`
+	UsersID   uint            `gorm:"notNull;index" json:"users_id"`
+	User      *Users          `gorm:"foreignKey:UsersID" json:"-"`
+	Type      string          `gorm:"size:16;notNull" json:"type"`
+	Amount    int64           `gorm:"notNull" json:"amount"`
+	OrdersID  *uint           `gorm:"default:null;index" json:"orders_id"`
+	Entries   []WalletEntries `gorm:"foreignKey:WalletTransactionsID" json:"entries,omitempty"`
+	CreatedAt time.Time       `gorm:"autoCreateTime:milli" json:"created_at"`
+}
+
+type WalletEntries struct {
+	ID                   uint      `gorm:"primaryKey;autoIncrement;notNull" json:"id"`
+	WalletTransactionsID uint      `gorm:"notNull;index" json:"wallet_transactions_id"`
+	UsersID              uint      `gorm:"notNull;index:idx_wallet_entries_account" json:"users_id"`
+	Account              string    `gorm:"size:16;notNull;index:idx_wallet_entries_account" json:"account"`
+	Amount               int64     `gorm:"notNull" json:"amount"`
+	CreatedAt            time.Time `gorm:"autoCreateTime:milli" json:"created_at"`
+}
+
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+type Roles struct {
+	ID          uint          `gorm:"primaryKey;autoIncrement;notNull" json:"id"`
+	Name        string        `gorm:"size:64;uniqueIndex;notNull" json:"name"`
+	Permissions []Permissions `gorm:"many2many:role_permissions" json:"permissions,omitempty"`
+	Users       []Users       `gorm:"foreignKey:RolesID" json:"-"`
+	CreatedAt   time.Time     `gorm:"autoCreateTime:milli" json:"created_at"`
+	UpdatedAt   time.Time     `gorm:"autoUpdateTime:milli" json:"updated_at"`
+}
+
+// Permissions are named "<resource>:<action>". Read and write only cover
+// the caller's own records, manage extends them to everyone's.
+type Permissions struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement;notNull" json:"id"`
+	Name        string    `gorm:"size:64;uniqueIndex;notNull" json:"name"`
+	Description string    `gorm:"size:255" json:"description"`
+	CreatedAt   time.Time `gorm:"autoCreateTime:milli" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime:milli" json:"updated_at"`
+}
+
+// APIKeys authenticate clients that act without a user, such as back-office
+// jobs. Only the SHA-256 hash of a key is stored, the key itself is shown
+// once on creation. Scopes are permission names, see Permissions.
+type APIKeys struct {
+	ID          uint          `gorm:"primaryKey;autoIncrement;notNull" json:"id"`
+	Name        string        `gorm:"size:255;notNull" json:"name" validate:"required,max=255"`
+	Prefix      string        `gorm:"size:16;notNull" json:"prefix"`
+	Hash        string        `gorm:"size:64;uniqueIndex;notNull" json:"-"`
+	Permissions []Permissions `gorm:"many2many:api_key_permissions" json:"-"`
+	Scopes      []string      `gorm:"-" json:"scopes" validate:"required,min=1"`
+	RateLimit   uint          `gorm:"notNull;default:0" json:"rate_limit"`
+	ExpiresAt   *time.Time    `json:"expires_at"`
+	LastUsedAt  *time.Time    `json:"last_used_at"`
+	RevokedAt   *time.Time    `json:"revoked_at"`
+	UsersID     *uint         `json:"users_id"`
+	CreatedAt   time.Time     `gorm:"autoCreateTime:milli" json:"created_at"`
+	UpdatedAt   time.Time     `gorm:"autoUpdateTime:milli" json:"updated_at"`
+}
@@ -3,6 +3,11 @@ module sahamrakyat_test/database/seeds
 go 1.19
 
 require (
-	github.com/go-faker/faker/v4 v4.1.0 // indirect
-	golang.org/x/text v0.9.0 // indirect
+	golang.org/x/crypto v0.9.0
+	gorm.io/gorm v1.25.1
+)
+
+require (
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
 )
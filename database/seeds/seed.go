@@ -1,68 +1,437 @@
 package seeds
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"sahamrakyat_test/database"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
 
-	"github.com/go-faker/faker/v4"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sahamrakyat_test/database"
 )
 
-func Seed(db *gorm.DB) {
-	// Down
-	db.Exec("TRUNCATE TABLE histories RESTART IDENTITY CASCADE;")
-	db.Exec("TRUNCATE TABLE orders RESTART IDENTITY CASCADE;")
-	db.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+// Profile is how much data a seed run creates.
+type Profile struct {
+	Stocks        int
+	Users         int
+	OrdersPerUser int
+}
+
+// Profiles by name, load-test creates a few million orders.
+var Profiles = map[string]Profile{
+	"small":     {Stocks: 20, Users: 10, OrdersPerUser: 10},
+	"medium":    {Stocks: 100, Users: 1000, OrdersPerUser: 20},
+	"load-test": {Stocks: 500, Users: 100000, OrdersPerUser: 25},
+}
 
-	// Up
-	// Histories
-	var histories = []database.Histories{}
+// Options of a seed run. The same profile and seed always produce the same
+// data.
+type Options struct {
+	Profile  string
+	Seed     int64
+	Truncate bool
+	// Env is APP_ENV, truncating is refused in production.
+	Env string
+}
 
-	for i := 0; i < 10; i++ {
-		historyInterface := database.Histories{}
-		err := faker.FakeData(&historyInterface)
+// Report counts the rows a seed run created.
+type Report struct {
+	Stocks int
+	Users  int
+	Orders int
+}
 
-		if err != nil {
-			fmt.Println(err)
+// Password of every seeded user.
+const Password = "password123"
+
+const batchSize = 1000
+
+var ErrProduction = errors.New("refusing to truncate tables in production")
+
+// Tables emptied by a truncating run, roles and permissions are left alone.
+var tables = []string{"api_key_permissions", "api_keys", "wallet_entries", "wallet_transactions", "trades", "orders", "users", "histories", "stocks"}
+
+// Well known tickers of the Indonesia Stock Exchange with a typical price,
+// seeded first. Larger profiles add made up tickers.
+var listings = []struct {
+	ticker, company, sector string
+	price                   uint
+}{
+	{"BBCA", "Bank Central Asia Tbk.", "Financials", 9000},
+	{"BBRI", "Bank Rakyat Indonesia (Persero) Tbk.", "Financials", 5400},
+	{"BMRI", "Bank Mandiri (Persero) Tbk.", "Financials", 6000},
+	{"BBNI", "Bank Negara Indonesia (Persero) Tbk.", "Financials", 4800},
+	{"TLKM", "Telkom Indonesia (Persero) Tbk.", "Infrastructures", 3800},
+	{"ASII", "Astra International Tbk.", "Industrials", 6500},
+	{"UNVR", "Unilever Indonesia Tbk.", "Consumer Non-Cyclicals", 3500},
+	{"ICBP", "Indofood CBP Sukses Makmur Tbk.", "Consumer Non-Cyclicals", 10500},
+	{"INDF", "Indofood Sukses Makmur Tbk.", "Consumer Non-Cyclicals", 6700},
+	{"GOTO", "GoTo Gojek Tokopedia Tbk.", "Technology", 90},
+	{"ANTM", "Aneka Tambang Tbk.", "Basic Materials", 1700},
+	{"ADRO", "Adaro Energy Indonesia Tbk.", "Energy", 2500},
+	{"PTBA", "Bukit Asam Tbk.", "Energy", 2800},
+	{"PGAS", "Perusahaan Gas Negara Tbk.", "Energy", 1400},
+	{"KLBF", "Kalbe Farma Tbk.", "Healthcare", 1600},
+	{"CPIN", "Charoen Pokphand Indonesia Tbk.", "Consumer Non-Cyclicals", 5000},
+	{"SMGR", "Semen Indonesia (Persero) Tbk.", "Basic Materials", 6400},
+	{"INCO", "Vale Indonesia Tbk.", "Basic Materials", 5900},
+	{"MDKA", "Merdeka Copper Gold Tbk.", "Basic Materials", 2900},
+	{"EXCL", "XL Axiata Tbk.", "Infrastructures", 2200},
+	{"ISAT", "Indosat Tbk.", "Infrastructures", 9500},
+	{"UNTR", "United Tractors Tbk.", "Industrials", 24000},
+	{"JSMR", "Jasa Marga (Persero) Tbk.", "Infrastructures", 4300},
+	{"BRIS", "Bank Syariah Indonesia Tbk.", "Financials", 1700},
+	{"ARTO", "Bank Jago Tbk.", "Financials", 2800},
+	{"BUKA", "Bukalapak.com Tbk.", "Technology", 200},
+	{"EMTK", "Elang Mahkota Teknologi Tbk.", "Technology", 600},
+	{"MEDC", "Medco Energi Internasional Tbk.", "Energy", 1200},
+	{"HMSP", "H.M. Sampoerna Tbk.", "Consumer Non-Cyclicals", 900},
+	{"GGRM", "Gudang Garam Tbk.", "Consumer Non-Cyclicals", 24000},
+}
+
+var sectors = []string{"Financials", "Energy", "Basic Materials", "Industrials", "Consumer Non-Cyclicals", "Consumer Cyclicals", "Healthcare", "Properties & Real Estate", "Technology", "Infrastructures", "Transportation & Logistic"}
+
+var firstNames = []string{"Budi", "Siti", "Agus", "Dewi", "Andi", "Rina", "Joko", "Sri", "Rizky", "Putri", "Hendra", "Ayu", "Fajar", "Intan", "Bayu", "Nur", "Dimas", "Lestari", "Eko", "Wulan"}
+
+var lastNames = []string{"Santoso", "Wijaya", "Saputra", "Hidayat", "Pratama", "Kusuma", "Nugroho", "Setiawan", "Siregar", "Lubis", "Harahap", "Simanjuntak", "Wibowo", "Gunawan", "Halim", "Tanoto", "Sembiring", "Situmorang", "Rahman", "Utomo"}
+
+// Seed fills the database with made up stocks, users with a history each,
+// and their orders. Tables are only emptied first when asked to, and never
+// in production. A database seeded with the same seed before has to be
+// truncated, emails would collide.
+func Seed(ctx context.Context, db *gorm.DB, options Options) (*Report, error) {
+	profile, ok := Profiles[options.Profile]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown seed profile %s", options.Profile)
+	}
+
+	db = db.WithContext(ctx)
+
+	if options.Truncate {
+		if options.Env == "production" {
+			return nil, ErrProduction
 		}
 
-		histories = append(histories, historyInterface)
+		if err := db.Exec(fmt.Sprintf("TRUNCATE TABLE %s RESTART IDENTITY CASCADE", strings.Join(tables, ", "))).Error; err != nil {
+			return nil, err
+		}
 	}
 
-	db.CreateInBatches(histories, len(histories))
+	random := rand.New(rand.NewSource(options.Seed))
+	report := &Report{}
 
-	// Users
-	var users = []database.Users{}
-	for i := 0; i < 10; i++ {
-		idx := uint(i) + 1
-		userInterface := database.Users{HistoriesID: &idx}
-		err := faker.FakeData(&userInterface)
+	var seeded int64
 
-		if err != nil {
-			fmt.Println(err)
+	if err := db.Model(&database.Users{}).Where("email = ?", email(options.Seed, 0)).Count(&seeded).Error; err != nil {
+		return nil, err
+	}
+
+	if seeded > 0 {
+		return nil, fmt.Errorf("the database was already seeded with seed %d, truncate it or use another seed", options.Seed)
+	}
+
+	stocks, err := seedStocks(db, random, profile.Stocks)
+
+	if err != nil {
+		return nil, err
+	}
+
+	report.Stocks = len(stocks)
+
+	password, err := bcrypt.GenerateFromPassword([]byte(Password), bcrypt.DefaultCost)
+
+	if err != nil {
+		return nil, err
+	}
+
+	role := &database.Roles{}
+
+	if err := db.Where("name = ?", database.RoleUser).Limit(1).Find(role).Error; err != nil {
+		return nil, err
+	}
+
+	// Users are created a batch at a time with their histories and orders, so
+	// large profiles never hold more than a batch in memory.
+	for start := 0; start < profile.Users; start += batchSize {
+		end := start + batchSize
+
+		if end > profile.Users {
+			end = profile.Users
 		}
 
-		users = append(users, userInterface)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			histories := make([]database.Histories, end-start)
+
+			for i := range histories {
+				histories[i].Descriptions = "Account created."
+			}
+
+			if err := tx.CreateInBatches(histories, batchSize).Error; err != nil {
+				return fmt.Errorf("failed to seed histories: %w", err)
+			}
+
+			users := make([]database.Users, end-start)
+			now := time.Now()
+
+			for i := range users {
+				users[i] = database.Users{
+					FullName:          firstNames[random.Intn(len(firstNames))] + " " + lastNames[random.Intn(len(lastNames))],
+					FirstOrder:        true,
+					Email:             pointer(email(options.Seed, start+i)),
+					Password:          string(password),
+					PasswordChangedAt: &now,
+					HistoriesID:       &histories[i].ID,
+				}
+
+				if role.ID != 0 {
+					users[i].RolesID = &role.ID
+				}
+			}
+
+			if err := tx.CreateInBatches(users, batchSize).Error; err != nil {
+				return fmt.Errorf("failed to seed users: %w", err)
+			}
+
+			orders := []database.Orders{}
+
+			for i := range users {
+				for j := 0; j < profile.OrdersPerUser; j++ {
+					orders = append(orders, order(random, &stocks[random.Intn(len(stocks))], &histories[i].ID, now))
+				}
+			}
+
+			if err := tx.CreateInBatches(orders, batchSize).Error; err != nil {
+				return fmt.Errorf("failed to seed orders: %w", err)
+			}
+
+			if err := fund(tx, users, orders); err != nil {
+				return fmt.Errorf("failed to seed wallets: %w", err)
+			}
+
+			report.Users += len(users)
+			report.Orders += len(orders)
+
+			return nil
+		})
+
+		if err != nil {
+			return report, err
+		}
 	}
 
-	db.CreateInBatches(users, len(users))
+	return report, nil
+}
+
+// seedStocks creates the listings, made up ones after the real ones, and
+// returns them with the price orders are placed around.
+func seedStocks(db *gorm.DB, random *rand.Rand, count int) ([]seededStock, error) {
+	stocks := []database.Stocks{}
+	prices := map[string]uint{}
+	taken := map[string]bool{}
 
-	// Orders
-	// Note: Seeding data more than 10 data in batches is slower than seeding 10 data in 2 or more batches
-	for j := 0; j < 2; j++ {
-		var orders = []database.Orders{}
-		for i := 0; i < 10; i++ {
-			idx := uint(i) + 1
-			orderInterface := database.Orders{HistoriesID: &idx}
-			err := faker.FakeData(&orderInterface)
+	for i := 0; i < count; i++ {
+		stock := database.Stocks{Board: "main", LotSize: 100, ListingStatus: database.ListingStatusListed}
 
-			if err != nil {
-				fmt.Println(err)
+		if i < len(listings) {
+			listing := listings[i]
+
+			stock.Ticker, stock.CompanyName, stock.Sector = listing.ticker, listing.company, listing.sector
+			prices[stock.Ticker] = listing.price
+		} else {
+			for stock.Ticker == "" || taken[stock.Ticker] {
+				stock.Ticker = ticker(random)
 			}
 
-			orders = append(orders, orderInterface)
+			stock.CompanyName = fmt.Sprintf("%s %s Tbk.", lastNames[random.Intn(len(lastNames))], []string{"Makmur", "Sejahtera", "Abadi", "Nusantara", "Persada", "Mandiri"}[random.Intn(6)])
+			stock.Sector = sectors[random.Intn(len(sectors))]
+			stock.Board = []string{"main", "development", "acceleration"}[random.Intn(3)]
+			prices[stock.Ticker] = tick(uint(50 + random.Intn(15000)))
 		}
 
-		db.CreateInBatches(orders, len(orders))
+		taken[stock.Ticker] = true
+		stocks = append(stocks, stock)
 	}
+
+	// Listings already there, such as imported ones, are kept.
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(stocks, batchSize).Error; err != nil {
+		return nil, fmt.Errorf("failed to seed stocks: %w", err)
+	}
+
+	tickers := make([]string, 0, len(stocks))
+
+	for _, stock := range stocks {
+		tickers = append(tickers, stock.Ticker)
+	}
+
+	if err := db.Where("ticker IN ?", tickers).Find(&stocks).Error; err != nil {
+		return nil, err
+	}
+
+	sort.Slice(stocks, func(i, j int) bool {
+		return stocks[i].Ticker < stocks[j].Ticker
+	})
+
+	seeded := make([]seededStock, len(stocks))
+
+	for i, stock := range stocks {
+		seeded[i] = seededStock{Stocks: stock, price: prices[stock.Ticker]}
+	}
+
+	return seeded, nil
+}
+
+// seededStock is a listing with its typical price.
+type seededStock struct {
+	database.Stocks
+	price uint
+}
+
+// order places a limit order within 5% of the stock's price. Most are
+// still open and expire within a month, the rest were filled, cancelled or
+// expired in the past. Seeded users hold no shares, so open orders are all
+// buys, funded by fund.
+func order(random *rand.Rand, stock *seededStock, historiesID *uint, now time.Time) database.Orders {
+	side := database.OrderSideBuy
+
+	if random.Intn(2) == 0 {
+		side = database.OrderSideSell
+	}
+
+	lots := uint(1 + random.Intn(50))
+	price := tick(uint(float64(stock.price) * (0.95 + random.Float64()*0.1)))
+
+	order := database.Orders{
+		Price:       price,
+		Quantity:    lots * stock.LotSize,
+		Status:      database.OrderStatusOpen,
+		StocksID:    &stock.ID,
+		HistoriesID: historiesID,
+	}
+
+	// Orders expire at the close of the trading day, 16:00 Jakarta time.
+	day := now.AddDate(0, 0, 1+random.Intn(30))
+	order.ExpiredAt = time.Date(day.Year(), day.Month(), day.Day(), 9, 0, 0, 0, time.UTC)
+
+	switch roll := random.Intn(10); {
+	case roll < 6:
+		side = database.OrderSideBuy
+	case roll < 8:
+		order.Status = database.OrderStatusFilled
+		order.FilledQuantity = order.Quantity
+	case roll < 9:
+		order.Status = database.OrderStatusCancelled
+	default:
+		order.Status = database.OrderStatusExpired
+		order.ExpiredAt = order.ExpiredAt.AddDate(0, 0, -31)
+	}
+
+	order.Side = side
+	order.Name = fmt.Sprintf("%s %s %d lot", strings.ToUpper(side), stock.Ticker, lots)
+
+	return order
+}
+
+// fund deposits what the open buy orders of every user cost and holds it
+// for each order, as placing them through the API would have, so their
+// fills are paid out of held funds.
+func fund(tx *gorm.DB, users []database.Users, orders []database.Orders) error {
+	owners := map[uint]uint{}
+
+	for _, user := range users {
+		owners[*user.HistoriesID] = user.ID
+	}
+
+	deposits := map[uint]int64{}
+	holds := []database.WalletTransactions{}
+
+	for i := range orders {
+		order := &orders[i]
+
+		if order.Side != database.OrderSideBuy || order.Status != database.OrderStatusOpen {
+			continue
+		}
+
+		usersID := owners[*order.HistoriesID]
+		amount := int64(order.Price) * int64(order.Quantity)
+
+		deposits[usersID] += amount
+		holds = append(holds, movement(usersID, &order.ID, database.WalletTransactionHold, amount, database.WalletAccountAvailable, database.WalletAccountHeld))
+	}
+
+	transactions := []database.WalletTransactions{}
+
+	for _, user := range users {
+		if amount := deposits[user.ID]; amount > 0 {
+			transactions = append(transactions, movement(user.ID, nil, database.WalletTransactionDeposit, amount, database.WalletAccountExternal, database.WalletAccountAvailable))
+		}
+	}
+
+	transactions = append(transactions, holds...)
+
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	return tx.CreateInBatches(transactions, batchSize).Error
+}
+
+// movement is a wallet transaction moving amount between two accounts of a
+// user, like the wallets service posts them.
+func movement(usersID uint, ordersID *uint, kind string, amount int64, from string, to string) database.WalletTransactions {
+	return database.WalletTransactions{
+		UsersID:  usersID,
+		Type:     kind,
+		Amount:   amount,
+		OrdersID: ordersID,
+		Entries: []database.WalletEntries{
+			{UsersID: usersID, Account: from, Amount: -amount},
+			{UsersID: usersID, Account: to, Amount: amount},
+		},
+	}
+}
+
+// tick rounds a price down to the tick size of its IDX price band.
+func tick(price uint) uint {
+	size := uint(25)
+
+	switch {
+	case price < 200:
+		size = 1
+	case price < 500:
+		size = 2
+	case price < 2000:
+		size = 5
+	case price < 5000:
+		size = 10
+	}
+
+	if price < size {
+		return size
+	}
+
+	return price - price%size
+}
+
+func ticker(random *rand.Rand) string {
+	letters := make([]byte, 4)
+
+	for i := range letters {
+		letters[i] = byte('A' + random.Intn(26))
+	}
+
+	return string(letters)
+}
+
+func email(seed int64, i int) string {
+	return fmt.Sprintf("seed%d.user%d@example.com", seed, i+1)
+}
+
+func pointer(value string) *string {
+	return &value
 }
@@ -2,7 +2,11 @@ module sahamrakyat_test
 
 go 1.19
 
-require github.com/labstack/echo/v4 v4.10.2
+require (
+	github.com/go-playground/validator v9.31.0+incompatible
+	github.com/labstack/echo/v4 v4.10.2
+	google.golang.org/grpc v1.55.0
+)
 
 require (
 	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
@@ -0,0 +1,111 @@
+package helpers
+
+import (
+	"fmt"
+	"net"
+	"sahamrakyat_test/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+const currentUserKey = "currentUser"
+
+// SetCurrentUser stores the user a request was authenticated as.
+func SetCurrentUser(c echo.Context, user *database.Users) {
+	c.Set(currentUserKey, user)
+}
+
+// CurrentUser returns the user a request was authenticated as, nil for
+// public routes.
+func CurrentUser(c echo.Context) *database.Users {
+	user, _ := c.Get(currentUserKey).(*database.Users)
+	return user
+}
+
+const currentAPIKeyKey = "currentAPIKey"
+
+// SetCurrentAPIKey stores the API key a request was authenticated with.
+func SetCurrentAPIKey(c echo.Context, key *database.APIKeys) {
+	c.Set(currentAPIKeyKey, key)
+}
+
+// CurrentAPIKey returns the API key a request was authenticated with, nil
+// for requests made with a user token.
+func CurrentAPIKey(c echo.Context) *database.APIKeys {
+	key, _ := c.Get(currentAPIKeyKey).(*database.APIKeys)
+	return key
+}
+
+const permissionsKey = "permissions"
+
+// SetPermissions stores the permissions granted to the current user.
+func SetPermissions(c echo.Context, permissions []string) {
+	granted := map[string]bool{}
+
+	for _, permission := range permissions {
+		granted[permission] = true
+	}
+
+	c.Set(permissionsKey, granted)
+}
+
+// Can reports whether the current user was granted the permission.
+func Can(c echo.Context, permission string) bool {
+	granted, _ := c.Get(permissionsKey).(map[string]bool)
+	return granted[permission]
+}
+
+// OwnsUser reports whether the current user may act on records of the user
+// with the given id, either because it is themselves or because they hold
+// the manage permission.
+func OwnsUser(c echo.Context, userID uint, manage string) bool {
+	if Can(c, manage) {
+		return true
+	}
+
+	user := CurrentUser(c)
+
+	return user != nil && user.ID == userID
+}
+
+// ClientIdentity names the client of a request for per client state such as
+// rate limits: its API key, its user, or its IP address for anonymous ones.
+func ClientIdentity(c echo.Context) string {
+	return Identity(CurrentAPIKey(c), CurrentUser(c), c.RealIP())
+}
+
+// Identity names a client the way ClientIdentity does, for transports other
+// than HTTP.
+func Identity(apiKey *database.APIKeys, user *database.Users, ip string) string {
+	if apiKey != nil {
+		return fmt.Sprintf("apikey:%d", apiKey.ID)
+	}
+
+	if user != nil {
+		return fmt.Sprintf("user:%d", user.ID)
+	}
+
+	return "ip:" + ip
+}
+
+// IPExtractor reads the address of the client from the connection, or from
+// X-Forwarded-For for requests coming through one of the trusted proxies.
+// Every other proxy header is ignored, so clients cannot pick the address
+// their rate limits are counted under.
+func IPExtractor(config AppConfig) echo.IPExtractor {
+	proxies := config.Proxies()
+
+	if len(proxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+
+	for _, proxy := range proxies {
+		if _, ipNet, err := net.ParseCIDR(proxy); err == nil {
+			options = append(options, echo.TrustIPRange(ipNet))
+		}
+	}
+
+	return echo.ExtractIPFromXFFHeader(options...)
+}
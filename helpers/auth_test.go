@@ -0,0 +1,31 @@
+package helpers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPExtractor(t *testing.T) {
+	cases := []struct {
+		name    string
+		proxies string
+		remote  string
+		want    string
+	}{
+		{name: "no proxies ignore the header", proxies: "", remote: "10.0.0.5", want: "10.0.0.5"},
+		{name: "untrusted proxies are ignored", proxies: "10.1.0.0/16", remote: "10.0.0.5", want: "10.0.0.5"},
+		{name: "trusted proxies are believed", proxies: "10.1.0.0/16, 10.0.0.0/24", remote: "10.0.0.5", want: "203.0.113.7"},
+	}
+
+	for _, c := range cases {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = c.remote + ":1234"
+		request.Header.Set("X-Forwarded-For", "203.0.113.7")
+		request.Header.Set("X-Real-IP", "198.51.100.9")
+
+		if got := IPExtractor(AppConfig{TrustedProxies: c.proxies})(request); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+}
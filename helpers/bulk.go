@@ -0,0 +1,267 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-playground/validator"
+	"github.com/labstack/echo/v4"
+)
+
+// Modes of bulk requests. Atomic writes every item or none of them, best
+// effort writes every item it can and reports the others.
+const (
+	BulkAtomic     = "atomic"
+	BulkBestEffort = "best_effort"
+)
+
+// MaxBulkItems bounds the number of items of one bulk request.
+const MaxBulkItems = 1000
+
+// BulkChunk is how many items are written together, the batch size of
+// CreateInBatches and the unit of retries in best effort mode.
+const BulkChunk = 100
+
+// BulkRequest is the body of the bulk endpoints. Items are kept raw so each
+// one is decoded, validated and reported on its own, deletes send IDs
+// instead.
+type BulkRequest struct {
+	Mode  string            `json:"mode"`
+	Items []json.RawMessage `json:"items"`
+	IDs   []uint            `json:"ids"`
+}
+
+// Len is the number of items or ids the request holds.
+func (r *BulkRequest) Len() int {
+	if r.IDs != nil {
+		return len(r.IDs)
+	}
+
+	return len(r.Items)
+}
+
+// BulkResult is the outcome of one item, Status is the HTTP status the item
+// would have got from the single item endpoint.
+type BulkResult struct {
+	Index  int        `json:"index"`
+	ID     uint       `json:"id,omitempty"`
+	Status int        `json:"status"`
+	Error  string     `json:"error,omitempty"`
+	Fields []echo.Map `json:"fields,omitempty"`
+}
+
+// BulkReport is the response of the bulk endpoints, with one result per
+// item in the order of the request.
+type BulkReport struct {
+	Mode      string        `json:"mode"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Items     []*BulkResult `json:"items"`
+}
+
+// BindBulk reads a bulk request, defaulting to atomic mode. ids tells
+// whether the endpoint takes ids rather than items.
+func BindBulk(c echo.Context, ids bool) (*BulkRequest, *echo.HTTPError) {
+	request := &BulkRequest{}
+
+	if err := c.Bind(request); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to bind bulk request.")
+	}
+
+	if request.Mode == "" {
+		request.Mode = BulkAtomic
+	}
+
+	if request.Mode != BulkAtomic && request.Mode != BulkBestEffort {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Mode must be %s or %s.", BulkAtomic, BulkBestEffort))
+	}
+
+	if ids {
+		request.Items = nil
+
+		if request.IDs == nil {
+			request.IDs = []uint{}
+		}
+	} else {
+		request.IDs = nil
+	}
+
+	if request.Len() < 1 || request.Len() > MaxBulkItems {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("A bulk request takes between 1 and %d items.", MaxBulkItems))
+	}
+
+	return request, nil
+}
+
+// NewBulkReport starts the report of a request with n items, none of them
+// processed yet.
+func NewBulkReport(mode string, n int) *BulkReport {
+	report := &BulkReport{Mode: mode, Items: make([]*BulkResult, n)}
+
+	for i := range report.Items {
+		report.Items[i] = &BulkResult{Index: i}
+	}
+
+	return report
+}
+
+// Fail records that the item at index failed.
+func (r *BulkReport) Fail(index int, status int, message string) {
+	item := r.Items[index]
+
+	r.count(item.Status, status)
+
+	item.Status = status
+	item.Error = message
+}
+
+// Invalid records that the item at index failed validation, listing the
+// fields at fault.
+func (r *BulkReport) Invalid(index int, err error) {
+	r.Fail(index, http.StatusBadRequest, "Failed to validate item.")
+	r.Items[index].Fields = ValidationFields(err)
+}
+
+// Succeed records that the item at index was written as id.
+func (r *BulkReport) Succeed(index int, id uint, status int) {
+	item := r.Items[index]
+
+	r.count(item.Status, status)
+
+	item.ID = id
+	item.Status = status
+	item.Error = ""
+}
+
+func (r *BulkReport) count(from int, to int) {
+	if from >= http.StatusBadRequest {
+		r.Failed--
+	} else if from != 0 {
+		r.Succeeded--
+	}
+
+	if to >= http.StatusBadRequest {
+		r.Failed++
+	} else {
+		r.Succeeded++
+	}
+}
+
+// Status is the status of the whole response: 200 when every item
+// succeeded, 207 when only some did and 422 when none did.
+func (r *BulkReport) Status() int {
+	if r.Failed == 0 {
+		return http.StatusOK
+	} else if r.Succeeded > 0 {
+		return http.StatusMultiStatus
+	}
+
+	return http.StatusUnprocessableEntity
+}
+
+// Write writes the items at indexes, the ones that passed validation, and
+// returns those that were written. write gets the indexes to write together
+// and returns the position among them of the item that made it fail, -1 when
+// the failure is not down to one item. reason turns an error into the
+// status and message reported.
+//
+// In atomic mode everything is written in a single call, and nothing at all
+// when an item already failed. In best effort mode items are written in
+// chunks of BulkChunk, and the items of a chunk that fails are retried one
+// by one so only the bad ones are reported.
+func (r *BulkReport) Write(indexes []int, write func(indexes []int) (int, error), reason func(err error) (int, string)) []int {
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	if r.Mode == BulkAtomic {
+		if r.Failed > 0 {
+			r.skip(indexes, -1)
+			return nil
+		}
+
+		position, err := write(indexes)
+
+		if err == nil {
+			return indexes
+		}
+
+		status, message := reason(err)
+
+		if position < 0 {
+			for _, index := range indexes {
+				r.Fail(index, status, message)
+			}
+
+			return nil
+		}
+
+		r.Fail(indexes[position], status, message)
+		r.skip(indexes, position)
+
+		return nil
+	}
+
+	written := []int{}
+
+	for start := 0; start < len(indexes); start += BulkChunk {
+		end := start + BulkChunk
+
+		if end > len(indexes) {
+			end = len(indexes)
+		}
+
+		chunk := indexes[start:end]
+
+		if _, err := write(chunk); err == nil {
+			written = append(written, chunk...)
+			continue
+		}
+
+		for _, index := range chunk {
+			if _, err := write([]int{index}); err != nil {
+				status, message := reason(err)
+				r.Fail(index, status, message)
+				continue
+			}
+
+			written = append(written, index)
+		}
+	}
+
+	return written
+}
+
+// skip fails the items of an atomic request left unwritten because another
+// item failed.
+func (r *BulkReport) skip(indexes []int, except int) {
+	for position, index := range indexes {
+		if position != except {
+			r.Fail(index, http.StatusFailedDependency, "Not written, another item failed.")
+		}
+	}
+}
+
+// ValidationFields lists the fields of a validator error, in the shape the
+// single item endpoints report them.
+func ValidationFields(err error) []echo.Map {
+	fields := []echo.Map{}
+
+	errs, ok := err.(validator.ValidationErrors)
+
+	if !ok {
+		return fields
+	}
+
+	for _, err := range errs {
+		fields = append(fields, echo.Map{
+			"field": err.Field(),
+			"tag":   err.Tag(),
+			"param": err.Param(),
+			"value": err.Value(),
+		})
+	}
+
+	return fields
+}
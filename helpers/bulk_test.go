@@ -0,0 +1,172 @@
+package helpers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+var errDuplicate = errors.New("duplicate")
+
+func reason(err error) (int, string) {
+	return http.StatusConflict, err.Error()
+}
+
+// writer fails every write holding one of the bad indexes, reporting the
+// position of the first, and records the writes it got.
+type writer struct {
+	bad    map[int]bool
+	writes [][]int
+}
+
+func (w *writer) write(indexes []int) (int, error) {
+	w.writes = append(w.writes, indexes)
+
+	for position, index := range indexes {
+		if w.bad[index] {
+			return position, errDuplicate
+		}
+	}
+
+	return -1, nil
+}
+
+func sequence(n int) []int {
+	indexes := make([]int, n)
+
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	return indexes
+}
+
+func statuses(report *BulkReport) []int {
+	all := []int{}
+
+	for _, item := range report.Items {
+		all = append(all, item.Status)
+	}
+
+	return all
+}
+
+func TestBindBulk(t *testing.T) {
+	cases := []struct {
+		body string
+		ids  bool
+		mode string
+		code int
+	}{
+		{body: `{"items":[{}]}`, mode: BulkAtomic},
+		{body: `{"mode":"best_effort","items":[{},{}]}`, mode: BulkBestEffort},
+		{body: `{"ids":[1,2]}`, ids: true, mode: BulkAtomic},
+		{body: `{"mode":"some","items":[{}]}`, code: http.StatusBadRequest},
+		{body: `{"items":[]}`, code: http.StatusBadRequest},
+		{body: `{"items":[{}]}`, ids: true, code: http.StatusBadRequest},
+		{body: `{"items":[` + strings.Repeat(`{},`, MaxBulkItems) + `{}]}`, code: http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(c.body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+		bulk, err := BindBulk(echo.New().NewContext(request, httptest.NewRecorder()), c.ids)
+
+		if c.code != 0 {
+			if err == nil || err.Code != c.code {
+				t.Errorf("%.40s: got %v, want %d", c.body, err, c.code)
+			}
+
+			continue
+		}
+
+		if err != nil || bulk.Mode != c.mode {
+			t.Errorf("%.40s: got %+v and %v, want mode %s", c.body, bulk, err, c.mode)
+		}
+	}
+}
+
+func TestAtomicWritesAllOrNothing(t *testing.T) {
+	w := &writer{bad: map[int]bool{2: true}}
+	report := NewBulkReport(BulkAtomic, 4)
+
+	if written := report.Write(sequence(4), w.write, reason); written != nil {
+		t.Fatalf("wrote %v, want nothing", written)
+	}
+
+	want := []int{http.StatusFailedDependency, http.StatusFailedDependency, http.StatusConflict, http.StatusFailedDependency}
+
+	if got := statuses(report); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got statuses %v, want %v", got, want)
+	}
+
+	if report.Failed != 4 || report.Succeeded != 0 || report.Status() != http.StatusUnprocessableEntity {
+		t.Fatalf("got %d failed and %d succeeded with %d, want everything to fail with 422", report.Failed, report.Succeeded, report.Status())
+	}
+}
+
+func TestAtomicSkipsWritesAfterInvalidItems(t *testing.T) {
+	w := &writer{}
+	report := NewBulkReport(BulkAtomic, 3)
+	report.Fail(0, http.StatusBadRequest, "Failed to validate item.")
+
+	if written := report.Write([]int{1, 2}, w.write, reason); written != nil || len(w.writes) != 0 {
+		t.Fatalf("wrote %v, want nothing", w.writes)
+	}
+
+	if report.Items[1].Status != http.StatusFailedDependency || report.Failed != 3 {
+		t.Fatalf("got %+v, want the valid items skipped", report)
+	}
+}
+
+func TestBestEffortWritesEveryGoodItem(t *testing.T) {
+	w := &writer{bad: map[int]bool{3: true, BulkChunk + 1: true}}
+	n := BulkChunk + 10
+	report := NewBulkReport(BulkBestEffort, n)
+
+	written := report.Write(sequence(n), w.write, reason)
+
+	for _, index := range written {
+		report.Succeed(index, uint(index+1), http.StatusCreated)
+	}
+
+	if len(written) != n-2 || report.Succeeded != n-2 || report.Failed != 2 {
+		t.Fatalf("wrote %d items, %d succeeded and %d failed, want all but 2 written", len(written), report.Succeeded, report.Failed)
+	}
+
+	if report.Items[3].Status != http.StatusConflict || report.Items[3].Error != "duplicate" || report.Items[3].ID != 0 {
+		t.Fatalf("got %+v, want item 3 to report the conflict", report.Items[3])
+	}
+
+	// Both chunks fail and are retried item by item.
+	if len(w.writes) != 2+n {
+		t.Fatalf("made %d writes, want %d", len(w.writes), 2+n)
+	}
+
+	if report.Status() != http.StatusMultiStatus {
+		t.Fatalf("answered %d, want 207", report.Status())
+	}
+}
+
+func TestReportCountsTheLastOutcome(t *testing.T) {
+	report := NewBulkReport(BulkBestEffort, 2)
+
+	report.Succeed(0, 1, http.StatusCreated)
+	report.Succeed(1, 2, http.StatusCreated)
+
+	if report.Status() != http.StatusOK {
+		t.Fatalf("answered %d, want 200", report.Status())
+	}
+
+	report.Fail(1, http.StatusInternalServerError, "Failed to commit.")
+
+	if report.Succeeded != 1 || report.Failed != 1 || report.Items[1].Error == "" {
+		t.Fatalf("got %+v, want the item moved to the failures", report)
+	}
+}
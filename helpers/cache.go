@@ -3,34 +3,121 @@ package helpers
 import (
 	"context"
 	"fmt"
-	"os"
+	"sync"
 	"time"
 
 	"github.com/go-redis/cache/v8"
 	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
 )
 
-func InitRedisCache() *cache.Cache {
-	ring := redis.NewRing(&redis.RingOptions{
+func NewRedis(config RedisConfig) *redis.Ring {
+	return redis.NewRing(&redis.RingOptions{
 		Addrs: map[string]string{
-			"server": fmt.Sprintf("%s:%s", os.Getenv("REDIS_HOST"), os.Getenv("REDIS_PORT")),
+			"server": fmt.Sprintf("%s:%d", config.Host, config.Port),
 		},
+		Password: config.Password.Value(),
+		DB:       config.DB,
 	})
+}
 
+func NewCache(ring *redis.Ring) *Cache {
 	cacheClient := cache.New(&cache.Options{
 		Redis:      ring,
 		LocalCache: cache.NewTinyLFU(1000, time.Minute),
 	})
 
-	return cacheClient
+	return &Cache{Cache: cacheClient}
 }
 
-func ClearCache() {
-	ring := redis.NewRing(&redis.RingOptions{
-		Addrs: map[string]string{
-			"server": fmt.Sprintf("%s:%s", os.Getenv("REDIS_HOST"), os.Getenv("REDIS_PORT")),
-		},
+// ListCacheKey returns the key the whole list of resource is cached under
+// for the current request: one list shared by principals holding manage,
+// who see every record, and one per user otherwise. Other principals, such
+// as API keys without manage, are not cached.
+func ListCacheKey(c echo.Context, resource string, manage string) (string, bool) {
+	if Can(c, manage) {
+		return resource, true
+	}
+
+	if user := CurrentUser(c); user != nil {
+		return fmt.Sprintf("%s:user:%d", resource, user.ID), true
+	}
+
+	return "", false
+}
+
+// InvalidateLists drops the cached lists of resource, the shared one and
+// the ones of the given users, see ListCacheKey.
+func (c *Cache) InvalidateLists(ctx context.Context, resource string, userIDs ...uint) {
+	c.Delete(ctx, resource)
+
+	for _, id := range userIDs {
+		c.Delete(ctx, fmt.Sprintf("%s:user:%d", resource, id))
+	}
+}
+
+// CachePrefixes are the prefixes of every key cached by the services. The
+// same Redis also holds refresh tokens, revoked tokens, rate limit windows,
+// idempotency records and import error files, which are not a cache and
+// are never flushed with it.
+var CachePrefixes = []string{"orders", "order:", "users", "user:", "histories", "history:", "stocks", "stock:", "portfolio:", "trade:"}
+
+// ClearCache deletes every cached key, see CachePrefixes, and returns how
+// many were deleted.
+func ClearCache(ctx context.Context, ring *redis.Ring) (int64, error) {
+	var deleted int64
+
+	for _, prefix := range CachePrefixes {
+		count, err := FlushCachePrefix(ctx, ring, prefix)
+		deleted += count
+
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// FlushCachePrefix deletes the keys starting with prefix from every shard,
+// such as "orders:" for the cached order lists, and returns how many were
+// deleted.
+func FlushCachePrefix(ctx context.Context, ring *redis.Ring, prefix string) (int64, error) {
+	var deleted int64
+	var mutex sync.Mutex
+
+	err := ring.ForEachShard(ctx, func(ctx context.Context, client *redis.Client) error {
+		iter := client.Scan(ctx, 0, prefix+"*", 1000).Iterator()
+		keys := []string{}
+
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+
+		if err := iter.Err(); err != nil {
+			return err
+		}
+
+		for start := 0; start < len(keys); start += 1000 {
+			end := start + 1000
+
+			if end > len(keys) {
+				end = len(keys)
+			}
+
+			count, err := client.Del(ctx, keys[start:end]...).Result()
+
+			if err != nil {
+				return err
+			}
+
+			mutex.Lock()
+			deleted += count
+			mutex.Unlock()
+		}
+
+		return nil
 	})
 
-	ring.FlushDB(context.TODO())
+	return deleted, err
 }
@@ -0,0 +1,383 @@
+package helpers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the application. It is loaded once on start
+// by LoadConfig and handed to components through Dependencies.
+//
+// Every field names the environment variable it is read from, its default
+// and its key in the optional YAML file. Settings are taken from, in order
+// of precedence, the environment, the .env file, the YAML file and the
+// defaults.
+type Config struct {
+	App         AppConfig         `yaml:"app"`
+	Database    DatabaseConfig    `yaml:"database"`
+	Redis       RedisConfig       `yaml:"redis"`
+	Log         LogConfig         `yaml:"log"`
+	Auth        AuthConfig        `yaml:"auth"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Orders      OrdersConfig      `yaml:"orders"`
+	Portfolio   PortfolioConfig   `yaml:"portfolio"`
+	Health      HealthConfig      `yaml:"health"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+}
+
+type AppConfig struct {
+	Env  string `yaml:"env" env:"APP_ENV" default:"production" validate:"oneof=development staging production"`
+	Port int    `yaml:"port" env:"APP_PORT" default:"5000" validate:"min=1,max=65535"`
+	// GRPCPort serves the gRPC API next to the HTTP one, 0 turns it off.
+	GRPCPort int `yaml:"grpc_port" env:"GRPC_PORT" default:"9090" validate:"min=0,max=65535"`
+	// ShutdownTimeout bounds how long in-flight requests may take to finish
+	// once the server is asked to stop.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" default:"15s" validate:"min=1"`
+	// TrustedProxies lists the addresses, as comma separated CIDR ranges,
+	// of the load balancers whose X-Forwarded-For header is believed. With
+	// none the client address is the one of the connection.
+	TrustedProxies string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+}
+
+type DatabaseConfig struct {
+	Host            string        `yaml:"host" env:"DB_HOST" validate:"required"`
+	Port            int           `yaml:"port" env:"DB_PORT" default:"5432" validate:"min=1,max=65535"`
+	User            string        `yaml:"user" env:"DB_USER" validate:"required"`
+	Password        Secret        `yaml:"password" env:"DB_PASS"`
+	Name            string        `yaml:"name" env:"DB_NAME" validate:"required"`
+	SSLMode         string        `yaml:"ssl_mode" env:"DB_SSLMODE" default:"disable" validate:"oneof=disable allow prefer require verify-ca verify-full"`
+	TimeZone        string        `yaml:"time_zone" env:"DB_TIMEZONE" default:"Asia/Jakarta"`
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS" default:"25" validate:"min=1"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" default:"10" validate:"min=0"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" default:"30m"`
+	// MigrateOnStart applies pending migrations when the server starts,
+	// turn it off to run them as a separate release step.
+	MigrateOnStart bool `yaml:"migrate_on_start" env:"DB_MIGRATE_ON_START" default:"true"`
+}
+
+type RedisConfig struct {
+	Host     string `yaml:"host" env:"REDIS_HOST" validate:"required"`
+	Port     int    `yaml:"port" env:"REDIS_PORT" default:"6379" validate:"min=1,max=65535"`
+	Password Secret `yaml:"password" env:"REDIS_PASSWORD"`
+	DB       int    `yaml:"db" env:"REDIS_DB" default:"0" validate:"min=0"`
+}
+
+type LogConfig struct {
+	File  string `yaml:"file" env:"LOG_FILE" default:"logs/access.log"`
+	Level string `yaml:"level" env:"LOG_LEVEL" default:"info" validate:"oneof=debug info warn error"`
+}
+
+type AuthConfig struct {
+	Algorithm       string        `yaml:"algorithm" env:"JWT_ALGORITHM" default:"HS256" validate:"oneof=HS256 RS256"`
+	Secret          Secret        `yaml:"secret" env:"JWT_SECRET"`
+	PrivateKey      Secret        `yaml:"private_key" env:"JWT_PRIVATE_KEY"`
+	PublicKey       string        `yaml:"public_key" env:"JWT_PUBLIC_KEY"`
+	Issuer          string        `yaml:"issuer" env:"JWT_ISSUER"`
+	AccessTTL       time.Duration `yaml:"access_ttl" env:"JWT_ACCESS_TTL" default:"15m" validate:"min=1"`
+	RefreshTTL      time.Duration `yaml:"refresh_ttl" env:"JWT_REFRESH_TTL" default:"168h" validate:"min=1"`
+	MaxFailedLogins int           `yaml:"max_failed_logins" env:"AUTH_MAX_FAILED_LOGINS" default:"5" validate:"min=1"`
+	LockoutDuration time.Duration `yaml:"lockout_duration" env:"AUTH_LOCKOUT_DURATION" default:"15m" validate:"min=1"`
+	AdminEmail      string        `yaml:"admin_email" env:"ADMIN_EMAIL" validate:"omitempty,email"`
+}
+
+type RateLimitConfig struct {
+	Default string `yaml:"default" env:"RATE_LIMIT_DEFAULT" default:"120/1m"`
+	Routes  string `yaml:"routes" env:"RATE_LIMIT_ROUTES"`
+	APIKey  int    `yaml:"api_key" env:"API_KEY_RATE_LIMIT" default:"600" validate:"min=1"`
+}
+
+type IdempotencyConfig struct {
+	TTL time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL" default:"24h" validate:"min=1"`
+	// PendingTTL bounds how long a key stays claimed by a request that never
+	// finished, e.g. because the server died while handling it.
+	PendingTTL time.Duration `yaml:"pending_ttl" env:"IDEMPOTENCY_PENDING_TTL" default:"1m" validate:"min=1"`
+}
+
+type OrdersConfig struct {
+	ExpiryInterval time.Duration `yaml:"expiry_interval" env:"ORDERS_EXPIRY_INTERVAL" default:"1m" validate:"min=1"`
+}
+
+type PortfolioConfig struct {
+	CostMethod string `yaml:"cost_method" env:"PORTFOLIO_COST_METHOD" default:"fifo" validate:"oneof=fifo average"`
+}
+
+type HealthConfig struct {
+	// Timeout bounds every dependency check of the readiness probe.
+	Timeout time.Duration `yaml:"timeout" env:"HEALTH_CHECK_TIMEOUT" default:"2s" validate:"min=1"`
+}
+
+type TracingConfig struct {
+	// Exporter is where spans go, none still propagates traceparent headers.
+	Exporter    string  `yaml:"exporter" env:"OTEL_TRACES_EXPORTER" default:"none" validate:"oneof=none otlp stdout"`
+	Endpoint    string  `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" default:"localhost:4318"`
+	Insecure    bool    `yaml:"insecure" env:"OTEL_EXPORTER_OTLP_INSECURE" default:"true"`
+	ServiceName string  `yaml:"service_name" env:"OTEL_SERVICE_NAME" default:"sahamrakyat" validate:"required"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"OTEL_TRACES_SAMPLER_ARG" default:"1" validate:"min=0,max=1"`
+}
+
+// Proxies splits TrustedProxies into its CIDR ranges.
+func (c AppConfig) Proxies() []string {
+	proxies := []string{}
+
+	for _, proxy := range strings.Split(c.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return proxies
+}
+
+// Secret is a setting that must never end up in logs. It prints and
+// marshals as a placeholder, Value returns the setting itself.
+type Secret string
+
+const redacted = "*****"
+
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return strconv.Quote(s.String())
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// LoadConfig reads the configuration. A missing .env file is fine, the
+// environment alone may hold everything. The YAML file is CONFIG_FILE, or
+// config.yaml when it exists.
+func LoadConfig() (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load .env: %w", err)
+	}
+
+	config := &Config{}
+
+	if err := walk(reflect.ValueOf(config).Elem(), "", func(field reflect.Value, tag reflect.StructTag, _ string) error {
+		if value, ok := tag.Lookup("default"); ok {
+			return set(field, value)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	file, required := os.LookupEnv("CONFIG_FILE")
+
+	if !required {
+		file = "config.yaml"
+	}
+
+	if content, err := os.ReadFile(file); err == nil {
+		if err := yaml.Unmarshal(content, config); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+	} else if required || !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	if err := walk(reflect.ValueOf(config).Elem(), "", func(field reflect.Value, tag reflect.StructTag, _ string) error {
+		if value, ok := os.LookupEnv(tag.Get("env")); ok && tag.Get("env") != "" {
+			if err := set(field, value); err != nil {
+				return fmt.Errorf("%s: %w", tag.Get("env"), err)
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Validate checks every setting, naming the environment variables of the
+// invalid ones.
+func (c *Config) Validate() error {
+	problems := []string{}
+
+	if err := validator.New().Struct(c); err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+
+		if !ok {
+			return err
+		}
+
+		for _, err := range validationErrors {
+			rule := err.Tag()
+
+			if err.Param() != "" {
+				rule += "=" + err.Param()
+			}
+
+			problems = append(problems, fmt.Sprintf("%s must satisfy %s", c.envName(err.StructNamespace()), rule))
+		}
+	}
+
+	// Tokens are signed with the key material of JWT_ALGORITHM, which is
+	// required so a missing key fails on start rather than on first login.
+	switch c.Auth.Algorithm {
+	case "HS256":
+		if len(c.Auth.Secret) < 32 {
+			problems = append(problems, "JWT_SECRET must be at least 32 characters")
+		}
+	case "RS256":
+		if c.Auth.PrivateKey == "" {
+			problems = append(problems, "JWT_PRIVATE_KEY is required with JWT_ALGORITHM=RS256")
+		}
+
+		if c.Auth.PublicKey == "" {
+			problems = append(problems, "JWT_PUBLIC_KEY is required with JWT_ALGORITHM=RS256")
+		}
+	}
+
+	for _, proxy := range c.App.Proxies() {
+		if _, _, err := net.ParseCIDR(proxy); err != nil {
+			problems = append(problems, fmt.Sprintf("TRUSTED_PROXIES has an invalid CIDR range %q", proxy))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// String lists every setting by its YAML key with secrets redacted, so the
+// configuration can be logged on start.
+func (c *Config) String() string {
+	lines := []string{}
+
+	walk(reflect.ValueOf(c).Elem(), "", func(field reflect.Value, _ reflect.StructTag, key string) error {
+		lines = append(lines, fmt.Sprintf("%s=%v", key, field.Interface()))
+		return nil
+	})
+
+	sort.Strings(lines)
+
+	return strings.Join(lines, "\n")
+}
+
+// envName maps a validator namespace such as "Config.Database.Host" to the
+// environment variable of the field.
+func (c *Config) envName(namespace string) string {
+	value := reflect.ValueOf(c).Elem()
+	fieldType := reflect.StructField{}
+
+	for _, name := range strings.Split(namespace, ".")[1:] {
+		var ok bool
+
+		if fieldType, ok = value.Type().FieldByName(name); !ok {
+			return namespace
+		}
+
+		value = value.FieldByName(name)
+	}
+
+	if env := fieldType.Tag.Get("env"); env != "" {
+		return env
+	}
+
+	return namespace
+}
+
+// walk calls fn for every setting of a config section, with its YAML key
+// prefixed by the keys of the sections it is in.
+func walk(section reflect.Value, prefix string, fn func(field reflect.Value, tag reflect.StructTag, key string) error) error {
+	for i := 0; i < section.NumField(); i++ {
+		field := section.Field(i)
+		structField := section.Type().Field(i)
+		key := prefix + structField.Tag.Get("yaml")
+
+		if field.Kind() == reflect.Struct {
+			if err := walk(field, key+".", fn); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := fn(field, structField.Tag, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func set(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(value)
+
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(int64(duration))
+
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+
+		if err != nil {
+			return err
+		}
+
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+
+		if err != nil {
+			return err
+		}
+
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setEnv sets the settings LoadConfig requires, plus the given ones, and
+// points CONFIG_FILE at an empty file unless one is given.
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	empty := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(empty, nil, 0o600)
+
+	settings := map[string]string{
+		"CONFIG_FILE": empty,
+		"DB_HOST":     "localhost",
+		"DB_USER":     "postgres",
+		"DB_NAME":     "sahamrakyat",
+		"REDIS_HOST":  "localhost",
+		"JWT_SECRET":  strings.Repeat("s", 32),
+	}
+
+	for name, value := range env {
+		settings[name] = value
+	}
+
+	for name, value := range settings {
+		t.Setenv(name, value)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	setEnv(t, nil)
+
+	config, err := LoadConfig()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.App.Port != 5000 || config.Database.Port != 5432 || config.Auth.AccessTTL != 15*time.Minute || !config.Database.MigrateOnStart {
+		t.Fatalf("defaults were not applied: %+v", config)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+
+	if err := os.WriteFile(file, []byte("app:\n  port: 6000\n  env: staging\nredis:\n  db: 2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	setEnv(t, map[string]string{"CONFIG_FILE": file, "APP_PORT": "7000"})
+
+	config, err := LoadConfig()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.App.Port != 7000 {
+		t.Errorf("APP_PORT did not override the file: %d", config.App.Port)
+	}
+
+	if config.App.Env != "staging" || config.Redis.DB != 2 {
+		t.Errorf("the file did not override the defaults: %q, %d", config.App.Env, config.Redis.DB)
+	}
+}
+
+func TestLoadConfigFailsOnStart(t *testing.T) {
+	cases := []struct {
+		env  map[string]string
+		want string
+	}{
+		{env: map[string]string{"DB_HOST": ""}, want: "DB_HOST must satisfy required"},
+		{env: map[string]string{"APP_PORT": "70000"}, want: "APP_PORT must satisfy max=65535"},
+		{env: map[string]string{"APP_PORT": "port"}, want: "APP_PORT"},
+		{env: map[string]string{"JWT_SECRET": ""}, want: "JWT_SECRET must be at least 32 characters"},
+		{env: map[string]string{"JWT_SECRET": "short"}, want: "JWT_SECRET must be at least 32 characters"},
+		{env: map[string]string{"JWT_ALGORITHM": "RS256", "JWT_PUBLIC_KEY": "public.pem"}, want: "JWT_PRIVATE_KEY is required"},
+		{env: map[string]string{"JWT_ALGORITHM": "RS256", "JWT_PRIVATE_KEY": "private.pem"}, want: "JWT_PUBLIC_KEY is required"},
+		{env: map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8,10.1.2.3"}, want: `invalid CIDR range "10.1.2.3"`},
+		{env: map[string]string{"CONFIG_FILE": "missing.yaml"}, want: "failed to read missing.yaml"},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprint(c.env), func(t *testing.T) {
+			setEnv(t, c.env)
+
+			if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("got %v, want an error containing %q", err, c.want)
+			}
+		})
+	}
+}
+
+func TestSecretsAreRedacted(t *testing.T) {
+	setEnv(t, map[string]string{"DB_PASS": "hunter2"})
+
+	config, err := LoadConfig()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if printed := config.String(); strings.Contains(printed, "hunter2") || strings.Contains(printed, strings.Repeat("s", 32)) {
+		t.Fatalf("secrets were printed:\n%s", printed)
+	}
+
+	if config.Database.Password.Value() != "hunter2" {
+		t.Fatalf("got password %q, want hunter2", config.Database.Password.Value())
+	}
+}
@@ -8,14 +8,24 @@ import (
 )
 
 // Connect to postgres database
-func ConnectDatabase(host string, user string, password string, dbname string) *gorm.DB {
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=5432 sslmode=disable TimeZone=Asia/Jakarta", host, user, password, dbname)
+func ConnectDatabase(config DatabaseConfig) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s TimeZone=%s", config.Host, config.User, config.Password.Value(), config.Name, config.Port, config.SSLMode, config.TimeZone)
 
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 
 	if err != nil {
-		panic(fmt.Sprintf("Failed to connect to database: %s", err.Error()))
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	return db
-}
\ No newline at end of file
+	sqlDB, err := db.DB()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB.SetMaxOpenConns(config.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(config.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(config.ConnMaxLifetime)
+
+	return db, nil
+}
@@ -0,0 +1,132 @@
+package helpers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"gorm.io/gorm"
+)
+
+// Dependencies are the shared clients every component works with, opened
+// once on start. Request handlers get them with Deps, everything else with
+// DepsFrom on a context made by WithDependencies.
+type Dependencies struct {
+	Config *Config
+	DB     *gorm.DB
+	Redis  *redis.Ring
+	Cache  *Cache
+	Logger *log.Logger
+
+	logFile        interface{ Close() error }
+	tracerProvider *sdktrace.TracerProvider
+}
+
+type dependenciesKey struct{}
+
+// Open connects everything the configuration describes.
+func Open(config *Config) (*Dependencies, error) {
+	logger, logFile, err := NewLogger(config.Log)
+
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := ConnectDatabase(config.Database)
+
+	if err != nil {
+		logFile.Close()
+		return nil, err
+	}
+
+	ring := NewRedis(config.Redis)
+
+	tracerProvider, err := NewTracerProvider(config.Tracing)
+
+	if err != nil {
+		logFile.Close()
+		return nil, err
+	}
+
+	ring.AddHook(redisTracing{})
+
+	if err := db.Use(queryTracing{}); err != nil {
+		logFile.Close()
+		return nil, err
+	}
+
+	if err := db.Use(queryMetrics{}); err != nil {
+		logFile.Close()
+		return nil, err
+	}
+
+	if err := registerPoolMetrics(db, ring); err != nil {
+		logFile.Close()
+		return nil, err
+	}
+
+	return &Dependencies{
+		Config:         config,
+		DB:             db,
+		Redis:          ring,
+		Cache:          NewCache(ring),
+		Logger:         logger,
+		logFile:        logFile,
+		tracerProvider: tracerProvider,
+	}, nil
+}
+
+// Close flushes pending spans and releases the database pool, the Redis
+// ring and the log file, in that order.
+func (d *Dependencies) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	firstErr := d.tracerProvider.Shutdown(ctx)
+
+	if sqlDB, err := d.DB.DB(); err == nil {
+		if err := sqlDB.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if err := d.Redis.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	if err := d.logFile.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	return firstErr
+}
+
+// WithDependencies returns a context carrying the dependencies.
+func WithDependencies(ctx context.Context, deps *Dependencies) context.Context {
+	return context.WithValue(ctx, dependenciesKey{}, deps)
+}
+
+// DepsFrom returns the dependencies carried by a context.
+func DepsFrom(ctx context.Context) *Dependencies {
+	deps, _ := ctx.Value(dependenciesKey{}).(*Dependencies)
+	return deps
+}
+
+// Deps returns the dependencies of a request.
+func Deps(c echo.Context) *Dependencies {
+	return DepsFrom(c.Request().Context())
+}
+
+// Inject makes the dependencies available to every request, it has to run
+// before any other middleware.
+func Inject(deps *Dependencies) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.SetRequest(c.Request().WithContext(WithDependencies(c.Request().Context(), deps)))
+			return next(c)
+		}
+	}
+}
@@ -9,25 +9,33 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func InitLogger() *log.Logger {
+// NewLogger logs JSON to stdout and to the log file, which the caller closes.
+func NewLogger(config LogConfig) (*log.Logger, *os.File, error) {
 	logger := log.New()
 	logger.SetFormatter(&log.JSONFormatter{})
 
-	cwd, err := os.Getwd()
+	level, err := log.ParseLevel(config.Level)
+
 	if err != nil {
-		log.Fatalf("Failed to determine working directory: %s", err)
+		return nil, nil, err
 	}
 
-	f, err := os.OpenFile(filepath.Join(cwd, "logs/access.log"), os.O_APPEND|os.O_CREATE, 0755)
+	logger.SetLevel(level)
+
+	if err := os.MkdirAll(filepath.Dir(config.File), 0755); err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.OpenFile(config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 
 	if err != nil {
-		logger.Fatal(err)
+		return nil, nil, err
 	}
 
 	logger.SetOutput(io.MultiWriter(os.Stdout, f))
-	return logger
+	return logger, f, nil
 }
 
 func ApacheFormatLogger(method string, url string, host string, ip string, ua string, time string) string {
 	return fmt.Sprintf("%s - [%s] %s %s %s %s %s", method, time, ip, host, url, ua, "\n")
-}
\ No newline at end of file
+}
@@ -0,0 +1,286 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/cache/v8"
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+// Registry holds every metric of the application, served on /metrics by
+// MetricsHandler.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests, by method, route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	dbDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Time taken by database queries, by operation and table.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table"})
+
+	cacheOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_operations_total",
+		Help: "Cache operations, by operation, key prefix and result (hit, miss, ok or error).",
+	}, []string{"operation", "prefix", "result"})
+
+	// RateLimitRejections counts requests answered 429, by route and kind of
+	// client (apikey, user or ip).
+	RateLimitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ratelimit_rejections_total",
+		Help: "Requests rejected by the rate limiter, by route and kind of client.",
+	}, []string{"route", "client"})
+
+	workerRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "worker_runs_total",
+		Help: "Runs of background workers, by worker and result.",
+	}, []string{"worker", "result"})
+
+	workerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "worker_run_duration_seconds",
+		Help:    "Time taken by runs of background workers.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"worker"})
+
+	// WorkerItems counts what background workers processed, such as expired
+	// orders.
+	WorkerItems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "worker_items_processed_total",
+		Help: "Items processed by background workers.",
+	}, []string{"worker"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, dbDuration, cacheOperations, RateLimitRejections,
+		workerRuns, workerDuration, WorkerItems,
+	)
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format.
+func MetricsHandler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry}))
+}
+
+// Metrics counts every request and its latency by the route it matched,
+// not its URL, so ids in paths do not blow up the number of series.
+// Requests matching no route are counted under "unmatched".
+func Metrics(skipper func(c echo.Context) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skipper != nil && skipper(c) {
+				return next(c)
+			}
+
+			start := time.Now()
+			err := next(c)
+
+			status := responseStatus(c, err)
+			route := c.Path()
+
+			if route == "" || status == 404 && strings.HasSuffix(route, "/*") {
+				route = "unmatched"
+			}
+
+			labels := []string{c.Request().Method, route, strconv.Itoa(status)}
+
+			httpRequests.WithLabelValues(labels...).Inc()
+			httpDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+
+			return err
+		}
+	}
+}
+
+// responseStatus is the status a request is answered with. An error is
+// turned into a response only after the middleware returns, so take the
+// status it is going to get.
+func responseStatus(c echo.Context, err error) int {
+	if err == nil {
+		return c.Response().Status
+	}
+
+	if httpErr, ok := err.(*echo.HTTPError); ok {
+		return httpErr.Code
+	}
+
+	return 500
+}
+
+// ObserveWorker records a run of a background worker that started at start.
+func ObserveWorker(worker string, start time.Time, err error) {
+	result := "ok"
+
+	if err != nil {
+		result = "error"
+	}
+
+	workerRuns.WithLabelValues(worker, result).Inc()
+	workerDuration.WithLabelValues(worker).Observe(time.Since(start).Seconds())
+}
+
+// registerPoolMetrics exports the statistics of the database pool and the
+// Redis ring.
+func registerPoolMetrics(db *gorm.DB, ring *redis.Ring) error {
+	sqlDB, err := db.DB()
+
+	if err != nil {
+		return err
+	}
+
+	if err := Registry.Register(collectors.NewDBStatsCollector(sqlDB, "postgres")); err != nil {
+		return err
+	}
+
+	redisStats := map[string]func(stats *redis.PoolStats) uint32{
+		"hits":        func(stats *redis.PoolStats) uint32 { return stats.Hits },
+		"misses":      func(stats *redis.PoolStats) uint32 { return stats.Misses },
+		"timeouts":    func(stats *redis.PoolStats) uint32 { return stats.Timeouts },
+		"total_conns": func(stats *redis.PoolStats) uint32 { return stats.TotalConns },
+		"idle_conns":  func(stats *redis.PoolStats) uint32 { return stats.IdleConns },
+		"stale_conns": func(stats *redis.PoolStats) uint32 { return stats.StaleConns },
+	}
+
+	for name, stat := range redisStats {
+		stat := stat
+
+		gauge := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "redis_pool_" + name,
+			Help: "Redis connection pool " + strings.ReplaceAll(name, "_", " ") + ".",
+		}, func() float64 {
+			return float64(stat(ring.PoolStats()))
+		})
+
+		if err := Registry.Register(gauge); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Cache is the Redis cache, counting hits, misses and errors by the prefix
+// of the key, the part before the first colon.
+type Cache struct {
+	*cache.Cache
+}
+
+func (c *Cache) Get(ctx context.Context, key string, value interface{}) error {
+	err := c.Cache.Get(ctx, key, value)
+	observeCache("get", key, err)
+	return err
+}
+
+func (c *Cache) GetSkippingLocalCache(ctx context.Context, key string, value interface{}) error {
+	err := c.Cache.GetSkippingLocalCache(ctx, key, value)
+	observeCache("get", key, err)
+	return err
+}
+
+func (c *Cache) Set(item *cache.Item) error {
+	err := c.Cache.Set(item)
+	observeCache("set", item.Key, err)
+	return err
+}
+
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	err := c.Cache.Delete(ctx, key)
+	observeCache("delete", key, err)
+	return err
+}
+
+func observeCache(operation string, key string, err error) {
+	result := "ok"
+
+	switch {
+	case errors.Is(err, cache.ErrCacheMiss):
+		result = "miss"
+	case err != nil:
+		result = "error"
+	case operation == "get":
+		result = "hit"
+	}
+
+	prefix, _, _ := strings.Cut(key, ":")
+
+	cacheOperations.WithLabelValues(operation, prefix, result).Inc()
+}
+
+// queryMetrics is a GORM plugin timing every query by operation and table.
+type queryMetrics struct{}
+
+const queryStartKey = "metrics:start"
+
+func (queryMetrics) Name() string {
+	return "metrics"
+}
+
+func (queryMetrics) Initialize(db *gorm.DB) error {
+	before := func(db *gorm.DB) {
+		db.InstanceSet(queryStartKey, time.Now())
+	}
+
+	after := func(operation string) func(db *gorm.DB) {
+		return func(db *gorm.DB) {
+			value, ok := db.InstanceGet(queryStartKey)
+
+			if !ok {
+				return
+			}
+
+			table := db.Statement.Table
+
+			if table == "" {
+				table = "unknown"
+			}
+
+			dbDuration.WithLabelValues(operation, table).Observe(time.Since(value.(time.Time)).Seconds())
+		}
+	}
+
+	register := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", db.Callback().Create().Before("gorm:create").Register, db.Callback().Create().After("gorm:create").Register},
+		{"query", db.Callback().Query().Before("gorm:query").Register, db.Callback().Query().After("gorm:query").Register},
+		{"update", db.Callback().Update().Before("gorm:update").Register, db.Callback().Update().After("gorm:update").Register},
+		{"delete", db.Callback().Delete().Before("gorm:delete").Register, db.Callback().Delete().After("gorm:delete").Register},
+		{"row", db.Callback().Row().Before("gorm:row").Register, db.Callback().Row().After("gorm:row").Register},
+		{"raw", db.Callback().Raw().Before("gorm:raw").Register, db.Callback().Raw().After("gorm:raw").Register},
+	}
+
+	for _, r := range register {
+		if err := r.before("metrics:before_"+r.operation, before); err != nil {
+			return err
+		}
+
+		if err := r.after("metrics:after_"+r.operation, after(r.operation)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package helpers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Page reads the take and skip query parameters of list requests, -1 when
+// left out so GORM applies no limit or offset.
+func Page(c echo.Context) (int, int, *echo.HTTPError) {
+	take := -1
+	skip := -1
+
+	if takeQuery := c.QueryParam("take"); takeQuery != "" {
+		val, err := strconv.ParseInt(takeQuery, 10, 32)
+
+		if err != nil {
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse take.")
+		}
+
+		take = int(val)
+	}
+
+	if skipQuery := c.QueryParam("skip"); skipQuery != "" {
+		val, err := strconv.ParseInt(skipQuery, 10, 32)
+
+		if err != nil {
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse skip.")
+		}
+
+		skip = int(val)
+	}
+
+	return take, skip, nil
+}
+
+// Period reads the from and to query parameters of list requests as RFC
+// 3339 times, keeping the records whose column is at or after from and
+// before to.
+func Period(c echo.Context, column string) (func(*gorm.DB) *gorm.DB, *echo.HTTPError) {
+	bounds := map[string]*time.Time{}
+
+	for _, name := range []string{"from", "to"} {
+		query := c.QueryParam(name)
+
+		if query == "" {
+			continue
+		}
+
+		bound, err := time.Parse(time.RFC3339, query)
+
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse "+name+", expected RFC 3339 time.")
+		}
+
+		bounds[name] = &bound
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		if from := bounds["from"]; from != nil {
+			db = db.Where(column+" >= ?", *from)
+		}
+
+		if to := bounds["to"]; to != nil {
+			db = db.Where(column+" < ?", *to)
+		}
+
+		return db
+	}, nil
+}
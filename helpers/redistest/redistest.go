@@ -0,0 +1,256 @@
+// Package redistest serves the Redis commands of the middlewares over RESP
+// for their tests, as neither Redis nor an in-memory replacement for it is
+// available to them.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Replies of commands, in RESP.
+const (
+	OK  = "+OK\r\n"
+	Nil = "$-1\r\n"
+)
+
+// Int is the RESP reply of an integer.
+func Int(n int64) string {
+	return fmt.Sprintf(":%d\r\n", n)
+}
+
+// Bulk is the RESP reply of a string.
+func Bulk(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+// Command answers a command the server does not know with its reply, it
+// returns false to leave the command to the server. Commands run one at a
+// time.
+type Command func(args []string) (string, bool)
+
+// Server answers PING, GET, SET with NX, XX, EX and PX, GETDEL, DEL and
+// PEXPIRE on strings, and every command of extra. Anything else is
+// answered with OK.
+type Server struct {
+	mu       sync.Mutex
+	values   map[string]string
+	expiries map[string]time.Time
+	extra    Command
+	addr     string
+}
+
+// Start serves until the test ends. extra may be nil.
+func Start(t testing.TB, extra Command) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	server := &Server{values: map[string]string{}, expiries: map[string]time.Time{}, extra: extra, addr: listener.Addr().String()}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+// Addr is the address the server listens on.
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// TTL is how long key has left to live, 0 when it does not expire or does
+// not exist.
+func (s *Server) TTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.get(key); !ok || s.expiries[key].IsZero() {
+		return 0
+	}
+
+	return time.Until(s.expiries[key])
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	for {
+		args, err := readCommand(reader)
+
+		if err != nil {
+			return
+		}
+
+		if _, err := io.WriteString(conn, s.run(args)); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+
+	for i := range args {
+		header, err := reader.ReadString('\n')
+
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "$")))
+
+		if err != nil {
+			return nil, err
+		}
+
+		value := make([]byte, size+2)
+
+		if _, err := io.ReadFull(reader, value); err != nil {
+			return nil, err
+		}
+
+		args[i] = string(value[:size])
+	}
+
+	return args, nil
+}
+
+// get returns the value of key, dropping it once it expired.
+func (s *Server) get(key string) (string, bool) {
+	if at, ok := s.expiries[key]; ok && !at.IsZero() && !time.Now().Before(at) {
+		delete(s.values, key)
+		delete(s.expiries, key)
+	}
+
+	value, ok := s.values[key]
+
+	return value, ok
+}
+
+func (s *Server) run(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.extra != nil {
+		if reply, ok := s.extra(args); ok {
+			return reply
+		}
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "ping":
+		return "+PONG\r\n"
+	case "get":
+		value, ok := s.get(args[1])
+
+		if !ok {
+			return Nil
+		}
+
+		return Bulk(value)
+	case "set":
+		return s.set(args[1], args[2], args[3:])
+	case "getdel":
+		value, ok := s.get(args[1])
+
+		if !ok {
+			return Nil
+		}
+
+		delete(s.values, args[1])
+		delete(s.expiries, args[1])
+
+		return Bulk(value)
+	case "del":
+		removed := int64(0)
+
+		for _, key := range args[1:] {
+			if _, ok := s.get(key); ok {
+				delete(s.values, key)
+				delete(s.expiries, key)
+				removed++
+			}
+		}
+
+		return Int(removed)
+	case "pexpire":
+		if _, ok := s.get(args[1]); !ok {
+			return Int(0)
+		}
+
+		ms, _ := strconv.ParseInt(args[2], 10, 64)
+		s.expiries[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+
+		return Int(1)
+	default:
+		return OK
+	}
+}
+
+func (s *Server) set(key string, value string, options []string) string {
+	_, exists := s.get(key)
+	expiry := time.Time{}
+
+	for i := 0; i < len(options); i++ {
+		switch strings.ToLower(options[i]) {
+		case "nx":
+			if exists {
+				return Nil
+			}
+		case "xx":
+			if !exists {
+				return Nil
+			}
+		case "ex", "px":
+			n, _ := strconv.ParseInt(options[i+1], 10, 64)
+			unit := time.Second
+
+			if strings.EqualFold(options[i], "px") {
+				unit = time.Millisecond
+			}
+
+			expiry = time.Now().Add(time.Duration(n) * unit)
+			i++
+		}
+	}
+
+	s.values[key] = value
+	s.expiries[key] = expiry
+
+	return OK
+}
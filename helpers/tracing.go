@@ -0,0 +1,245 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const tracerName = "sahamrakyat_test"
+
+// Tracer returns the tracer spans of the application are started with.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// NewTracerProvider sets up tracing as OTEL_TRACES_EXPORTER asks: spans are
+// sent to an OTLP/HTTP collector, written to stdout or, with none, only
+// used to propagate W3C traceparent headers. It is installed globally.
+func NewTracerProvider(config TracingConfig) (*sdktrace.TracerProvider, error) {
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(config.ServiceName))),
+	}
+
+	switch config.Exporter {
+	case "otlp":
+		clientOptions := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.Endpoint)}
+
+		if config.Insecure {
+			clientOptions = append(clientOptions, otlptracehttp.WithInsecure())
+		}
+
+		exporter, err := otlptracehttp.New(context.Background(), clientOptions...)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+
+		options = append(options, sdktrace.WithBatcher(exporter))
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+		}
+
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+
+	provider := sdktrace.NewTracerProvider(options...)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider, nil
+}
+
+// Tracing starts a span for every request, continuing the trace of an
+// incoming traceparent header. It has to run after Inject: the request
+// context carries the span, and the database of the request dependencies
+// is bound to it, so every query and Redis command a service makes with
+// them ends up in the trace of the request.
+func Tracing(skipper func(c echo.Context) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skipper != nil && skipper(c) {
+				return next(c)
+			}
+
+			request := c.Request()
+			route := c.Path()
+
+			ctx := otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+			ctx, span := Tracer().Start(ctx, request.Method+" "+route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPMethod(request.Method),
+					semconv.HTTPRoute(route),
+					semconv.HTTPTarget(request.URL.RequestURI()),
+				),
+			)
+			defer span.End()
+
+			deps := *DepsFrom(ctx)
+			deps.DB = deps.DB.WithContext(ctx)
+
+			c.SetRequest(request.WithContext(WithDependencies(ctx, &deps)))
+
+			err := next(c)
+			status := responseStatus(c, err)
+
+			span.SetAttributes(semconv.HTTPStatusCode(status))
+
+			if status >= 500 {
+				span.SetStatus(codes.Error, fmt.Sprint(err))
+			}
+
+			return err
+		}
+	}
+}
+
+// queryTracing is a GORM plugin putting a span around every query made
+// with a context carrying one.
+type queryTracing struct{}
+
+const querySpanKey = "tracing:span"
+
+func (queryTracing) Name() string {
+	return "tracing"
+}
+
+func (queryTracing) Initialize(db *gorm.DB) error {
+	before := func(operation string) func(db *gorm.DB) {
+		return func(db *gorm.DB) {
+			ctx := db.Statement.Context
+
+			if !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+				return
+			}
+
+			_, span := Tracer().Start(ctx, "db "+operation,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperation(operation)),
+			)
+
+			db.InstanceSet(querySpanKey, span)
+		}
+	}
+
+	after := func(db *gorm.DB) {
+		value, ok := db.InstanceGet(querySpanKey)
+
+		if !ok {
+			return
+		}
+
+		span := value.(trace.Span)
+
+		span.SetAttributes(semconv.DBSQLTable(db.Statement.Table), semconv.DBStatement(db.Statement.SQL.String()))
+
+		if db.Error != nil && db.Error != gorm.ErrRecordNotFound {
+			span.RecordError(db.Error)
+			span.SetStatus(codes.Error, db.Error.Error())
+		}
+
+		span.End()
+	}
+
+	register := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", db.Callback().Create().Before("gorm:create").Register, db.Callback().Create().After("gorm:create").Register},
+		{"query", db.Callback().Query().Before("gorm:query").Register, db.Callback().Query().After("gorm:query").Register},
+		{"update", db.Callback().Update().Before("gorm:update").Register, db.Callback().Update().After("gorm:update").Register},
+		{"delete", db.Callback().Delete().Before("gorm:delete").Register, db.Callback().Delete().After("gorm:delete").Register},
+		{"row", db.Callback().Row().Before("gorm:row").Register, db.Callback().Row().After("gorm:row").Register},
+		{"raw", db.Callback().Raw().Before("gorm:raw").Register, db.Callback().Raw().After("gorm:raw").Register},
+	}
+
+	for _, r := range register {
+		if err := r.before("tracing:before_"+r.operation, before(r.operation)); err != nil {
+			return err
+		}
+
+		if err := r.after("tracing:after_"+r.operation, after); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// redisTracing is a go-redis hook putting a span around every command made
+// with a context carrying one.
+type redisTracing struct{}
+
+func (redisTracing) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return startRedisSpan(ctx, cmd.FullName(), []redis.Cmder{cmd}), nil
+}
+
+func (redisTracing) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endRedisSpan(ctx, []redis.Cmder{cmd})
+	return nil
+}
+
+func (redisTracing) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return startRedisSpan(ctx, "pipeline", cmds), nil
+}
+
+func (redisTracing) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	endRedisSpan(ctx, cmds)
+	return nil
+}
+
+func startRedisSpan(ctx context.Context, name string, cmds []redis.Cmder) context.Context {
+	if !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return ctx
+	}
+
+	names := make([]string, len(cmds))
+
+	for i, cmd := range cmds {
+		names[i] = cmd.Name()
+	}
+
+	ctx, _ = Tracer().Start(ctx, "redis "+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperation(strings.Join(names, " "))),
+	)
+
+	return ctx
+}
+
+func endRedisSpan(ctx context.Context, cmds []redis.Cmder) {
+	span := trace.SpanFromContext(ctx)
+
+	if !span.IsRecording() {
+		return
+	}
+
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && err != redis.Nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+
+	span.End()
+}
@@ -1,38 +1,80 @@
+// Command sahamrakyat_test runs the API and the maintenance tasks around it.
+//
+//	sahamrakyat_test [serve]                        run the HTTP API and background workers
+//	sahamrakyat_test migrate up|down [steps]|status|create <name>
+//	sahamrakyat_test seed [-profile small|medium|load-test] [-seed n] [-truncate]
+//	sahamrakyat_test cache flush [prefix]           delete cached keys, all or starting with prefix
+//	sahamrakyat_test users create -name ... -email ... -password ... [-role user|admin]
+//	sahamrakyat_test orders expire                  expire orders past their expired_at now
+//	sahamrakyat_test stocks import -file listing.csv
+//	sahamrakyat_test export orders|users|histories [-out file.csv]
+//
+// Every command reads the same configuration as the server, see
+// helpers.Config.
 package main
 
 import (
+	"fmt"
+	"log"
 	"os"
-	"sahamrakyat_test/database/migrations"
-	"sahamrakyat_test/database/seeds"
 	"sahamrakyat_test/helpers"
-	"sahamrakyat_test/routes"
-
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
+	"sort"
 )
 
+var commands = map[string]func(args []string){
+	"serve":   serve,
+	"migrate": migrate,
+	"seed":    seed,
+	"cache":   cache,
+	"users":   users,
+	"orders":  orders,
+	"stocks":  stocks,
+	"export":  export,
+}
+
 func main() {
-	app := echo.New()
+	// Without a command the server is started, as it always was.
+	if len(os.Args) < 2 {
+		serve(nil)
+		return
+	}
 
-	routes.Init(app)
+	command, ok := commands[os.Args[1]]
+
+	if !ok {
+		usage()
+	}
+
+	command(os.Args[2:])
+}
 
-	helpers.LoadEnvironment(app)
+func usage() {
+	names := []string{}
 
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
-	migrations.Migrate(db)
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [arguments]\n\ncommands: %v\n", os.Args[0], names)
+	os.Exit(2)
+}
+
+// open loads the configuration and connects to everything it describes,
+// exiting when either fails.
+func open() *helpers.Dependencies {
+	config, err := helpers.LoadConfig()
+
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	app.Use(middleware.CORS())
-	// app.Use(middleware.CSRF()) // Not suitable for API used by mobile apps
-	app.Use(middleware.Gzip())
-	app.Use(middleware.Logger())
-	app.Use(middleware.Recover())
-	app.Use(middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(20)))
-	// app.Use(middleware.Secure()) // using X-Xss-Protection is known problematic (Find Chrome Bug report for about X-Xss-Protection)
+	deps, err := helpers.Open(config)
 
-	if os.Getenv("APP_ENV") == "development" {
-		seeds.Seed(db)
-		helpers.ClearCache()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	app.Logger.Fatal(app.Start(":5000"))
+	return deps
 }
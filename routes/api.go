@@ -1,35 +1,115 @@
 package routes
 
 import (
+	apikeysController "sahamrakyat_test/apikeys/controller"
+	authController "sahamrakyat_test/auth/controller"
+	authMiddleware "sahamrakyat_test/auth/middleware"
+	exportsController "sahamrakyat_test/exports/controller"
+	healthController "sahamrakyat_test/health/controller"
+	"sahamrakyat_test/helpers"
 	historiesController "sahamrakyat_test/histories/controller"
+	idempotencyMiddleware "sahamrakyat_test/idempotency/middleware"
+	importsController "sahamrakyat_test/imports/controller"
+	openapiController "sahamrakyat_test/openapi/controller"
+	openapiMiddleware "sahamrakyat_test/openapi/middleware"
+	openapiService "sahamrakyat_test/openapi/service"
 	ordersController "sahamrakyat_test/orders/controller"
+	portfoliosController "sahamrakyat_test/portfolios/controller"
+	ratelimitMiddleware "sahamrakyat_test/ratelimit/middleware"
+	stocksController "sahamrakyat_test/stocks/controller"
+	tradesController "sahamrakyat_test/trades/controller"
 	usersController "sahamrakyat_test/users/controller"
+	walletsController "sahamrakyat_test/wallets/controller"
 
 	"github.com/labstack/echo/v4"
 )
 
 func Init(app *echo.Echo) {
+	// Probes and metrics sit outside /api so they are neither rate limited nor need a token.
+	app.GET("/healthz", healthController.Live)
+	app.GET("/readyz", healthController.Ready)
+	app.GET("/status", healthController.Status, authMiddleware.Authenticate(), authMiddleware.Require("status:read"))
+	app.GET("/metrics", helpers.MetricsHandler())
+	// The API document and its reference page need no token either.
+	document := &openapiService.Document{}
+	app.GET("/api/v1/openapi.json", openapiController.JSON(document))
+	app.GET("/api/v1/docs", openapiController.Docs(Info.Title, "/api/v1/openapi.json"))
+
 	apiGroup := app.Group("/api")
 	// v1
 	apiv1Group := apiGroup.Group("/v1")
+	// Every route below requires a token or API key, and the permission it names on top.
+	// Rate limits are counted per IP address before authentication, so failed attempts count too,
+	// and per API key or user instead once the client is known.
+	// Parameters and bodies are then checked against the API document before any controller runs.
+	apiv1Group.Use(ratelimitMiddleware.LimitAnonymous(), authMiddleware.Authenticate(), ratelimitMiddleware.Limit(), openapiMiddleware.Validate(document))
+	authGroup := apiv1Group.Group("/auth")
+	authGroup.POST("/signup", authController.SignUp, idempotencyMiddleware.Idempotent())
+	authGroup.POST("/login", authController.Login)
+	authGroup.POST("/refresh", authController.Refresh)
+	authGroup.POST("/logout", authController.Logout)
+	authGroup.GET("/me", authController.Me)
+	authGroup.POST("/password", authController.ChangePassword)
+	apiKeysGroup := apiv1Group.Group("/api-keys")
+	apiKeysGroup.GET("", apikeysController.GetAll, authMiddleware.Require("api_keys:manage"))
+	apiKeysGroup.POST("", apikeysController.Create, authMiddleware.Require("api_keys:manage"))
+	apiKeysGroup.DELETE("/:id", apikeysController.Revoke, authMiddleware.Require("api_keys:manage"))
 	ordersGroup := apiv1Group.Group("/orders")
-	ordersGroup.GET("", ordersController.GetAll)
-	ordersGroup.GET("/:id", ordersController.Get)
-	ordersGroup.POST("", ordersController.Create)
-	ordersGroup.PUT("/:id", ordersController.Update)
-	ordersGroup.DELETE("/:id", ordersController.Delete)
+	ordersGroup.GET("", ordersController.GetAll, authMiddleware.Require("orders:read"))
+	ordersGroup.GET("/export", exportsController.Orders, authMiddleware.Require("orders:read"))
+	ordersGroup.POST("/import", importsController.Orders, authMiddleware.Require("orders:write"))
+	ordersGroup.GET("/import/errors/:id", importsController.OrdersErrors, authMiddleware.Require("orders:write"))
+	ordersGroup.POST("/bulk", ordersController.BulkCreate, authMiddleware.Require("orders:write"), idempotencyMiddleware.Idempotent())
+	ordersGroup.PATCH("/bulk", ordersController.BulkUpdate, authMiddleware.Require("orders:write"))
+	ordersGroup.DELETE("/bulk", ordersController.BulkDelete, authMiddleware.Require("orders:write"))
+	ordersGroup.GET("/:id", ordersController.Get, authMiddleware.Require("orders:read"))
+	ordersGroup.POST("", ordersController.Create, authMiddleware.Require("orders:write"), idempotencyMiddleware.Idempotent())
+	ordersGroup.PUT("/:id", ordersController.Update, authMiddleware.Require("orders:write"))
+	ordersGroup.DELETE("/:id", ordersController.Delete, authMiddleware.Require("orders:write"))
 	usersGroup := apiv1Group.Group("/users")
-	usersGroup.GET("", usersController.GetAll)
-	usersGroup.GET("/:id", usersController.Get)
-	usersGroup.POST("", usersController.Create)
-	usersGroup.PUT("/:id", usersController.Update)
-	usersGroup.DELETE("/:id", usersController.Delete)
+	usersGroup.GET("", usersController.GetAll, authMiddleware.Require("users:read"))
+	usersGroup.GET("/export", exportsController.Users, authMiddleware.Require("users:read"))
+	usersGroup.POST("/import", importsController.Users, authMiddleware.Require("users:manage"))
+	usersGroup.GET("/import/errors/:id", importsController.UsersErrors, authMiddleware.Require("users:manage"))
+	usersGroup.POST("/bulk", usersController.BulkCreate, authMiddleware.Require("users:manage"), idempotencyMiddleware.Idempotent())
+	usersGroup.PATCH("/bulk", usersController.BulkUpdate, authMiddleware.Require("users:manage"))
+	usersGroup.DELETE("/bulk", usersController.BulkDelete, authMiddleware.Require("users:manage"))
+	usersGroup.GET("/:id", usersController.Get, authMiddleware.Require("users:read"))
+	usersGroup.POST("", usersController.Create, authMiddleware.Require("users:manage"), idempotencyMiddleware.Idempotent())
+	usersGroup.PUT("/:id", usersController.Update, authMiddleware.Require("users:write"))
+	usersGroup.DELETE("/:id", usersController.Delete, authMiddleware.Require("users:manage"))
+	usersGroup.GET("/:id/portfolio", portfoliosController.Get, authMiddleware.Require("portfolios:read"))
+	usersGroup.GET("/:id/wallet", walletsController.Get, authMiddleware.Require("wallets:read"))
+	usersGroup.GET("/:id/wallet/transactions", walletsController.GetAll, authMiddleware.Require("wallets:read"))
+	usersGroup.POST("/:id/wallet/deposits", walletsController.Deposit, authMiddleware.Require("wallets:manage"), idempotencyMiddleware.Idempotent())
+	usersGroup.POST("/:id/wallet/withdrawals", walletsController.Withdraw, authMiddleware.Require("wallets:manage"), idempotencyMiddleware.Idempotent())
+	usersGroup.PUT("/:id/role", usersController.AssignRole, authMiddleware.Require("users:manage"))
 	orderHistoriesGroup := apiv1Group.Group("/histories")
-	orderHistoriesGroup.GET("", historiesController.GetAll)
-	orderHistoriesGroup.GET("/:id", historiesController.Get)
-	orderHistoriesGroup.POST("", historiesController.Create)
-	orderHistoriesGroup.PUT("/:id", historiesController.Update)
-	orderHistoriesGroup.DELETE("/:id", historiesController.Delete)
+	orderHistoriesGroup.GET("", historiesController.GetAll, authMiddleware.Require("histories:read"))
+	orderHistoriesGroup.GET("/export", exportsController.Histories, authMiddleware.Require("histories:read"))
+	orderHistoriesGroup.GET("/:id", historiesController.Get, authMiddleware.Require("histories:read"))
+	orderHistoriesGroup.POST("", historiesController.Create, authMiddleware.Require("histories:write"), idempotencyMiddleware.Idempotent())
+	orderHistoriesGroup.PUT("/:id", historiesController.Update, authMiddleware.Require("histories:write"))
+	orderHistoriesGroup.DELETE("/:id", historiesController.Delete, authMiddleware.Require("histories:write"))
+	stocksGroup := apiv1Group.Group("/stocks")
+	stocksGroup.GET("", stocksController.GetAll, authMiddleware.Require("stocks:read"))
+	stocksGroup.GET("/:id", stocksController.Get, authMiddleware.Require("stocks:read"))
+	stocksGroup.POST("", stocksController.Create, authMiddleware.Require("stocks:write"), idempotencyMiddleware.Idempotent())
+	stocksGroup.PUT("/:id", stocksController.Update, authMiddleware.Require("stocks:write"))
+	stocksGroup.DELETE("/:id", stocksController.Delete, authMiddleware.Require("stocks:write"))
+	tradesGroup := apiv1Group.Group("/trades")
+	tradesGroup.GET("", tradesController.GetAll, authMiddleware.Require("trades:read"))
+	tradesGroup.GET("/:id", tradesController.Get, authMiddleware.Require("trades:read"))
+	// The document covers every route above, so it is built once they are all registered.
+	// A route without an entry in Operations fails the routes test, and serving the document here.
+	built, err := openapiService.Build(Info, app.Routes(), Operations)
+
+	if err != nil {
+		app.Logger.Error(err)
+	} else {
+		*document = *built
+	}
+
 	// v2
 	// Note: If you had breaking change in API, use new version for preserving old API while creating new one
 	// ...
@@ -0,0 +1,63 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	openapiService "sahamrakyat_test/openapi/service"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestEveryRouteIsDocumented(t *testing.T) {
+	app := echo.New()
+	Init(app)
+
+	if missing := openapiService.Missing(app.Routes(), Operations); len(missing) > 0 {
+		t.Fatalf("routes without an entry in Operations:\n%s", strings.Join(missing, "\n"))
+	}
+}
+
+func TestOperationsMatchRoutes(t *testing.T) {
+	app := echo.New()
+	Init(app)
+
+	registered := map[string]bool{}
+
+	for _, route := range openapiService.Documented(app.Routes()) {
+		registered[openapiService.Key(route)] = true
+	}
+
+	for key := range Operations {
+		if !registered[key] {
+			t.Errorf("operation %q has no route", key)
+		}
+	}
+}
+
+func TestDocumentIsServed(t *testing.T) {
+	app := echo.New()
+	Init(app)
+
+	recorder := httptest.NewRecorder()
+	app.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /api/v1/openapi.json answered %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	document := struct {
+		OpenAPI string                            `json:"openapi"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+	}{}
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+
+	if document.OpenAPI != "3.1.0" || document.Paths["/api/v1/orders/{id}"]["get"] == nil {
+		t.Fatalf("unexpected document: %s", recorder.Body.String())
+	}
+}
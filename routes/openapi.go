@@ -0,0 +1,147 @@
+package routes
+
+import (
+	"net/http"
+	apikeysService "sahamrakyat_test/apikeys/service"
+	authService "sahamrakyat_test/auth/service"
+	"sahamrakyat_test/database"
+	healthService "sahamrakyat_test/health/service"
+	"sahamrakyat_test/helpers"
+	importsService "sahamrakyat_test/imports/service"
+	openapiService "sahamrakyat_test/openapi/service"
+	ordersService "sahamrakyat_test/orders/service"
+	portfoliosService "sahamrakyat_test/portfolios/service"
+	usersService "sahamrakyat_test/users/service"
+	walletsService "sahamrakyat_test/wallets/service"
+)
+
+// Info heads the OpenAPI document.
+var Info = openapiService.Info{
+	Title:       "Saham Rakyat API",
+	Version:     "1.0.0",
+	Description: "Orders, users and histories of a stock trading app. Responses wrap their data in {statusCode, message, data}.",
+}
+
+// Bodies of the bulk and import endpoints. Items are checked one by one and
+// reported on in the response, so they are not held to the schema of their
+// resource here.
+type (
+	bulkItems struct {
+		Mode  string                   `json:"mode" validate:"omitempty,oneof=atomic best_effort" doc:"atomic writes every item or none, best_effort writes the valid ones. Defaults to atomic."`
+		Items []map[string]interface{} `json:"items" validate:"required,min=1,max=1000"`
+	}
+
+	bulkIDs struct {
+		Mode string `json:"mode" validate:"omitempty,oneof=atomic best_effort" doc:"atomic deletes every item or none, best_effort deletes the ones it can. Defaults to atomic."`
+		IDs  []uint `json:"ids" validate:"required,min=1,max=1000"`
+	}
+
+	importForm struct {
+		File   openapiService.Binary `json:"file" validate:"required" doc:"CSV file with a header row of JSON field names."`
+		Mode   string                `json:"mode" validate:"omitempty,oneof=atomic best_effort" doc:"Defaults to best_effort."`
+		DryRun bool                  `json:"dry_run" doc:"Only check the rows."`
+	}
+)
+
+var page = []*openapiService.Parameter{
+	{Name: "take", In: "query", Description: "Number of records to return.", Schema: &openapiService.Schema{Types: []string{"integer"}, Minimum: number(0)}},
+	{Name: "skip", In: "query", Description: "Number of records to skip.", Schema: &openapiService.Schema{Types: []string{"integer"}, Minimum: number(0)}},
+}
+
+// period filters lists on the time their records were created.
+var period = []*openapiService.Parameter{
+	{Name: "from", In: "query", Description: "Created at or after.", Schema: &openapiService.Schema{Types: []string{"string"}, Format: "date-time"}},
+	{Name: "to", In: "query", Description: "Created before.", Schema: &openapiService.Schema{Types: []string{"string"}, Format: "date-time"}},
+}
+
+var orderFilters = append(append([]*openapiService.Parameter{
+	{Name: "status", In: "query", Schema: &openapiService.Schema{Types: []string{"string"}, Enum: []interface{}{"open", "partial", "filled", "cancelled", "expired"}}},
+	{Name: "side", In: "query", Schema: &openapiService.Schema{Types: []string{"string"}, Enum: []interface{}{"buy", "sell"}}},
+	{Name: "stocks_id", In: "query", Schema: &openapiService.Schema{Types: []string{"integer"}, Minimum: number(1)}},
+}, period...), page...)
+
+var userFilters = append([]*openapiService.Parameter{
+	{Name: "email", In: "query", Schema: &openapiService.Schema{Types: []string{"string"}, Format: "email"}},
+	{Name: "phone", In: "query", Schema: &openapiService.Schema{Types: []string{"string"}}},
+	{Name: "roles_id", In: "query", Schema: &openapiService.Schema{Types: []string{"integer"}, Minimum: number(1)}},
+}, page...)
+
+var historyFilters = append(append([]*openapiService.Parameter{}, period...), page...)
+
+var transactionFilters = append(append([]*openapiService.Parameter{}, period...), page...)
+
+var exportFormat = &openapiService.Parameter{Name: "format", In: "query", Description: "File format, csv by default.", Schema: &openapiService.Schema{Types: []string{"string"}, Enum: []interface{}{"csv", "xlsx"}}}
+
+var errorFileID = &openapiService.Parameter{Name: "id", In: "path", Required: true, Schema: &openapiService.Schema{Types: []string{"string"}, Pattern: "^[0-9a-f]{32}$"}}
+
+var exportFiles = []string{"text/csv", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"}
+
+// Operations documents every route registered in Init, a route without an
+// entry fails the routes test.
+var Operations = map[string]openapiService.Operation{
+	"GET /healthz":                              {Summary: "Liveness probe", Public: true},
+	"GET /readyz":                               {Summary: "Readiness probe", Description: "Answers 503 while the database or Redis is unreachable or a migration is pending.", Public: true, Data: healthService.Readiness{}},
+	"GET /status":                               {Summary: "Operator status", Permission: "status:read", Data: healthService.Details{}},
+	"GET /metrics":                              {Summary: "Prometheus metrics", Public: true, Files: []string{"text/plain"}},
+	"GET /api/v1/openapi.json":                  {Summary: "This document", Public: true, Files: []string{"application/json"}},
+	"GET /api/v1/docs":                          {Summary: "API reference page", Public: true, Files: []string{"text/html"}},
+	"POST /api/v1/auth/signup":                  {Summary: "Sign up", Public: true, Body: authService.Signup{}, Data: database.Users{}, Status: http.StatusCreated},
+	"POST /api/v1/auth/login":                   {Summary: "Log in", Public: true, Body: authService.Credentials{}, Data: authService.Tokens{}},
+	"POST /api/v1/auth/refresh":                 {Summary: "Refresh tokens", Public: true, Body: authService.RefreshRequest{}, Data: authService.Tokens{}},
+	"POST /api/v1/auth/logout":                  {Summary: "Log out", Description: "Revokes the access token and, when sent, the refresh token.", Body: authService.RefreshRequest{}, Partial: true, Data: database.Users{}},
+	"GET /api/v1/auth/me":                       {Summary: "Current user", Data: database.Users{}},
+	"POST /api/v1/auth/password":                {Summary: "Change password", Body: authService.PasswordChange{}, Data: authService.Tokens{}},
+	"GET /api/v1/api-keys":                      {Summary: "List API keys", Permission: "api_keys:manage", Data: []database.APIKeys{}},
+	"POST /api/v1/api-keys":                     {Summary: "Create an API key", Description: "The key is only returned in this response.", Permission: "api_keys:manage", Body: database.APIKeys{}, Data: apikeysService.Created{}, Status: http.StatusCreated},
+	"DELETE /api/v1/api-keys/:id":               {Summary: "Revoke an API key", Permission: "api_keys:manage", Data: database.APIKeys{}},
+	"GET /api/v1/orders":                        {Summary: "List orders", Permission: "orders:read", Parameters: orderFilters, Data: []database.Orders{}},
+	"GET /api/v1/orders/export":                 {Summary: "Export orders", Permission: "orders:read", Parameters: append([]*openapiService.Parameter{exportFormat}, orderFilters...), Files: exportFiles},
+	"POST /api/v1/orders/import":                {Summary: "Import orders from CSV", Permission: "orders:write", Body: importForm{}, Form: true, Data: importsService.Report{}},
+	"GET /api/v1/orders/import/errors/:id":      {Summary: "Download the invalid rows of an order import", Permission: "orders:write", Parameters: []*openapiService.Parameter{errorFileID}, Files: []string{"text/csv"}},
+	"POST /api/v1/orders/bulk":                  {Summary: "Create orders in bulk", Permission: "orders:write", Body: bulkItems{}, Data: helpers.BulkReport{}},
+	"PATCH /api/v1/orders/bulk":                 {Summary: "Update orders in bulk", Description: "Items hold the id of an order and the name or expired_at to change.", Permission: "orders:write", Body: bulkItems{}, Data: helpers.BulkReport{}},
+	"DELETE /api/v1/orders/bulk":                {Summary: "Delete orders in bulk", Permission: "orders:write", Body: bulkIDs{}, Data: helpers.BulkReport{}},
+	"GET /api/v1/orders/:id":                    {Summary: "Get an order", Permission: "orders:read", Data: database.Orders{}},
+	"POST /api/v1/orders":                       {Summary: "Create an order", Permission: "orders:write", Body: database.Orders{}, Data: database.Orders{}, Status: http.StatusCreated},
+	"PUT /api/v1/orders/:id":                    {Summary: "Update an order", Description: "Changes the name or expired_at of an order.", Permission: "orders:write", Body: ordersService.OrderPatch{}, Partial: true, Data: database.Orders{}},
+	"DELETE /api/v1/orders/:id":                 {Summary: "Delete an order", Permission: "orders:write", Data: database.Orders{}},
+	"GET /api/v1/users":                         {Summary: "List users", Permission: "users:read", Parameters: userFilters, Data: []database.Users{}},
+	"GET /api/v1/users/export":                  {Summary: "Export users", Permission: "users:read", Parameters: append([]*openapiService.Parameter{exportFormat}, userFilters...), Files: exportFiles},
+	"POST /api/v1/users/import":                 {Summary: "Import users from CSV", Permission: "users:manage", Body: importForm{}, Form: true, Data: importsService.Report{}},
+	"GET /api/v1/users/import/errors/:id":       {Summary: "Download the invalid rows of a user import", Permission: "users:manage", Parameters: []*openapiService.Parameter{errorFileID}, Files: []string{"text/csv"}},
+	"POST /api/v1/users/bulk":                   {Summary: "Create users in bulk", Permission: "users:manage", Body: bulkItems{}, Data: helpers.BulkReport{}},
+	"PATCH /api/v1/users/bulk":                  {Summary: "Update users in bulk", Description: "Items hold the id of a user and the full_name, email or phone to change.", Permission: "users:manage", Body: bulkItems{}, Data: helpers.BulkReport{}},
+	"DELETE /api/v1/users/bulk":                 {Summary: "Delete users in bulk", Permission: "users:manage", Body: bulkIDs{}, Data: helpers.BulkReport{}},
+	"GET /api/v1/users/:id":                     {Summary: "Get a user", Permission: "users:read", Data: database.Users{}},
+	"POST /api/v1/users":                        {Summary: "Create a user", Permission: "users:manage", Body: database.Users{}, Data: database.Users{}, Status: http.StatusCreated},
+	"PUT /api/v1/users/:id":                     {Summary: "Update a user", Description: "Changes the full_name, email or phone of a user.", Permission: "users:write", Body: usersService.UserPatch{}, Partial: true, Data: database.Users{}},
+	"DELETE /api/v1/users/:id":                  {Summary: "Delete a user", Permission: "users:manage", Data: database.Users{}},
+	"GET /api/v1/users/:id/portfolio":           {Summary: "Portfolio of a user", Permission: "portfolios:read", Data: portfoliosService.Portfolio{}},
+	"GET /api/v1/users/:id/wallet":              {Summary: "Wallet balance of a user", Permission: "wallets:read", Data: walletsService.Balance{}},
+	"GET /api/v1/users/:id/wallet/transactions": {Summary: "Wallet transactions of a user", Permission: "wallets:read", Parameters: transactionFilters, Data: []database.WalletTransactions{}},
+	"POST /api/v1/users/:id/wallet/deposits":    {Summary: "Deposit into a wallet", Permission: "wallets:manage", Body: walletsService.Movement{}, Data: walletsService.Balance{}},
+	"POST /api/v1/users/:id/wallet/withdrawals": {Summary: "Withdraw from a wallet", Permission: "wallets:manage", Body: walletsService.Movement{}, Data: walletsService.Balance{}},
+	"PUT /api/v1/users/:id/role":                {Summary: "Assign a role", Permission: "users:manage", Body: usersService.RoleAssignment{}, Data: database.Users{}},
+	"GET /api/v1/histories":                     {Summary: "List histories", Permission: "histories:read", Parameters: historyFilters, Data: []database.Histories{}},
+	"GET /api/v1/histories/export":              {Summary: "Export histories", Permission: "histories:read", Parameters: append([]*openapiService.Parameter{exportFormat}, historyFilters...), Files: exportFiles},
+	"GET /api/v1/histories/:id":                 {Summary: "Get a history", Permission: "histories:read", Data: database.Histories{}},
+	"POST /api/v1/histories":                    {Summary: "Create a history", Permission: "histories:write", Body: database.Histories{}, Data: database.Histories{}, Status: http.StatusCreated},
+	"PUT /api/v1/histories/:id":                 {Summary: "Update a history", Permission: "histories:write", Body: database.Histories{}, Partial: true, Data: database.Histories{}},
+	"DELETE /api/v1/histories/:id":              {Summary: "Delete a history", Permission: "histories:write", Data: database.Histories{}},
+	"GET /api/v1/stocks":                        {Summary: "List stocks", Permission: "stocks:read", Parameters: page, Data: []database.Stocks{}},
+	"GET /api/v1/stocks/:id":                    {Summary: "Get a stock", Permission: "stocks:read", Data: database.Stocks{}},
+	"POST /api/v1/stocks":                       {Summary: "Create a stock", Permission: "stocks:write", Body: database.Stocks{}, Data: database.Stocks{}, Status: http.StatusCreated},
+	"PUT /api/v1/stocks/:id":                    {Summary: "Update a stock", Permission: "stocks:write", Body: database.Stocks{}, Partial: true, Data: database.Stocks{}},
+	"DELETE /api/v1/stocks/:id":                 {Summary: "Delete a stock", Permission: "stocks:write", Data: database.Stocks{}},
+	"GET /api/v1/trades": {Summary: "List trades", Permission: "trades:read", Parameters: append([]*openapiService.Parameter{
+		{Name: "user_id", In: "query", Description: "Only trades of this user's orders, always the caller without trades:manage.", Schema: &openapiService.Schema{Types: []string{"integer"}, Minimum: number(1)}},
+		{Name: "ticker", In: "query", Schema: &openapiService.Schema{Types: []string{"string"}}},
+		{Name: "from", In: "query", Description: "Executed at or after.", Schema: &openapiService.Schema{Types: []string{"string"}, Format: "date-time"}},
+		{Name: "to", In: "query", Description: "Executed before.", Schema: &openapiService.Schema{Types: []string{"string"}, Format: "date-time"}},
+	}, page...), Data: []database.Trades{}},
+	"GET /api/v1/trades/:id": {Summary: "Get a trade", Permission: "trades:read", Data: database.Trades{}},
+}
+
+func number(value float64) *float64 {
+	return &value
+}
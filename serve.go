@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sahamrakyat_test/database/migrations"
+	grpcController "sahamrakyat_test/grpc/controller"
+	grpcMiddleware "sahamrakyat_test/grpc/middleware"
+	healthController "sahamrakyat_test/health/controller"
+	"sahamrakyat_test/helpers"
+	ordersService "sahamrakyat_test/orders/service"
+	"sahamrakyat_test/routes"
+	"sync"
+	"syscall"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"google.golang.org/grpc"
+)
+
+// serve runs the HTTP API, the gRPC API and the background workers until
+// SIGINT or SIGTERM.
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Parse(args)
+
+	deps := open()
+	config := deps.Config
+
+	deps.Logger.Infof("Loaded configuration:\n%s", config)
+
+	app := echo.New()
+	app.IPExtractor = helpers.IPExtractor(config.App)
+
+	routes.Init(app)
+
+	if config.Database.MigrateOnStart {
+		if err := migrations.Migrate(deps.DB, config); err != nil {
+			deps.Logger.Fatalf("Failed to migrate database: %s", err)
+		}
+	}
+
+	releaseBooks, err := ordersService.ClaimBooks(context.Background(), deps.DB)
+
+	if err != nil {
+		deps.Logger.Fatalf("Failed to claim the order books: %s", err)
+	}
+
+	app.Use(helpers.Inject(deps))
+	app.Use(helpers.Tracing(healthController.Skipper))
+	app.Use(middleware.CORS())
+	// app.Use(middleware.CSRF()) // Not suitable for API used by mobile apps
+	app.Use(middleware.Gzip())
+	app.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{Skipper: healthController.Skipper}))
+	app.Use(helpers.Metrics(healthController.Skipper))
+	app.Use(middleware.Recover())
+	// app.Use(middleware.Secure()) // using X-Xss-Protection is known problematic (Find Chrome Bug report for about X-Xss-Protection)
+
+	ctx := helpers.WithDependencies(context.Background(), deps)
+
+	workersCtx, stopWorkers := context.WithCancel(ctx)
+	workers := sync.WaitGroup{}
+
+	workers.Add(1)
+
+	go func() {
+		defer workers.Done()
+		ordersService.RunExpiryWorker(workersCtx)
+	}()
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	serverErr := make(chan error, 2)
+
+	go func() {
+		serverErr <- app.Start(fmt.Sprintf(":%d", config.App.Port))
+	}()
+
+	var grpcServer *grpc.Server
+
+	if config.App.GRPCPort != 0 {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", config.App.GRPCPort))
+
+		if err != nil {
+			deps.Logger.Fatalf("Failed to listen for gRPC: %s", err)
+		}
+
+		grpcServer = grpc.NewServer(grpc.ChainUnaryInterceptor(
+			grpcMiddleware.Inject(deps),
+			grpcMiddleware.Logging(),
+			grpcMiddleware.Errors(),
+			grpcMiddleware.LimitAnonymous(),
+			grpcMiddleware.Authenticate(),
+			grpcMiddleware.Limit(),
+		))
+
+		grpcController.Register(grpcServer)
+
+		deps.Logger.Infof("gRPC server started on %s", listener.Addr())
+
+		go func() {
+			serverErr <- grpcServer.Serve(listener)
+		}()
+	}
+
+	exitCode := 0
+
+	select {
+	case <-signals.Done():
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			deps.Logger.Errorf("Server stopped: %v", err)
+			exitCode = 1
+		}
+	}
+
+	shutdown(app, grpcServer, deps, stopWorkers, &workers, releaseBooks)
+
+	os.Exit(exitCode)
+}
+
+// shutdown stops accepting connections and lets in-flight requests and gRPC
+// calls finish within SHUTDOWN_TIMEOUT, then stops the background workers,
+// gives up the order books and closes the database pool, the Redis ring and
+// the log file, in that order.
+func shutdown(app *echo.Echo, grpcServer *grpc.Server, deps *helpers.Dependencies, stopWorkers context.CancelFunc, workers *sync.WaitGroup, releaseBooks func()) {
+	deps.Logger.Info("Shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), deps.Config.App.ShutdownTimeout)
+	defer cancel()
+
+	if err := app.Shutdown(ctx); err != nil {
+		deps.Logger.Errorf("Failed to drain requests: %v", err)
+	}
+
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			deps.Logger.Error("Failed to drain gRPC calls in time")
+			grpcServer.Stop()
+		}
+	}
+
+	stopWorkers()
+	workers.Wait()
+	releaseBooks()
+
+	deps.Logger.Info("Shutdown complete")
+
+	if err := deps.Close(); err != nil {
+		log.Printf("Failed to close connections: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"net/http"
+	"sahamrakyat_test/apikeys/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+func Create(c echo.Context) error {
+	data, err := service.Create(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"statusCode": http.StatusCreated,
+		"message":    "Successfully created new API key, store the key now as it is not shown again.",
+		"data":       data,
+	})
+}
+
+func GetAll(c echo.Context) error {
+	data, err := service.GetAll(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully get all API keys.",
+		"data":       data,
+	})
+}
+
+func Revoke(c echo.Context) error {
+	data, err := service.Revoke(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully revoked API key.",
+		"data":       data,
+	})
+}
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sahamrakyat_test/database"
+	"sahamrakyat_test/helpers"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Keys look like "sr_<prefix>_<secret>", the prefix identifies a key in
+// listings without revealing it.
+const keyScheme = "sr_"
+
+var ErrInvalidKey = errors.New("invalid, expired or revoked API key")
+
+// Created is returned once when a key is created, it is the only time the
+// key itself is available.
+type Created struct {
+	*database.APIKeys
+	Key string `json:"key"`
+}
+
+func Create(c echo.Context) (*Created, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	apiKey := &database.APIKeys{}
+
+	if err := c.Bind(apiKey); err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind API key.")
+	}
+
+	if err := validator.New().Struct(apiKey); err != nil {
+		logger.Error(err)
+
+		validationErrors := []echo.Map{}
+
+		if _, ok := err.(*validator.InvalidValidationError); ok {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Invalid argument passed.")
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+
+			validationErrors = append(validationErrors, echo.Map{
+				"namespace":       err.Namespace(),
+				"field":           err.Field(),
+				"structNamespace": err.StructNamespace(),
+				"structField":     err.StructField(),
+				"tag":             err.Tag(),
+				"actualTag":       err.ActualTag(),
+				"kind":            err.Kind(),
+				"type":            err.Type(),
+				"value":           err.Value(),
+				"param":           err.Param(),
+			})
+		}
+
+		return nil, echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"message": "Failed to validate API key.",
+			"error":   validationErrors,
+		})
+	}
+
+	if apiKey.ExpiresAt != nil && !apiKey.ExpiresAt.After(time.Now()) {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Expiry must be in the future.")
+	}
+
+	db := helpers.Deps(c).DB
+
+	permissions := []database.Permissions{}
+
+	db.Where("name IN ?", apiKey.Scopes).Find(&permissions)
+
+	if len(permissions) != len(unique(apiKey.Scopes)) {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Unknown scope, scopes must be permission names.")
+	}
+
+	key, prefix, err := generate()
+
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate API key.")
+	}
+
+	// Usage and revocation are only ever recorded by the service.
+	apiKey.ID = 0
+	apiKey.Prefix = prefix
+	apiKey.Hash = hash(key)
+	apiKey.Permissions = permissions
+	apiKey.LastUsedAt = nil
+	apiKey.RevokedAt = nil
+	apiKey.UsersID = nil
+
+	if user := helpers.CurrentUser(c); user != nil {
+		apiKey.UsersID = &user.ID
+	}
+
+	if result := db.Create(apiKey); result.Error != nil {
+		logger.Error(result.Error)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to create API key.")
+	}
+
+	apiKey.Scopes = scopes(apiKey)
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return &Created{APIKeys: apiKey, Key: key}, nil
+}
+
+func GetAll(c echo.Context) (*[]database.APIKeys, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	db := helpers.Deps(c).DB
+
+	apiKeys := []database.APIKeys{}
+
+	if result := db.Preload("Permissions").Order("id").Find(&apiKeys); result.Error != nil {
+		logger.Error(result.Error)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get API keys.")
+	}
+
+	for i := range apiKeys {
+		apiKeys[i].Scopes = scopes(&apiKeys[i])
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return &apiKeys, nil
+}
+
+// Revoke stops a key from working, it is kept so listings still show it.
+func Revoke(c echo.Context) (*database.APIKeys, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse API key id.")
+	}
+
+	db := helpers.Deps(c).DB
+
+	apiKey := &database.APIKeys{}
+
+	if result := db.Preload("Permissions").Limit(1).Find(apiKey, id); result.Error != nil || result.RowsAffected < 1 {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "API key not found.")
+	}
+
+	if apiKey.RevokedAt == nil {
+		now := time.Now()
+
+		if result := db.Model(apiKey).UpdateColumn("revoked_at", now); result.Error != nil {
+			logger.Error(result.Error)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to revoke API key.")
+		}
+
+		apiKey.RevokedAt = &now
+	}
+
+	apiKey.Scopes = scopes(apiKey)
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return apiKey, nil
+}
+
+// Verify returns the key with its scopes, or ErrInvalidKey when it is
+// unknown, expired or revoked. Keys are looked up on every request so a
+// revocation applies right away.
+func Verify(ctx context.Context, db *gorm.DB, key string) (*database.APIKeys, error) {
+	apiKey := &database.APIKeys{}
+
+	result := db.WithContext(ctx).Preload("Permissions").Where("hash = ?", hash(key)).Limit(1).Find(apiKey)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	now := time.Now()
+
+	if result.RowsAffected < 1 || apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && !apiKey.ExpiresAt.After(now)) {
+		return nil, ErrInvalidKey
+	}
+
+	// Last use is tracked to the minute, sparing a write on every request.
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= time.Minute {
+		db.WithContext(ctx).Model(apiKey).UpdateColumn("last_used_at", now)
+		apiKey.LastUsedAt = &now
+	}
+
+	apiKey.Scopes = scopes(apiKey)
+
+	return apiKey, nil
+}
+
+func generate() (string, string, error) {
+	prefix := make([]byte, 4)
+	secret := make([]byte, 32)
+
+	if _, err := rand.Read(prefix); err != nil {
+		return "", "", err
+	}
+
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+
+	p := hex.EncodeToString(prefix)
+
+	return keyScheme + p + "_" + base64.RawURLEncoding.EncodeToString(secret), p, nil
+}
+
+func hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func scopes(apiKey *database.APIKeys) []string {
+	names := []string{}
+
+	for _, permission := range apiKey.Permissions {
+		names = append(names, permission.Name)
+	}
+
+	return names
+}
+
+func unique(values []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
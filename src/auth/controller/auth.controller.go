@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"net/http"
+	"sahamrakyat_test/auth/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+func Login(c echo.Context) error {
+	data, err := service.Login(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully logged in.",
+		"data":       data,
+	})
+}
+
+func Refresh(c echo.Context) error {
+	data, err := service.Refresh(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully refreshed tokens.",
+		"data":       data,
+	})
+}
+
+func Logout(c echo.Context) error {
+	data, err := service.Logout(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully logged out.",
+		"data":       data,
+	})
+}
+
+func Me(c echo.Context) error {
+	data, err := service.Me(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully get current user.",
+		"data":       data,
+	})
+}
+
+func SignUp(c echo.Context) error {
+	data, err := service.SignUp(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"statusCode": http.StatusCreated,
+		"message":    "Successfully signed up.",
+		"data":       data,
+	})
+}
+
+func ChangePassword(c echo.Context) error {
+	data, err := service.ChangePassword(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully changed password.",
+		"data":       data,
+	})
+}
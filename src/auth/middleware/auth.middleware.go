@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	apikeysService "sahamrakyat_test/apikeys/service"
+	authService "sahamrakyat_test/auth/service"
+	"sahamrakyat_test/database"
+	"sahamrakyat_test/helpers"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Routes reachable without a token, by method and route path.
+var publicRoutes = map[string]bool{
+	"POST /api/v1/auth/login":   true,
+	"POST /api/v1/auth/refresh": true,
+	"POST /api/v1/auth/signup":  true,
+}
+
+// Authenticate requires a valid access token or API key on every route
+// except the public ones. The user a token belongs to is available through
+// helpers.CurrentUser, a key through helpers.CurrentAPIKey.
+func Authenticate() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if publicRoutes[c.Request().Method+" "+c.Path()] {
+				return next(c)
+			}
+
+			principal, failure := Identify(c.Request().Context(), c.Request().Header.Get(echo.HeaderAuthorization))
+
+			if failure != nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, failure.Scheme)
+
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"statusCode": http.StatusUnauthorized,
+					"message":    failure.Message,
+				})
+			}
+
+			principal.Apply(c)
+
+			return next(c)
+		}
+	}
+}
+
+// Principal is who a request is made by: a user with the permissions of
+// their role, or an API key with its scopes.
+type Principal struct {
+	User        *database.Users
+	APIKey      *database.APIKeys
+	Permissions []string
+}
+
+// Apply makes the principal the current user or API key of a request.
+func (p *Principal) Apply(c echo.Context) {
+	if p.User != nil {
+		helpers.SetCurrentUser(c, p.User)
+	}
+
+	if p.APIKey != nil {
+		helpers.SetCurrentAPIKey(c, p.APIKey)
+	}
+
+	helpers.SetPermissions(c, p.Permissions)
+}
+
+// Failure is why credentials were refused, with the scheme to challenge
+// the client with.
+type Failure struct {
+	Scheme  string
+	Message string
+}
+
+// Identify checks the value of an Authorization header, either a bearer
+// access token or "ApiKey <key>". It is shared by every transport the API
+// is served on.
+func Identify(ctx context.Context, authorization string) (*Principal, *Failure) {
+	db := helpers.DepsFrom(ctx).DB
+	scheme, credentials, found := strings.Cut(authorization, " ")
+	credentials = strings.TrimSpace(credentials)
+
+	if found && strings.EqualFold(scheme, "ApiKey") {
+		apiKey, err := apikeysService.Verify(ctx, db, credentials)
+
+		if err != nil {
+			return nil, &Failure{Scheme: "ApiKey", Message: "Invalid, expired or revoked API key."}
+		}
+
+		return &Principal{APIKey: apiKey, Permissions: apiKey.Scopes}, nil
+	}
+
+	if !found || !strings.EqualFold(scheme, "Bearer") || credentials == "" {
+		return nil, &Failure{Scheme: "Bearer", Message: "Missing bearer token."}
+	}
+
+	invalid := &Failure{Scheme: "Bearer", Message: "Invalid or expired token."}
+
+	claims, err := authService.Verify(ctx, credentials, authService.TokenTypeAccess)
+
+	if err != nil {
+		return nil, invalid
+	}
+
+	user := &database.Users{}
+
+	if result := db.Limit(1).Find(user, claims.Subject); result.Error != nil || result.RowsAffected < 1 || authService.IssuedBeforePasswordChange(claims, user) {
+		return nil, invalid
+	}
+
+	permissions, err := authService.Permissions(db, user)
+
+	if err != nil {
+		return nil, invalid
+	}
+
+	return &Principal{User: user, Permissions: permissions}, nil
+}
+
+// Require rejects requests whose user was not granted the permission. It is
+// attached per route in routes.Init, after Authenticate ran on the group.
+func Require(permission string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !helpers.Can(c, permission) {
+				return c.JSON(http.StatusForbidden, echo.Map{
+					"statusCode": http.StatusForbidden,
+					"message":    "Missing permission " + permission + ".",
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,651 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sahamrakyat_test/database"
+	"sahamrakyat_test/helpers"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-playground/validator"
+	"github.com/go-redis/cache/v8"
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Users log in with either their email or their phone number.
+type Credentials struct {
+	Email    string `json:"email" validate:"required_without=Phone"`
+	Phone    string `json:"phone" validate:"required_without=Email"`
+	Password string `json:"password" validate:"required"`
+}
+
+type Signup struct {
+	FullName string `json:"full_name" validate:"required,max=255"`
+	Email    string `json:"email" validate:"required_without=Phone,omitempty,email,max=255"`
+	Phone    string `json:"phone" validate:"required_without=Email,omitempty,e164"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+}
+
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,max=72"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+type Claims struct {
+	jwt.StandardClaims
+	Type string `json:"typ"`
+	// IssuedAtMicro is IssuedAt in microseconds, as precise as the password
+	// change times it is compared to.
+	IssuedAtMicro int64 `json:"iat_us,omitempty"`
+}
+
+type keys struct {
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+var (
+	loadKeys   sync.Once
+	signing    *keys
+	signingErr error
+)
+
+func Login(c echo.Context) (*Tokens, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	credentials := &Credentials{}
+
+	if err := c.Bind(credentials); err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind credentials.")
+	}
+
+	if err := validator.New().Struct(credentials); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Email or phone and password are required.")
+	}
+
+	db := helpers.Deps(c).DB
+
+	user := &database.Users{}
+	query := db.Limit(1)
+
+	if credentials.Email != "" {
+		query = query.Where("email = ?", normalizeEmail(credentials.Email))
+	} else {
+		query = query.Where("phone = ?", strings.TrimSpace(credentials.Phone))
+	}
+
+	if result := query.Find(user); result.Error != nil || result.RowsAffected < 1 || user.Password == "" {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid credentials.")
+	}
+
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		return nil, echo.NewHTTPError(http.StatusLocked, "Account is locked after too many failed logins, try again later.")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(credentials.Password)); err != nil {
+		recordFailedLogin(helpers.Deps(c).Config.Auth, db, user)
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid credentials.")
+	}
+
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		db.Model(user).Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil})
+	}
+
+	tokens, err := Issue(c.Request().Context(), user)
+
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to issue tokens.")
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return tokens, nil
+}
+
+// Refresh exchanges a refresh token for a new pair of tokens. Refresh tokens
+// are single use, the one presented is consumed, so of two requests racing
+// with the same token only one gets new tokens.
+func Refresh(c echo.Context) (*Tokens, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	request := &RefreshRequest{}
+
+	if err := c.Bind(request); err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind refresh token.")
+	}
+
+	if err := validator.New().Struct(request); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Refresh token is required.")
+	}
+
+	claims, err := Verify(c.Request().Context(), request.RefreshToken, TokenTypeRefresh)
+
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired refresh token.")
+	}
+
+	// Verify only tells the token is still known, deleting it is what uses
+	// it up. Whoever did not delete it lost the race.
+	consumed, err := helpers.Deps(c).Redis.Del(c.Request().Context(), fmt.Sprintf("refresh:%s", claims.Id)).Result()
+
+	if err != nil || consumed != 1 {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired refresh token.")
+	}
+
+	db := helpers.Deps(c).DB
+
+	user := &database.Users{}
+
+	if result := db.Limit(1).Find(user, claims.Subject); result.Error != nil || result.RowsAffected < 1 || IssuedBeforePasswordChange(claims, user) {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired refresh token.")
+	}
+
+	tokens, err := Issue(c.Request().Context(), user)
+
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to issue tokens.")
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return tokens, nil
+}
+
+// Logout revokes the access token of the request and, when given, the
+// refresh token in the body.
+func Logout(c echo.Context) (*database.Users, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	if claims, err := Verify(c.Request().Context(), BearerToken(c), TokenTypeAccess); err == nil {
+		revoke(c.Request().Context(), claims)
+	}
+
+	request := &RefreshRequest{}
+
+	if err := c.Bind(request); err == nil && request.RefreshToken != "" {
+		if claims, err := Verify(c.Request().Context(), request.RefreshToken, TokenTypeRefresh); err == nil {
+			revoke(c.Request().Context(), claims)
+		}
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return helpers.CurrentUser(c), nil
+}
+
+func SignUp(c echo.Context) (*database.Users, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	signup := &Signup{}
+
+	if err := c.Bind(signup); err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind sign up.")
+	}
+
+	if err := validator.New().Struct(signup); err != nil {
+		logger.Error(err)
+
+		validationErrors := []echo.Map{}
+
+		if _, ok := err.(*validator.InvalidValidationError); ok {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Invalid argument passed.")
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+
+			validationErrors = append(validationErrors, echo.Map{
+				"namespace":       err.Namespace(),
+				"field":           err.Field(),
+				"structNamespace": err.StructNamespace(),
+				"structField":     err.StructField(),
+				"tag":             err.Tag(),
+				"actualTag":       err.ActualTag(),
+				"kind":            err.Kind(),
+				"type":            err.Type(),
+				"value":           err.Value(),
+				"param":           err.Param(),
+			})
+		}
+
+		return nil, echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"message": "Failed to validate sign up.",
+			"error":   validationErrors,
+		})
+	}
+
+	hash, err := HashPassword(signup.Password)
+
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to hash password.")
+	}
+
+	now := time.Now()
+
+	user := &database.Users{
+		FullName:          strings.TrimSpace(signup.FullName),
+		Password:          hash,
+		PasswordChangedAt: &now,
+	}
+
+	if signup.Email != "" {
+		email := normalizeEmail(signup.Email)
+		user.Email = &email
+	}
+
+	if signup.Phone != "" {
+		phone := strings.TrimSpace(signup.Phone)
+		user.Phone = &phone
+	}
+
+	if err := Register(helpers.Deps(c).DB, user, database.RoleUser); err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusConflict, "Email or phone is already registered.")
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return user, nil
+}
+
+// Register creates a user with the named role and the history every user
+// gets, orders are owned through it. The password must already be hashed.
+func Register(db *gorm.DB, user *database.Users, roleName string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		role := &database.Roles{}
+
+		if result := tx.Where("name = ?", roleName).Limit(1).Find(role); result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected > 0 {
+			user.RolesID = &role.ID
+		}
+
+		history := &database.Histories{Descriptions: "Account created."}
+
+		if result := tx.Create(history); result.Error != nil {
+			return result.Error
+		}
+
+		user.HistoriesID = &history.ID
+
+		return tx.Create(user).Error
+	})
+}
+
+// ChangePassword replaces the password of the authenticated user. Tokens
+// issued before the change stop working, a new pair is returned.
+func ChangePassword(c echo.Context) (*Tokens, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	user := helpers.CurrentUser(c)
+
+	if user == nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Not authenticated.")
+	}
+
+	change := &PasswordChange{}
+
+	if err := c.Bind(change); err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind password change.")
+	}
+
+	if err := validator.New().Struct(change); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Current password and a new password of 8 to 72 characters are required.")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(change.CurrentPassword)); err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Current password is incorrect.")
+	}
+
+	hash, err := HashPassword(change.NewPassword)
+
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to hash password.")
+	}
+
+	// Kept in microseconds like the database does, see IssuedAtMicro.
+	now := time.Now().Truncate(time.Microsecond)
+
+	db := helpers.Deps(c).DB
+
+	if result := db.Model(user).Updates(map[string]interface{}{"password": hash, "password_changed_at": now}); result.Error != nil {
+		logger.Error(result.Error)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to change password.")
+	}
+
+	tokens, err := Issue(c.Request().Context(), user)
+
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to issue tokens.")
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return tokens, nil
+}
+
+func Me(c echo.Context) (*database.Users, *echo.HTTPError) {
+	user := helpers.CurrentUser(c)
+
+	if user == nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Not authenticated.")
+	}
+
+	return user, nil
+}
+
+// Issue signs a new access and refresh token for a user.
+func Issue(ctx context.Context, user *database.Users) (*Tokens, error) {
+	config := helpers.DepsFrom(ctx).Config.Auth
+
+	accessToken, _, err := sign(config, user, TokenTypeAccess, config.AccessTTL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, refreshClaims, err := sign(config, user, TokenTypeRefresh, config.RefreshTTL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Refresh tokens are only valid while they are known to Redis, which
+	// lets logout and rotation revoke them before they expire.
+	cacheClient := helpers.DepsFrom(ctx).Cache
+
+	if err := cacheClient.Set(&cache.Item{
+		Ctx:            ctx,
+		Key:            fmt.Sprintf("refresh:%s", refreshClaims.Id),
+		Value:          user.ID,
+		TTL:            config.RefreshTTL,
+		SkipLocalCache: true,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &Tokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(config.AccessTTL.Seconds()),
+	}, nil
+}
+
+// Verify checks the signature, expiry, type and revocation of a token.
+func Verify(ctx context.Context, token string, tokenType string) (*Claims, error) {
+	k, err := signingKeys(helpers.DepsFrom(ctx).Config.Auth)
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != k.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+		return k.verifyKey, nil
+	})
+
+	if err != nil || !parsed.Valid || claims.Type != tokenType {
+		return nil, ErrInvalidToken
+	}
+
+	cacheClient := helpers.DepsFrom(ctx).Cache
+
+	var userID uint
+
+	if tokenType == TokenTypeRefresh {
+		if err := cacheClient.GetSkippingLocalCache(ctx, fmt.Sprintf("refresh:%s", claims.Id), &userID); err != nil {
+			return nil, ErrInvalidToken
+		}
+	} else if err := cacheClient.GetSkippingLocalCache(ctx, fmt.Sprintf("revoked:%s", claims.Id), &userID); err == nil {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// IssuedBeforePasswordChange reports whether a token predates the last
+// password change of its user, such tokens are no longer accepted. Tokens
+// issued in the same microsecond, or without iat_us in the same second, are
+// rejected too, they may have been issued before the change.
+func IssuedBeforePasswordChange(claims *Claims, user *database.Users) bool {
+	if user.PasswordChangedAt == nil {
+		return false
+	}
+
+	if claims.IssuedAtMicro == 0 {
+		return claims.IssuedAt <= user.PasswordChangedAt.Unix()
+	}
+
+	return claims.IssuedAtMicro <= user.PasswordChangedAt.UnixMicro()
+}
+
+// Permissions returns the names of the permissions granted to the role of
+// the user, users without a role get those of the default user role.
+func Permissions(db *gorm.DB, user *database.Users) ([]string, error) {
+	names := []string{}
+
+	query := db.Model(&database.Permissions{}).
+		Joins("JOIN role_permissions ON role_permissions.permissions_id = permissions.id").
+		Joins("JOIN roles ON roles.id = role_permissions.roles_id")
+
+	if user.RolesID != nil {
+		query = query.Where("roles.id = ?", *user.RolesID)
+	} else {
+		query = query.Where("roles.name = ?", database.RoleUser)
+	}
+
+	err := query.Pluck("permissions.name", &names).Error
+
+	return names, err
+}
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+
+	return string(hash), err
+}
+
+// BearerToken returns the token of an "Authorization: Bearer" header.
+func BearerToken(c echo.Context) string {
+	scheme, token, found := strings.Cut(c.Request().Header.Get(echo.HeaderAuthorization), " ")
+
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+func sign(config helpers.AuthConfig, user *database.Users, tokenType string, ttl time.Duration) (string, *Claims, error) {
+	k, err := signingKeys(config)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	id := make([]byte, 16)
+
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+
+	claims := &Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        hex.EncodeToString(id),
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Issuer:    config.Issuer,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+		Type:          tokenType,
+		IssuedAtMicro: now.UnixMicro(),
+	}
+
+	token, err := jwt.NewWithClaims(k.method, claims).SignedString(k.signKey)
+
+	return token, claims, err
+}
+
+// revoke invalidates a token before it expires. Refresh tokens are dropped
+// from Redis, access tokens are denied until their expiry.
+func revoke(ctx context.Context, claims *Claims) {
+	cacheClient := helpers.DepsFrom(ctx).Cache
+
+	if claims.Type == TokenTypeRefresh {
+		cacheClient.Delete(ctx, fmt.Sprintf("refresh:%s", claims.Id))
+		return
+	}
+
+	ttl := time.Until(time.Unix(claims.ExpiresAt, 0))
+
+	if ttl <= 0 {
+		return
+	}
+
+	if err := cacheClient.Set(&cache.Item{
+		Ctx:            ctx,
+		Key:            fmt.Sprintf("revoked:%s", claims.Id),
+		Value:          claims.Subject,
+		TTL:            ttl,
+		SkipLocalCache: true,
+	}); err != nil {
+		helpers.DepsFrom(ctx).Logger.Errorf("Failed to revoke token: %v", err)
+	}
+}
+
+// signingKeys loads the keys configured through JWT_ALGORITHM. HS256 signs
+// with JWT_SECRET, RS256 signs with JWT_PRIVATE_KEY and verifies with
+// JWT_PUBLIC_KEY, both given either as PEM or as a path to a PEM file.
+func signingKeys(config helpers.AuthConfig) (*keys, error) {
+	loadKeys.Do(func() {
+		switch strings.ToUpper(config.Algorithm) {
+		case "", "HS256":
+			secret := config.Secret.Value()
+
+			if len(secret) < 32 {
+				signingErr = errors.New("JWT_SECRET must be at least 32 characters")
+				return
+			}
+
+			signing = &keys{method: jwt.SigningMethodHS256, signKey: []byte(secret), verifyKey: []byte(secret)}
+		case "RS256":
+			privatePEM, err := pem(config.PrivateKey.Value())
+
+			if err != nil {
+				signingErr = err
+				return
+			}
+
+			publicPEM, err := pem(config.PublicKey)
+
+			if err != nil {
+				signingErr = err
+				return
+			}
+
+			var privateKey *rsa.PrivateKey
+			var publicKey *rsa.PublicKey
+
+			if privateKey, signingErr = jwt.ParseRSAPrivateKeyFromPEM(privatePEM); signingErr != nil {
+				return
+			}
+
+			if publicKey, signingErr = jwt.ParseRSAPublicKeyFromPEM(publicPEM); signingErr != nil {
+				return
+			}
+
+			signing = &keys{method: jwt.SigningMethodRS256, signKey: privateKey, verifyKey: publicKey}
+		default:
+			signingErr = fmt.Errorf("unsupported JWT_ALGORITHM %q", config.Algorithm)
+		}
+	})
+
+	return signing, signingErr
+}
+
+// pem accepts a key inline, with escaped newlines as .env files need, or a
+// path to the key file.
+func pem(value string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		return []byte(strings.ReplaceAll(value, `\n`, "\n")), nil
+	}
+
+	if value == "" {
+		return nil, errors.New("missing RS256 key")
+	}
+
+	return os.ReadFile(value)
+}
+
+// recordFailedLogin counts a failed login, locking the account for
+// AUTH_LOCKOUT_DURATION once AUTH_MAX_FAILED_LOGINS is reached.
+func recordFailedLogin(config helpers.AuthConfig, db *gorm.DB, user *database.Users) {
+	maxFailures := config.MaxFailedLogins
+
+	lockedUntil := time.Now().Add(config.LockoutDuration)
+
+	db.Model(&database.Users{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"failed_logins": gorm.Expr("CASE WHEN failed_logins + 1 >= ? THEN 0 ELSE failed_logins + 1 END", maxFailures),
+		"locked_until":  gorm.Expr("CASE WHEN failed_logins + 1 >= ? THEN ? ELSE locked_until END", maxFailures, lockedUntil),
+	})
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
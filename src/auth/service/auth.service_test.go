@@ -0,0 +1,43 @@
+package service
+
+import (
+	"sahamrakyat_test/database"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestIssuedBeforePasswordChange(t *testing.T) {
+	changed := time.Date(2026, 10, 19, 9, 30, 0, 250000000, time.UTC)
+	user := &database.Users{PasswordChangedAt: &changed}
+
+	cases := []struct {
+		name     string
+		issued   time.Time
+		precise  bool
+		rejected bool
+	}{
+		{name: "before", issued: changed.Add(-time.Millisecond), precise: true, rejected: true},
+		{name: "same microsecond", issued: changed, precise: true, rejected: true},
+		{name: "after in the same second", issued: changed.Add(time.Millisecond), precise: true},
+		{name: "same second without iat_us", issued: changed.Add(time.Millisecond), rejected: true},
+		{name: "next second without iat_us", issued: changed.Add(time.Second)},
+	}
+
+	for _, c := range cases {
+		claims := &Claims{StandardClaims: jwt.StandardClaims{IssuedAt: c.issued.Unix()}}
+
+		if c.precise {
+			claims.IssuedAtMicro = c.issued.UnixMicro()
+		}
+
+		if got := IssuedBeforePasswordChange(claims, user); got != c.rejected {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.rejected)
+		}
+	}
+
+	if IssuedBeforePasswordChange(&Claims{}, &database.Users{}) {
+		t.Error("a user who never changed their password rejected a token")
+	}
+}
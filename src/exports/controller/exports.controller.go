@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	exportsService "sahamrakyat_test/exports/service"
+	"sahamrakyat_test/helpers"
+	historiesService "sahamrakyat_test/histories/service"
+	ordersService "sahamrakyat_test/orders/service"
+	usersService "sahamrakyat_test/users/service"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+func Orders(c echo.Context) error {
+	return export(c, "orders", ordersService.Filter)
+}
+
+func Users(c echo.Context) error {
+	return export(c, "users", usersService.Filter)
+}
+
+func Histories(c echo.Context) error {
+	return export(c, "histories", historiesService.Filter)
+}
+
+// export downloads the rows a list request with the same filters would
+// return, in the format of the format query parameter, csv by default. Rows
+// are streamed into the response as they are read, so once the first one is
+// sent a failure can only cut the file short.
+func export(c echo.Context, resource string, filter func(echo.Context) (func(*gorm.DB) *gorm.DB, *echo.HTTPError)) error {
+	fileFormat := c.QueryParam("format")
+
+	if fileFormat == "" {
+		fileFormat = "csv"
+	}
+
+	contentType, ok := exportsService.Formats[fileFormat]
+
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Format must be csv or xlsx.",
+		})
+	}
+
+	scope, err := filter(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	logger := helpers.Deps(c).Logger
+	db := helpers.Deps(c).DB
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%s.%s", resource, time.Now().Format("20060102-150405"), fileFormat)))
+	header.Set("Cache-Control", "no-store")
+
+	count, exportErr := exportsService.Export(c.Request().Context(), db.Scopes(scope), resource, fileFormat, c.Response())
+
+	if exportErr == nil {
+		logger.Infof("Exported %d %s as %s", count, resource, fileFormat)
+		return nil
+	}
+
+	logger.Errorf("Failed to export %s after %d rows: %v", resource, count, exportErr)
+
+	if c.Response().Committed {
+		return nil
+	}
+
+	header.Del(echo.HeaderContentDisposition)
+
+	return c.JSON(http.StatusInternalServerError, echo.Map{
+		"statusCode": http.StatusInternalServerError,
+		"message":    fmt.Sprintf("Failed to export %s.", resource),
+	})
+}
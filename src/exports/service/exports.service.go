@@ -0,0 +1,315 @@
+package service
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"sahamrakyat_test/database"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Models that can be exported, by resource name.
+var Models = map[string]interface{}{
+	"orders":    database.Orders{},
+	"users":     database.Users{},
+	"histories": database.Histories{},
+}
+
+// Formats exports can be written in, with their content type.
+var Formats = map[string]string{
+	"csv":  "text/csv; charset=utf-8",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// column is a field of a model written to exports, named like in the JSON
+// responses of the API.
+type column struct {
+	name    string
+	index   int
+	numeric bool
+}
+
+// rowWriter writes the rows of one export, Close finishes the file.
+type rowWriter interface {
+	Write(record []string) error
+	Close() error
+}
+
+// CSV writes every row of a resource the query selects as CSV, see Export.
+func CSV(ctx context.Context, query *gorm.DB, resource string, w io.Writer) (int, error) {
+	return Export(ctx, query, resource, "csv", w)
+}
+
+// Export writes every row of a resource the query selects, ordered by id,
+// in one of Formats, and returns how many were written. Rows are read one
+// at a time from a cursor and written as they come, so exports of any size
+// use little memory.
+func Export(ctx context.Context, query *gorm.DB, resource string, fileFormat string, w io.Writer) (int, error) {
+	model, ok := Models[resource]
+
+	if !ok {
+		return 0, fmt.Errorf("unknown resource %s", resource)
+	}
+
+	modelType := reflect.TypeOf(model)
+	columns := columnsOf(modelType)
+
+	if _, ok := Formats[fileFormat]; !ok {
+		return 0, fmt.Errorf("unknown format %s", fileFormat)
+	}
+
+	// Nothing is written before the query runs, so callers can still answer
+	// with an error when it fails.
+	rows, err := query.WithContext(ctx).Model(reflect.New(modelType).Interface()).Order("id").Rows()
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	var writer rowWriter
+
+	switch fileFormat {
+	case "csv":
+		writer = &csvWriter{csv.NewWriter(w)}
+	case "xlsx":
+		writer = newXLSXWriter(w, resource, columns)
+	}
+
+	header := make([]string, len(columns))
+
+	for i, column := range columns {
+		header[i] = column.name
+	}
+
+	if err := writer.Write(header); err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for rows.Next() {
+		item := reflect.New(modelType)
+
+		if err := query.ScanRows(rows, item.Interface()); err != nil {
+			return count, err
+		}
+
+		record := make([]string, len(columns))
+
+		for i, column := range columns {
+			record[i] = format(item.Elem().Field(column.index))
+		}
+
+		if err := writer.Write(record); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+
+	return count, writer.Close()
+}
+
+// columnsOf lists the fields of a model serialized in the API, leaving out
+// relations.
+func columnsOf(modelType reflect.Type) []column {
+	columns := []column{}
+
+	for i := 0; i < modelType.NumField(); i++ {
+		field := modelType.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		if name == "-" || strings.Contains(field.Tag.Get("gorm"), "foreignKey") || field.Tag.Get("gorm") == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		kind := field.Type.Kind()
+
+		if kind == reflect.Pointer {
+			kind = field.Type.Elem().Kind()
+		}
+
+		numeric := kind >= reflect.Int && kind <= reflect.Float64
+
+		columns = append(columns, column{name: name, index: i, numeric: numeric})
+	}
+
+	return columns
+}
+
+func format(value reflect.Value) string {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return ""
+		}
+
+		value = value.Elem()
+	}
+
+	switch v := value.Interface().(type) {
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+
+		return v.Format(time.RFC3339)
+	case gorm.DeletedAt:
+		if !v.Valid {
+			return ""
+		}
+
+		return v.Time.Format(time.RFC3339)
+	}
+
+	return fmt.Sprint(value.Interface())
+}
+
+type csvWriter struct {
+	*csv.Writer
+}
+
+func (w *csvWriter) Close() error {
+	w.Flush()
+	return w.Error()
+}
+
+// xlsxWriter writes a workbook of a single sheet. The package parts are
+// written up front and the sheet is streamed row by row into the zip
+// archive, cells are inline strings so no shared string table has to be
+// kept in memory.
+type xlsxWriter struct {
+	archive *zip.Writer
+	sheet   *bufio.Writer
+	columns []column
+	row     int
+	err     error
+}
+
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+}
+
+func newXLSXWriter(w io.Writer, sheetName string, columns []column) *xlsxWriter {
+	writer := &xlsxWriter{archive: zip.NewWriter(w), columns: columns}
+
+	for _, part := range xlsxParts {
+		content := part.content
+
+		if part.name == "xl/workbook.xml" {
+			content = fmt.Sprintf(content, escape(sheetName))
+		}
+
+		f, err := writer.archive.Create(part.name)
+
+		if err == nil {
+			_, err = io.WriteString(f, content)
+		}
+
+		if err != nil {
+			writer.err = err
+			return writer
+		}
+	}
+
+	f, err := writer.archive.Create("xl/worksheets/sheet1.xml")
+
+	if err != nil {
+		writer.err = err
+		return writer
+	}
+
+	writer.sheet = bufio.NewWriter(f)
+	_, writer.err = writer.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	return writer
+}
+
+func (w *xlsxWriter) Write(record []string) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	w.row++
+
+	row := strings.Builder{}
+	row.WriteString(`<row r="` + strconv.Itoa(w.row) + `">`)
+
+	for i, value := range record {
+		if value == "" {
+			continue
+		}
+
+		// The header row is text even above numeric columns.
+		if w.row > 1 && w.columns[i].numeric {
+			row.WriteString(`<c r="` + cellName(i, w.row) + `"><v>` + escape(value) + `</v></c>`)
+			continue
+		}
+
+		row.WriteString(`<c r="` + cellName(i, w.row) + `" t="inlineStr"><is><t>` + escape(value) + `</t></is></c>`)
+	}
+
+	row.WriteString(`</row>`)
+
+	_, w.err = w.sheet.WriteString(row.String())
+
+	return w.err
+}
+
+func (w *xlsxWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if _, err := w.sheet.WriteString(`</sheetData></worksheet>`); err != nil {
+		return err
+	}
+
+	if err := w.sheet.Flush(); err != nil {
+		return err
+	}
+
+	return w.archive.Close()
+}
+
+// cellName is the A1 style reference of a cell, columns counted from 0.
+func cellName(column int, row int) string {
+	name := ""
+
+	for column++; column > 0; column = (column - 1) / 26 {
+		name = string(rune('A'+(column-1)%26)) + name
+	}
+
+	return name + strconv.Itoa(row)
+}
+
+func escape(value string) string {
+	escaped := strings.Builder{}
+	xml.EscapeText(&escaped, []byte(value))
+	return escaped.String()
+}
@@ -0,0 +1,10 @@
+module sahamrakyat_test/exports/service
+
+go 1.19
+
+require gorm.io/gorm v1.25.1
+
+require (
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
+)
@@ -0,0 +1,9 @@
+module sahamrakyat_test/grpc/controller
+
+go 1.19
+
+require (
+	github.com/labstack/echo/v4 v4.10.2
+	google.golang.org/grpc v1.55.0
+	google.golang.org/protobuf v1.30.0
+)
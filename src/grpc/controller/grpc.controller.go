@@ -0,0 +1,451 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sahamrakyat_test/database"
+	grpcMiddleware "sahamrakyat_test/grpc/middleware"
+	"sahamrakyat_test/grpc/pb"
+	historiesService "sahamrakyat_test/histories/service"
+	ordersService "sahamrakyat_test/orders/service"
+	usersService "sahamrakyat_test/users/service"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Register adds the services of the gRPC API to a server. Calls are served
+// by the service layer, behind the interceptors of the server that
+// authenticate and rate limit them as the routes do.
+func Register(server *grpc.Server) {
+	pb.RegisterOrdersServiceServer(server, &Orders{})
+	pb.RegisterUsersServiceServer(server, &Users{})
+	pb.RegisterHistoriesServiceServer(server, &Histories{})
+}
+
+// Orders serves OrdersService with the orders service, as the orders routes
+// do.
+type Orders struct {
+	pb.UnimplementedOrdersServiceServer
+}
+
+func (Orders) CreateOrder(ctx context.Context, in *pb.CreateOrderRequest) (*pb.Order, error) {
+	c := call(ctx, http.MethodPost, "/api/v1/orders", 0, nil, echo.Map{
+		"name":       in.Name,
+		"price":      in.Price,
+		"side":       in.Side,
+		"quantity":   in.Quantity,
+		"expired_at": timeOf(in.ExpiredAt),
+		"stocks_id":  in.StocksId,
+	})
+
+	order, httpErr := ordersService.Create(c)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	return orderMessage(order), nil
+}
+
+func (Orders) ListOrders(ctx context.Context, in *pb.ListOrdersRequest) (*pb.ListOrdersResponse, error) {
+	c := call(ctx, http.MethodGet, "/api/v1/orders", 0, query(echo.Map{
+		"take":      in.Take,
+		"skip":      in.Skip,
+		"status":    in.Status,
+		"side":      in.Side,
+		"stocks_id": in.StocksId,
+		"from":      timeOf(in.From),
+		"to":        timeOf(in.To),
+	}), nil)
+
+	orders, httpErr := ordersService.GetAll(c)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	response := &pb.ListOrdersResponse{Orders: []*pb.Order{}}
+
+	for i := range *orders {
+		response.Orders = append(response.Orders, orderMessage(&(*orders)[i]))
+	}
+
+	return response, nil
+}
+
+func (Orders) GetOrder(ctx context.Context, in *pb.GetOrderRequest) (*pb.Order, error) {
+	order, httpErr := ordersService.Get(call(ctx, http.MethodGet, "/api/v1/orders/:id", in.Id, nil, nil))
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	return orderMessage(order), nil
+}
+
+// UpdateOrder changes the name or expiry of an order, as PUT
+// /api/v1/orders/:id does. The message shares its fields with
+// CreateOrderRequest, but the price, side, quantity and stock of an order
+// are fixed once it is on the book.
+func (Orders) UpdateOrder(ctx context.Context, in *pb.UpdateOrderRequest) (*pb.Order, error) {
+	if in.Price != 0 || in.Side != "" || in.Quantity != 0 || in.StocksId != 0 {
+		return nil, status.Error(codes.InvalidArgument, "Only the name and expired_at of an order can be updated.")
+	}
+
+	c := call(ctx, http.MethodPut, "/api/v1/orders/:id", in.Id, nil, echo.Map{
+		"name":       in.Name,
+		"expired_at": timeOf(in.ExpiredAt),
+	})
+
+	order, httpErr := ordersService.Update(c)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	return orderMessage(order), nil
+}
+
+func (Orders) DeleteOrder(ctx context.Context, in *pb.DeleteOrderRequest) (*pb.Order, error) {
+	order, httpErr := ordersService.Delete(call(ctx, http.MethodDelete, "/api/v1/orders/:id", in.Id, nil, nil))
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	return orderMessage(order), nil
+}
+
+// Users serves UsersService with the users service, as the users routes do.
+type Users struct {
+	pb.UnimplementedUsersServiceServer
+}
+
+func (Users) CreateUser(ctx context.Context, in *pb.CreateUserRequest) (*pb.User, error) {
+	c := call(ctx, http.MethodPost, "/api/v1/users", 0, nil, echo.Map{
+		"full_name": in.FullName,
+		"email":     in.Email,
+		"phone":     in.Phone,
+		"password":  in.Password,
+	})
+
+	user, httpErr := usersService.Create(c)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	return userMessage(user), nil
+}
+
+func (Users) ListUsers(ctx context.Context, in *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	c := call(ctx, http.MethodGet, "/api/v1/users", 0, query(echo.Map{
+		"take":     in.Take,
+		"skip":     in.Skip,
+		"email":    in.Email,
+		"phone":    in.Phone,
+		"roles_id": in.RolesId,
+	}), nil)
+
+	users, httpErr := usersService.GetAll(c)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	response := &pb.ListUsersResponse{Users: []*pb.User{}}
+
+	for i := range *users {
+		response.Users = append(response.Users, userMessage(&(*users)[i]))
+	}
+
+	return response, nil
+}
+
+func (Users) GetUser(ctx context.Context, in *pb.GetUserRequest) (*pb.User, error) {
+	user, httpErr := usersService.Get(call(ctx, http.MethodGet, "/api/v1/users/:id", in.Id, nil, nil))
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	return userMessage(user), nil
+}
+
+func (Users) UpdateUser(ctx context.Context, in *pb.UpdateUserRequest) (*pb.User, error) {
+	c := call(ctx, http.MethodPut, "/api/v1/users/:id", in.Id, nil, echo.Map{
+		"full_name": in.FullName,
+		"email":     in.Email,
+		"phone":     in.Phone,
+	})
+
+	user, httpErr := usersService.Update(c)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	return userMessage(user), nil
+}
+
+func (Users) DeleteUser(ctx context.Context, in *pb.DeleteUserRequest) (*pb.User, error) {
+	user, httpErr := usersService.Delete(call(ctx, http.MethodDelete, "/api/v1/users/:id", in.Id, nil, nil))
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	return userMessage(user), nil
+}
+
+// Histories serves HistoriesService with the histories service, as the
+// histories routes do.
+type Histories struct {
+	pb.UnimplementedHistoriesServiceServer
+}
+
+func (Histories) CreateHistory(ctx context.Context, in *pb.CreateHistoryRequest) (*pb.History, error) {
+	history, httpErr := historiesService.Create(call(ctx, http.MethodPost, "/api/v1/histories", 0, nil, echo.Map{
+		"descriptions": in.Descriptions,
+	}))
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	return historyMessage(history), nil
+}
+
+func (Histories) ListHistories(ctx context.Context, in *pb.ListHistoriesRequest) (*pb.ListHistoriesResponse, error) {
+	c := call(ctx, http.MethodGet, "/api/v1/histories", 0, query(echo.Map{
+		"take": in.Take,
+		"skip": in.Skip,
+		"from": timeOf(in.From),
+		"to":   timeOf(in.To),
+	}), nil)
+
+	histories, httpErr := historiesService.GetAll(c)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	response := &pb.ListHistoriesResponse{Histories: []*pb.History{}}
+
+	for i := range *histories {
+		response.Histories = append(response.Histories, historyMessage(&(*histories)[i]))
+	}
+
+	return response, nil
+}
+
+func (Histories) GetHistory(ctx context.Context, in *pb.GetHistoryRequest) (*pb.History, error) {
+	history, httpErr := historiesService.Get(call(ctx, http.MethodGet, "/api/v1/histories/:id", in.Id, nil, nil))
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	return historyMessage(history), nil
+}
+
+func (Histories) UpdateHistory(ctx context.Context, in *pb.UpdateHistoryRequest) (*pb.History, error) {
+	history, httpErr := historiesService.Update(call(ctx, http.MethodPut, "/api/v1/histories/:id", in.Id, nil, echo.Map{
+		"descriptions": in.Descriptions,
+	}))
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	return historyMessage(history), nil
+}
+
+func (Histories) DeleteHistory(ctx context.Context, in *pb.DeleteHistoryRequest) (*pb.History, error) {
+	history, httpErr := historiesService.Delete(call(ctx, http.MethodDelete, "/api/v1/histories/:id", in.Id, nil, nil))
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	return historyMessage(history), nil
+}
+
+// app only builds the contexts of calls, it never serves.
+var app = echo.New()
+
+// call builds the echo context the service layer reads a request from: the
+// route path with its id, the query of list filters and a JSON body of the
+// fields the message sets, with the caller of the gRPC call as the current
+// user. Services answer through their return values, so the response is
+// discarded.
+func call(ctx context.Context, method string, path string, id uint64, query url.Values, body echo.Map) echo.Context {
+	target := &url.URL{Path: path, RawQuery: query.Encode()}
+	content := []byte{}
+
+	if body != nil {
+		content, _ = json.Marshal(set(body))
+	}
+
+	if id != 0 {
+		target.Path = strings.Replace(path, ":id", strconv.FormatUint(id, 10), 1)
+	}
+
+	request, _ := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(content))
+
+	if body != nil {
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		request.RemoteAddr = p.Addr.String()
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("user-agent")) > 0 {
+		request.Header.Set("User-Agent", md.Get("user-agent")[0])
+	}
+
+	c := app.NewContext(request, &discard{header: http.Header{}})
+	c.SetPath(path)
+
+	if id != 0 {
+		c.SetParamNames("id")
+		c.SetParamValues(strconv.FormatUint(id, 10))
+	}
+
+	if principal := grpcMiddleware.Principal(ctx); principal != nil {
+		principal.Apply(c)
+	}
+
+	return c
+}
+
+// query encodes the filters of a list request that are set.
+func query(filters echo.Map) url.Values {
+	values := url.Values{}
+
+	for name, value := range set(filters) {
+		if at, ok := value.(time.Time); ok {
+			values.Set(name, at.Format(time.RFC3339Nano))
+		} else {
+			values.Set(name, toString(value))
+		}
+	}
+
+	return values
+}
+
+// set leaves out the fields a message does not set, proto3 does not tell a
+// zero value from a missing one.
+func set(fields echo.Map) echo.Map {
+	for name, value := range fields {
+		if value == nil || reflect.ValueOf(value).IsZero() {
+			delete(fields, name)
+		}
+	}
+
+	return fields
+}
+
+func toString(value interface{}) string {
+	encoded, _ := json.Marshal(value)
+	return string(bytes.Trim(encoded, `"`))
+}
+
+func timeOf(timestamp *timestamppb.Timestamp) interface{} {
+	if timestamp == nil {
+		return nil
+	}
+
+	return timestamp.AsTime()
+}
+
+func timestamp(at time.Time) *timestamppb.Timestamp {
+	if at.IsZero() {
+		return nil
+	}
+
+	return timestamppb.New(at)
+}
+
+func orderMessage(order *database.Orders) *pb.Order {
+	message := &pb.Order{
+		Id:             uint64(order.ID),
+		Name:           order.Name,
+		Price:          uint64(order.Price),
+		Side:           order.Side,
+		Quantity:       uint64(order.Quantity),
+		FilledQuantity: uint64(order.FilledQuantity),
+		Status:         order.Status,
+		ExpiredAt:      timestamp(order.ExpiredAt),
+		CreatedAt:      timestamp(order.CreatedAt),
+		UpdatedAt:      timestamp(order.UpdatedAt),
+	}
+
+	if order.StocksID != nil {
+		message.StocksId = uint64(*order.StocksID)
+	}
+
+	return message
+}
+
+func userMessage(user *database.Users) *pb.User {
+	message := &pb.User{
+		Id:         uint64(user.ID),
+		FullName:   user.FullName,
+		FirstOrder: user.FirstOrder,
+		CreatedAt:  timestamp(user.CreatedAt),
+		UpdatedAt:  timestamp(user.UpdatedAt),
+	}
+
+	if user.Email != nil {
+		message.Email = *user.Email
+	}
+
+	if user.Phone != nil {
+		message.Phone = *user.Phone
+	}
+
+	if user.RolesID != nil {
+		message.RolesId = uint64(*user.RolesID)
+	}
+
+	return message
+}
+
+func historyMessage(history *database.Histories) *pb.History {
+	return &pb.History{
+		Id:           uint64(history.ID),
+		Descriptions: history.Descriptions,
+		CreatedAt:    timestamp(history.CreatedAt),
+		UpdatedAt:    timestamp(history.UpdatedAt),
+	}
+}
+
+// discard is the response writer of calls, services only set its headers.
+type discard struct {
+	header http.Header
+}
+
+func (d *discard) Header() http.Header {
+	return d.header
+}
+
+func (d *discard) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (d *discard) WriteHeader(int) {}
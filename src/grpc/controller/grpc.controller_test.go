@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"sahamrakyat_test/grpc/pb"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestUpdateOrderRejectsFixedFields(t *testing.T) {
+	requests := map[string]*pb.UpdateOrderRequest{
+		"price":     {Id: 1, Price: 1000},
+		"side":      {Id: 1, Side: "buy"},
+		"quantity":  {Id: 1, Quantity: 100},
+		"stocks_id": {Id: 1, StocksId: 2, Name: "BBCA", ExpiredAt: timestamppb.Now()},
+	}
+
+	for field, in := range requests {
+		if _, err := (Orders{}).UpdateOrder(context.Background(), in); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: got %v, want InvalidArgument", field, err)
+		}
+	}
+}
+
+func TestCallsCarryThePathAndBody(t *testing.T) {
+	c := call(context.Background(), "PUT", "/api/v1/orders/:id", 7, nil, map[string]interface{}{"name": "Savings", "price": uint64(0)})
+
+	if c.Path() != "/api/v1/orders/:id" || c.Param("id") != "7" || c.Request().URL.Path != "/api/v1/orders/7" {
+		t.Fatalf("got path %s with id %q for %s, want order 7", c.Path(), c.Param("id"), c.Request().URL.Path)
+	}
+
+	body := map[string]interface{}{}
+
+	if err := json.NewDecoder(c.Request().Body).Decode(&body); err != nil || len(body) != 1 || body["name"] != "Savings" {
+		t.Fatalf("got body %v and %v, want only the fields that are set", body, err)
+	}
+}
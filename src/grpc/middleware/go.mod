@@ -0,0 +1,9 @@
+module sahamrakyat_test/grpc/middleware
+
+go 1.19
+
+require (
+	github.com/labstack/echo/v4 v4.10.2
+	google.golang.org/genproto v0.0.0-20230306155012-7f2fa6fef1f4
+	google.golang.org/grpc v1.55.0
+)
@@ -0,0 +1,302 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	authMiddleware "sahamrakyat_test/auth/middleware"
+	"sahamrakyat_test/grpc/pb"
+	"sahamrakyat_test/helpers"
+	ratelimitMiddleware "sahamrakyat_test/ratelimit/middleware"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Method is what a call of the gRPC API shares with the HTTP route serving
+// the same operation: the permission it requires, and the route, by method
+// and path, whose rate limits it counts against.
+type Method struct {
+	Permission string
+	Route      string
+}
+
+// Methods of the gRPC API, calls of any other method are refused.
+var Methods = map[string]Method{
+	pb.OrdersService_CreateOrder_FullMethodName:      {Permission: "orders:write", Route: "POST /api/v1/orders"},
+	pb.OrdersService_ListOrders_FullMethodName:       {Permission: "orders:read", Route: "GET /api/v1/orders"},
+	pb.OrdersService_GetOrder_FullMethodName:         {Permission: "orders:read", Route: "GET /api/v1/orders/:id"},
+	pb.OrdersService_UpdateOrder_FullMethodName:      {Permission: "orders:write", Route: "PUT /api/v1/orders/:id"},
+	pb.OrdersService_DeleteOrder_FullMethodName:      {Permission: "orders:write", Route: "DELETE /api/v1/orders/:id"},
+	pb.UsersService_CreateUser_FullMethodName:        {Permission: "users:manage", Route: "POST /api/v1/users"},
+	pb.UsersService_ListUsers_FullMethodName:         {Permission: "users:read", Route: "GET /api/v1/users"},
+	pb.UsersService_GetUser_FullMethodName:           {Permission: "users:read", Route: "GET /api/v1/users/:id"},
+	pb.UsersService_UpdateUser_FullMethodName:        {Permission: "users:write", Route: "PUT /api/v1/users/:id"},
+	pb.UsersService_DeleteUser_FullMethodName:        {Permission: "users:manage", Route: "DELETE /api/v1/users/:id"},
+	pb.HistoriesService_CreateHistory_FullMethodName: {Permission: "histories:write", Route: "POST /api/v1/histories"},
+	pb.HistoriesService_ListHistories_FullMethodName: {Permission: "histories:read", Route: "GET /api/v1/histories"},
+	pb.HistoriesService_GetHistory_FullMethodName:    {Permission: "histories:read", Route: "GET /api/v1/histories/:id"},
+	pb.HistoriesService_UpdateHistory_FullMethodName: {Permission: "histories:write", Route: "PUT /api/v1/histories/:id"},
+	pb.HistoriesService_DeleteHistory_FullMethodName: {Permission: "histories:write", Route: "DELETE /api/v1/histories/:id"},
+}
+
+type (
+	principalKey      struct{}
+	anonymousCountKey struct{}
+)
+
+// identify checks the authorization metadata of a call, it is replaced in
+// tests.
+var identify = authMiddleware.Identify
+
+// Principal returns who a call was authenticated as by Authenticate.
+func Principal(ctx context.Context) *authMiddleware.Principal {
+	principal, _ := ctx.Value(principalKey{}).(*authMiddleware.Principal)
+	return principal
+}
+
+// Inject makes the dependencies available to every call, like
+// helpers.Inject does for HTTP requests. It has to run first.
+func Inject(deps *helpers.Dependencies) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(helpers.WithDependencies(ctx, deps), req)
+	}
+}
+
+// Logging logs every call with its peer, status code and duration.
+func Logging() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		address := "-"
+
+		if p, ok := peer.FromContext(ctx); ok {
+			address = p.Addr.String()
+		}
+
+		helpers.DepsFrom(ctx).Logger.Info(fmt.Sprintf("[gRPC] %s %s %s %s", info.FullMethod, address, status.Code(err), time.Since(start)))
+
+		return resp, err
+	}
+}
+
+// Errors turns the errors of the service layer, which are echo.HTTPErrors,
+// into gRPC statuses, and panics into Internal.
+func Errors() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				helpers.DepsFrom(ctx).Logger.Errorf("Panic in %s: %v\n%s", info.FullMethod, recovered, debug.Stack())
+				resp, err = nil, status.Error(codes.Internal, "Internal server error.")
+			}
+		}()
+
+		resp, err = handler(ctx, req)
+
+		return resp, Status(err)
+	}
+}
+
+// Status converts an error of the service layer into a gRPC status. The
+// fields that failed validation are sent as a BadRequest detail.
+func Status(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	httpErr := &echo.HTTPError{}
+
+	if !errors.As(err, &httpErr) {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+
+		return status.Error(codes.Internal, "Internal server error.")
+	}
+
+	code := Code(httpErr.Code)
+
+	message, ok := httpErr.Message.(echo.Map)
+
+	if !ok {
+		return status.Error(code, fmt.Sprint(httpErr.Message))
+	}
+
+	result := status.New(code, fmt.Sprint(message["message"]))
+
+	if fields, _ := message["error"].([]echo.Map); len(fields) > 0 {
+		violations := &errdetails.BadRequest{}
+
+		for _, field := range fields {
+			violations.FieldViolations = append(violations.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       fmt.Sprint(field["field"]),
+				Description: strings.TrimSpace(fmt.Sprintf("Failed on %v %v", field["tag"], field["param"])) + ".",
+			})
+		}
+
+		if detailed, err := result.WithDetails(violations); err == nil {
+			result = detailed
+		}
+	}
+
+	return result.Err()
+}
+
+// Code maps an HTTP status to the gRPC code clients expect for it.
+func Code(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusFailedDependency, http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout, http.StatusRequestTimeout:
+		return codes.DeadlineExceeded
+	}
+
+	if httpStatus >= http.StatusInternalServerError {
+		return codes.Internal
+	}
+
+	return codes.Unknown
+}
+
+// LimitAnonymous counts every call against the IP address of its peer
+// before authentication runs, like ratelimitMiddleware.LimitAnonymous does
+// for HTTP requests, so calls failing it are limited too.
+func LimitAnonymous() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		counted := ratelimitMiddleware.Count(ctx, helpers.Identity(nil, nil, peerIP(ctx)), ratelimitMiddleware.Rule{}, Methods[info.FullMethod].Route)
+
+		if err := limited(ctx, counted); err != nil {
+			return nil, err
+		}
+
+		return handler(context.WithValue(ctx, anonymousCountKey{}, counted), req)
+	}
+}
+
+// Authenticate requires an access token or API key in the authorization
+// metadata of every call, "Bearer <token>" or "ApiKey <key>" as in the
+// Authorization header, and the permission of the method.
+func Authenticate() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		authorization := ""
+
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+			authorization = md.Get("authorization")[0]
+		}
+
+		principal, failure := identify(ctx, authorization)
+
+		if failure != nil {
+			return nil, status.Error(codes.Unauthenticated, failure.Message)
+		}
+
+		method, ok := Methods[info.FullMethod]
+
+		if !ok {
+			return nil, status.Error(codes.PermissionDenied, "No permission is defined for "+info.FullMethod+".")
+		}
+
+		granted := false
+
+		for _, name := range principal.Permissions {
+			granted = granted || name == method.Permission
+		}
+
+		if !granted {
+			return nil, status.Error(codes.PermissionDenied, "Missing permission "+method.Permission+".")
+		}
+
+		return handler(context.WithValue(ctx, principalKey{}, principal), req)
+	}
+}
+
+// Limit counts calls against the limits of the API key or user they were
+// authenticated as, like ratelimitMiddleware.Limit does for HTTP requests,
+// and releases what LimitAnonymous counted. It has to run after
+// Authenticate.
+func Limit() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		principal := Principal(ctx)
+
+		if principal == nil {
+			return handler(ctx, req)
+		}
+
+		if anonymous, ok := ctx.Value(anonymousCountKey{}).(*ratelimitMiddleware.Counted); ok {
+			anonymous.Release(ctx)
+		}
+
+		identity := helpers.Identity(principal.APIKey, principal.User, peerIP(ctx))
+		counted := ratelimitMiddleware.Count(ctx, identity, ratelimitMiddleware.ClientRule(ctx, principal.APIKey), Methods[info.FullMethod].Route)
+
+		if err := limited(ctx, counted); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// limited sends the RateLimit headers of a counted call as header metadata
+// and returns ResourceExhausted when it is over its limit.
+func limited(ctx context.Context, counted *ratelimitMiddleware.Counted) error {
+	returned := metadata.MD{}
+
+	for name, value := range counted.Headers() {
+		returned.Set(strings.ToLower(name), value)
+	}
+
+	// Fails only outside of a server, where there is nobody to send them to.
+	grpc.SetHeader(ctx, returned)
+
+	if counted.Allowed() {
+		return nil
+	}
+
+	return status.Error(codes.ResourceExhausted, fmt.Sprintf("Too many requests, retry in %s seconds.", counted.Headers()[echo.HeaderRetryAfter]))
+}
+
+// peerIP is the address a call comes from, without its port.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+
+	if !ok {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	authMiddleware "sahamrakyat_test/auth/middleware"
+	"sahamrakyat_test/database"
+	"sahamrakyat_test/grpc/pb"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// stubIdentify accepts the bearer token "user" as a user allowed to read
+// orders and refuses anything else.
+func stubIdentify(t *testing.T) {
+	identify = func(_ context.Context, authorization string) (*authMiddleware.Principal, *authMiddleware.Failure) {
+		if authorization != "Bearer user" {
+			return nil, &authMiddleware.Failure{Scheme: "Bearer", Message: "Missing bearer token."}
+		}
+
+		return &authMiddleware.Principal{User: &database.Users{ID: 3}, Permissions: []string{"orders:read"}}, nil
+	}
+
+	t.Cleanup(func() { identify = authMiddleware.Identify })
+}
+
+func authenticate(ctx context.Context, method string) (*authMiddleware.Principal, error) {
+	var principal *authMiddleware.Principal
+
+	_, err := Authenticate()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		principal = Principal(ctx)
+		return nil, nil
+	})
+
+	return principal, err
+}
+
+func TestAuthenticateChecksCredentialsAndPermissions(t *testing.T) {
+	stubIdentify(t)
+
+	if _, err := authenticate(context.Background(), pb.OrdersService_GetOrder_FullMethodName); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("a call without credentials answered %v, want Unauthenticated", err)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer user"))
+
+	if _, err := authenticate(ctx, pb.OrdersService_UpdateOrder_FullMethodName); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("a call without orders:write answered %v, want PermissionDenied", err)
+	}
+
+	if _, err := authenticate(ctx, "/pb.OrdersService/Unknown"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("a call of an unknown method answered %v, want PermissionDenied", err)
+	}
+
+	principal, err := authenticate(ctx, pb.OrdersService_GetOrder_FullMethodName)
+
+	if err != nil || principal == nil || principal.User.ID != 3 {
+		t.Fatalf("got %+v and %v, want user 3 to be the principal", principal, err)
+	}
+}
+
+func TestEveryMethodHasARoute(t *testing.T) {
+	for _, service := range []grpc.ServiceDesc{pb.OrdersService_ServiceDesc, pb.UsersService_ServiceDesc, pb.HistoriesService_ServiceDesc} {
+		for _, method := range service.Methods {
+			name := "/" + service.ServiceName + "/" + method.MethodName
+
+			if m, ok := Methods[name]; !ok || m.Permission == "" || m.Route == "" {
+				t.Errorf("%s has no permission or route", name)
+			}
+		}
+	}
+}
+
+func TestStatusCarriesValidationErrors(t *testing.T) {
+	err := Status(echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+		"message": "Failed to validate history.",
+		"error":   []echo.Map{{"field": "Descriptions", "tag": "required"}},
+	}))
+
+	result := status.Convert(err)
+
+	if result.Code() != codes.InvalidArgument || result.Message() != "Failed to validate history." {
+		t.Fatalf("got %v, want InvalidArgument", err)
+	}
+
+	details := result.Details()
+
+	if len(details) != 1 {
+		t.Fatalf("got details %v, want a BadRequest", details)
+	}
+
+	violations, ok := details[0].(*errdetails.BadRequest)
+
+	if !ok || len(violations.FieldViolations) != 1 || violations.FieldViolations[0].Field != "Descriptions" {
+		t.Fatalf("got details %v, want Descriptions to have failed", details)
+	}
+
+	if code := status.Code(Status(echo.NewHTTPError(http.StatusNotFound, "Order not found."))); code != codes.NotFound {
+		t.Fatalf("a 404 answered %v, want NotFound", code)
+	}
+}
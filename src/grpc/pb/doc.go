@@ -0,0 +1,5 @@
+// Package pb holds the protobuf messages and gRPC services of the API,
+// generated from proto/sahamrakyat/v1.
+package pb
+
+//go:generate protoc -I ../../../proto --go_out=. --go_opt=module=sahamrakyat_test/grpc/pb --go-grpc_out=. --go-grpc_opt=module=sahamrakyat_test/grpc/pb sahamrakyat/v1/orders.proto sahamrakyat/v1/users.proto sahamrakyat/v1/histories.proto
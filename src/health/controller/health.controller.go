@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"net/http"
+	"sahamrakyat_test/health/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Live answers as long as the process serves requests.
+func Live(c echo.Context) error {
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "OK",
+	})
+}
+
+// Ready answers 503 while a dependency is unreachable, so load balancers
+// stop routing to the instance.
+func Ready(c echo.Context) error {
+	data := service.Ready(c)
+
+	if data.Status != service.StatusOK {
+		return c.JSON(http.StatusServiceUnavailable, echo.Map{
+			"statusCode": http.StatusServiceUnavailable,
+			"message":    "Not ready.",
+			"data":       data,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Ready.",
+		"data":       data,
+	})
+}
+
+func Status(c echo.Context) error {
+	data := service.Status(c)
+
+	code := http.StatusOK
+
+	if data.Status != service.StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+
+	return c.JSON(code, echo.Map{
+		"statusCode": code,
+		"message":    "Successfully get status.",
+		"data":       data,
+	})
+}
+
+// Skipper leaves the probes out of middleware such as access logging.
+func Skipper(c echo.Context) bool {
+	return service.Paths[c.Path()]
+}
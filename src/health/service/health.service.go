@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime"
+	"runtime/debug"
+	"sahamrakyat_test/database/migrations"
+	"sahamrakyat_test/helpers"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Paths of the probes and the metrics, left out of access logs and rate
+// limits so frequent polling neither floods the log nor eats into client
+// limits.
+var Paths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/status":  true,
+	"/metrics": true,
+}
+
+var startedAt = time.Now()
+
+var errPending = errors.New("schema has pending migrations")
+
+// Check is the outcome of pinging one dependency.
+type Check struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+type Readiness struct {
+	Status    string            `json:"status"`
+	Checks    map[string]*Check `json:"checks"`
+	Migration *migrations.State `json:"migration,omitempty"`
+}
+
+// Details is the operator status, readiness plus what the process and its
+// connection pools are doing.
+type Details struct {
+	*Readiness
+	Env        string           `json:"env"`
+	Version    string           `json:"version"`
+	GoVersion  string           `json:"go_version"`
+	StartedAt  time.Time        `json:"started_at"`
+	Uptime     string           `json:"uptime"`
+	Goroutines int              `json:"goroutines"`
+	HeapBytes  uint64           `json:"heap_bytes"`
+	Database   sql.DBStats      `json:"database"`
+	Redis      *redis.PoolStats `json:"redis"`
+}
+
+// Ready pings the database pool and the Redis ring, each within
+// HEALTH_CHECK_TIMEOUT, and checks that the schema is fully migrated.
+func Ready(c echo.Context) *Readiness {
+	deps := helpers.Deps(c)
+	timeout := deps.Config.Health.Timeout
+
+	readiness := &Readiness{Status: StatusOK, Checks: map[string]*Check{}}
+
+	readiness.Checks["database"] = check(c.Request().Context(), timeout, func(ctx context.Context) error {
+		sqlDB, err := deps.DB.DB()
+
+		if err != nil {
+			return err
+		}
+
+		return sqlDB.PingContext(ctx)
+	})
+
+	readiness.Checks["redis"] = check(c.Request().Context(), timeout, func(ctx context.Context) error {
+		return deps.Redis.Ping(ctx).Err()
+	})
+
+	readiness.Checks["migrations"] = check(c.Request().Context(), timeout, func(ctx context.Context) error {
+		state, err := migrations.Status(deps.DB.WithContext(ctx))
+
+		if err != nil {
+			return err
+		}
+
+		readiness.Migration = state
+
+		if len(state.Pending) > 0 {
+			return errPending
+		}
+
+		return nil
+	})
+
+	for _, result := range readiness.Checks {
+		if result.Status != StatusOK {
+			readiness.Status = StatusFail
+		}
+	}
+
+	return readiness
+}
+
+func Status(c echo.Context) *Details {
+	deps := helpers.Deps(c)
+
+	details := &Details{
+		Readiness:  Ready(c),
+		Env:        deps.Config.App.Env,
+		Version:    "unknown",
+		GoVersion:  runtime.Version(),
+		StartedAt:  startedAt,
+		Uptime:     time.Since(startedAt).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		Redis:      deps.Redis.PoolStats(),
+	}
+
+	if sqlDB, err := deps.DB.DB(); err == nil {
+		details.Database = sqlDB.Stats()
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		details.Version = info.Main.Version
+
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				details.Version = setting.Value
+			}
+		}
+	}
+
+	memory := runtime.MemStats{}
+	runtime.ReadMemStats(&memory)
+	details.HeapBytes = memory.HeapAlloc
+
+	return details
+}
+
+func check(parent context.Context, timeout time.Duration, ping func(ctx context.Context) error) *Check {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := ping(ctx)
+
+	result := &Check{Status: StatusOK, LatencyMs: time.Since(start).Milliseconds()}
+
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+
+	return result
+}
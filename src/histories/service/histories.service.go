@@ -3,7 +3,6 @@ package service
 import (
 	"fmt"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
 	"sahamrakyat_test/database"
@@ -12,11 +11,12 @@ import (
 	"github.com/go-playground/validator"
 	"github.com/go-redis/cache/v8"
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
 func Create(c echo.Context) (*database.Histories, *echo.HTTPError) {
-	logger := helpers.InitLogger()
+	logger := helpers.Deps(c).Logger
 
 	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
 
@@ -58,64 +58,50 @@ func Create(c echo.Context) (*database.Histories, *echo.HTTPError) {
 		})
 	}
 
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
+	db := helpers.Deps(c).DB
 
 	db.Create(history)
 
+	invalidateLists(c, history.ID)
+
 	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
 
 	return history, nil
 }
 
 func GetAll(c echo.Context) (*[]database.Histories, *echo.HTTPError) {
-	logger := helpers.InitLogger()
+	logger := helpers.Deps(c).Logger
 
 	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
 
-	var take int
-	var skip int
-
-	takeQuery := c.QueryParam("take")
+	filter, httpErr := Filter(c)
 
-	if takeQuery != "" {
-		if val, err := strconv.ParseInt(takeQuery, 10, 32); err != nil {
-			return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse take.")
-		} else {
-			take = int(val)
-		}
-	} else {
-		take = -1
-	}
-
-	skipQuery := c.QueryParam("skip")
-
-	if skipQuery != "" {
-		if val, err := strconv.ParseInt(skipQuery, 10, 32); err != nil {
-			return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse skip.")
-		} else {
-			skip = int(val)
-		}
-	} else {
-		skip = -1
+	if httpErr != nil {
+		return nil, httpErr
 	}
 	
 	histories := &[]database.Histories{}
 
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
+	db := helpers.Deps(c).DB
 
-	db.Limit(take).Offset(skip).Preload(clause.Associations).Find(histories)
+	db.Scopes(filter).Preload(clause.Associations).Find(histories)
 
-	cacheClient := helpers.InitRedisCache()
+	cacheKey, cacheable := helpers.ListCacheKey(c, "histories", "histories:manage")
 
-	if err := cacheClient.Get(c.Request().Context(), "histories", histories); err == nil {
-		return histories, nil
-	} else if err != nil {
-		if err := cacheClient.Set(&cache.Item{
-			Ctx:   c.Request().Context(),
-			Key:   "histories",
-			Value: histories,
-		}); err != nil {
-			fmt.Printf("Failed to cache histories: %v\n", err)
+	// Only whole lists are cached, filtered and paged ones are always read.
+	if cacheable && c.QueryString() == "" {
+		cacheClient := helpers.Deps(c).Cache
+
+		if err := cacheClient.Get(c.Request().Context(), cacheKey, histories); err == nil {
+			return histories, nil
+		} else if err != nil {
+			if err := cacheClient.Set(&cache.Item{
+				Ctx:   c.Request().Context(),
+				Key:   cacheKey,
+				Value: histories,
+			}); err != nil {
+				fmt.Printf("Failed to cache histories: %v\n", err)
+			}
 		}
 	}
 
@@ -125,7 +111,7 @@ func GetAll(c echo.Context) (*[]database.Histories, *echo.HTTPError) {
 }
 
 func Get(c echo.Context) (*database.Histories, *echo.HTTPError) {
-	logger := helpers.InitLogger()
+	logger := helpers.Deps(c).Logger
 
 	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
 
@@ -143,17 +129,13 @@ func Get(c echo.Context) (*database.Histories, *echo.HTTPError) {
 		ID: uint(id),
 	}
 
-	if err := c.Bind(history); err != nil {
-		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind history.")
-	}
-
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
+	db := helpers.Deps(c).DB
 
-	if result := db.Find(history); result.Error != nil || result.RowsAffected < 0 {
+	if result := db.Scopes(Owned(c)).Find(history); result.Error != nil || result.RowsAffected < 1 {
 		return nil, echo.NewHTTPError(http.StatusNotFound, "History not found.")
 	}
 
-	cacheClient := helpers.InitRedisCache()
+	cacheClient := helpers.Deps(c).Cache
 
 	if err := cacheClient.Get(c.Request().Context(), fmt.Sprintf("history:%d", id), history); err == nil {
 		return history, nil
@@ -172,8 +154,10 @@ func Get(c echo.Context) (*database.Histories, *echo.HTTPError) {
 	return history, nil
 }
 
+// Update changes the descriptions of a history, the only field clients
+// write.
 func Update(c echo.Context) (*database.Histories, *echo.HTTPError) {
-	logger := helpers.InitLogger()
+	logger := helpers.Deps(c).Logger
 
 	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
 
@@ -187,28 +171,34 @@ func Update(c echo.Context) (*database.Histories, *echo.HTTPError) {
 		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse history id.")
 	}
 
-	history := &database.Histories{
-		ID: uint(id),
-	}
+	patch := &database.Histories{}
 
-	if err := c.Bind(history); err != nil {
-		fmt.Println(err)
+	if err := c.Bind(patch); err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind history.")
 	}
 
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
+	history := &database.Histories{
+		ID: uint(id),
+	}
+
+	db := helpers.Deps(c).DB
 
-	if result := db.Find(history); result.Error != nil || result.RowsAffected < 0 {
+	if result := db.Scopes(Owned(c)).Find(history); result.Error != nil || result.RowsAffected < 1 {
 		return nil, echo.NewHTTPError(http.StatusNotFound, "History not found.")
 	}
 
-	cacheClient := helpers.InitRedisCache()
+	if patch.Descriptions != "" {
+		history.Descriptions = patch.Descriptions
+	}
 
-	if err := cacheClient.Get(c.Request().Context(), fmt.Sprintf("history:%d", id), history); err == nil {
-		cacheClient.Delete(c.Request().Context(), fmt.Sprintf("history:%d", id))
+	if err := db.Save(history).Error; err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to update history.")
 	}
 
-	db.Save(history)
+	helpers.Deps(c).Cache.Delete(c.Request().Context(), fmt.Sprintf("history:%d", id))
+
+	invalidateLists(c, history.ID)
 
 	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
 
@@ -216,7 +206,7 @@ func Update(c echo.Context) (*database.Histories, *echo.HTTPError) {
 }
 
 func Delete(c echo.Context) (*database.Histories, *echo.HTTPError) {
-	logger := helpers.InitLogger()
+	logger := helpers.Deps(c).Logger
 
 	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
 
@@ -234,18 +224,17 @@ func Delete(c echo.Context) (*database.Histories, *echo.HTTPError) {
 		ID: uint(id),
 	}
 
-	if err := c.Bind(history); err != nil {
-		fmt.Println(err)
+	if err := c.Bind(&database.Histories{}); err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind history.")
 	}
 
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
+	db := helpers.Deps(c).DB
 
-	if result := db.Find(history); result.Error != nil || result.RowsAffected < 0 {
+	if result := db.Scopes(Owned(c)).Find(history); result.Error != nil || result.RowsAffected < 1 {
 		return nil, echo.NewHTTPError(http.StatusNotFound, "History not found.")
 	}
 
-	cacheClient := helpers.InitRedisCache()
+	cacheClient := helpers.Deps(c).Cache
 
 	if err := cacheClient.Get(c.Request().Context(), fmt.Sprintf("history:%d", id), history); err == nil {
 		cacheClient.Delete(c.Request().Context(), fmt.Sprintf("history:%d", id))
@@ -253,7 +242,55 @@ func Delete(c echo.Context) (*database.Histories, *echo.HTTPError) {
 
 	db.Delete(history)
 
+	invalidateLists(c, history.ID)
+
 	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
 
 	return history, nil
 }
+
+// invalidateLists drops the cached history lists a history shows up in, the
+// shared one and the one of its owner.
+func invalidateLists(c echo.Context, historiesID uint) {
+	owners := []uint{}
+
+	helpers.Deps(c).DB.Model(&database.Users{}).Where("histories_id = ?", historiesID).Pluck("id", &owners)
+	helpers.Deps(c).Cache.InvalidateLists(c.Request().Context(), "histories", owners...)
+}
+
+// Owned limits history queries to the history of the current user, unless
+// they hold histories:manage.
+func Owned(c echo.Context) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if helpers.Can(c, "histories:manage") {
+			return db
+		}
+
+		if user := helpers.CurrentUser(c); user != nil && user.HistoriesID != nil {
+			return db.Where("histories.id = ?", *user.HistoriesID)
+		}
+
+		return db.Where("1 = 0")
+	}
+}
+
+// Filter is the query of a list request: the histories the caller may see,
+// optionally only those created between from and to, paged with take and
+// skip.
+func Filter(c echo.Context) (func(*gorm.DB) *gorm.DB, *echo.HTTPError) {
+	take, skip, httpErr := helpers.Page(c)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	period, httpErr := helpers.Period(c, "histories.created_at")
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Scopes(Owned(c), period).Limit(take).Offset(skip)
+	}, nil
+}
@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sahamrakyat_test/helpers"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// record is what is kept per key, the response is empty while the first
+// request is still being handled.
+type record struct {
+	Fingerprint string      `json:"fingerprint"`
+	Done        bool        `json:"done"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// Headers of the first response that are replayed with it.
+var replayedHeaders = []string{echo.HeaderContentType, echo.HeaderLocation}
+
+// Idempotent makes a create route safe to retry. The first response to a
+// request carrying an Idempotency-Key is stored in Redis for
+// IDEMPOTENCY_TTL (24h by default) and replayed for every later request
+// with the same key, without running the handler again. Keys are scoped to
+// the client and route, reusing one with a different body answers 422, and
+// using it while the first request is still running answers 409. The key is
+// only claimed for IDEMPOTENCY_PENDING_TTL until then. Server errors and
+// panics are not stored, so such requests can be retried with the same key.
+func Idempotent() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			deps := helpers.Deps(c)
+			client := deps.Redis
+			key := c.Request().Header.Get(HeaderIdempotencyKey)
+
+			if key == "" {
+				return next(c)
+			}
+
+			if len(key) > 255 {
+				return reply(c, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters.")
+			}
+
+			body, err := io.ReadAll(c.Request().Body)
+
+			if err != nil {
+				return reply(c, http.StatusBadRequest, "Failed to read request body.")
+			}
+
+			c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+			ctx := c.Request().Context()
+			storeKey := "idempotency:" + helpers.ClientIdentity(c) + ":" + digest(c.Request().Method, c.Path(), key)
+			fingerprint := digest(c.Request().Method, c.Request().URL.Path, string(body))
+			ttl := deps.Config.Idempotency.TTL
+
+			pending, _ := json.Marshal(record{Fingerprint: fingerprint})
+
+			claimed, err := client.SetNX(ctx, storeKey, pending, deps.Config.Idempotency.PendingTTL).Result()
+
+			if err != nil {
+				// Without Redis requests are handled as if they carried no key.
+				helpers.Deps(c).Logger.Error(err)
+				return next(c)
+			}
+
+			if !claimed {
+				return replay(c, client, storeKey, fingerprint)
+			}
+
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					client.Del(context.Background(), storeKey)
+					panic(recovered)
+				}
+			}()
+
+			recorder := &responseRecorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = recorder
+
+			err = next(c)
+
+			status := c.Response().Status
+
+			if err != nil || status >= http.StatusInternalServerError || !c.Response().Committed {
+				client.Del(context.Background(), storeKey)
+				return err
+			}
+
+			stored := record{Fingerprint: fingerprint, Done: true, Status: status, Header: http.Header{}, Body: recorder.body.Bytes()}
+
+			for _, name := range replayedHeaders {
+				if value := c.Response().Header().Get(name); value != "" {
+					stored.Header.Set(name, value)
+				}
+			}
+
+			if value, err := json.Marshal(stored); err == nil {
+				client.Set(context.Background(), storeKey, value, ttl)
+			}
+
+			return nil
+		}
+	}
+}
+
+func replay(c echo.Context, client *redis.Ring, storeKey string, fingerprint string) error {
+	value, err := client.Get(c.Request().Context(), storeKey).Bytes()
+
+	if errors.Is(err, redis.Nil) {
+		// The first request failed and released the key in the meantime.
+		return reply(c, http.StatusConflict, "A request with this Idempotency-Key failed just now, retry it.")
+	}
+
+	stored := record{}
+
+	if err != nil || json.Unmarshal(value, &stored) != nil {
+		return reply(c, http.StatusInternalServerError, "Failed to read the response stored for this Idempotency-Key.")
+	}
+
+	if stored.Fingerprint != fingerprint {
+		return reply(c, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request.")
+	}
+
+	if !stored.Done {
+		return reply(c, http.StatusConflict, "A request with this Idempotency-Key is still being processed.")
+	}
+
+	for name, values := range stored.Header {
+		for _, value := range values {
+			c.Response().Header().Add(name, value)
+		}
+	}
+
+	c.Response().Header().Set("Idempotent-Replayed", "true")
+	c.Response().WriteHeader(stored.Status)
+
+	_, err = c.Response().Write(stored.Body)
+
+	return err
+}
+
+func reply(c echo.Context, status int, message string) error {
+	return c.JSON(status, echo.Map{
+		"statusCode": status,
+		"message":    message,
+	})
+}
+
+func digest(parts ...string) string {
+	hash := sha256.New()
+
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder keeps a copy of the body written through it.
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return r.ResponseWriter.(http.Hijacker).Hijack()
+}
@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sahamrakyat_test/database"
+	"sahamrakyat_test/helpers"
+	"sahamrakyat_test/helpers/redistest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// handler counts its runs and answers with the status it is told in the
+// status query parameter, 201 by default, or panics when told so. A run
+// blocks while release is set and not closed.
+type handler struct {
+	mu      sync.Mutex
+	runs    int
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *handler) serve(c echo.Context) error {
+	h.mu.Lock()
+	h.runs++
+	run := h.runs
+	h.mu.Unlock()
+
+	if h.release != nil {
+		close(h.started)
+		<-h.release
+	}
+
+	if c.QueryParam("panic") != "" {
+		panic("handler failed")
+	}
+
+	code := http.StatusCreated
+
+	if status := c.QueryParam("status"); status != "" {
+		code, _ = strconv.Atoi(status)
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/api/v1/orders/%d", run))
+
+	return c.JSON(code, echo.Map{"run": run})
+}
+
+func newApp(addr string, h *handler) *echo.Echo {
+	config := &helpers.Config{}
+	config.Idempotency.TTL = time.Hour
+	config.Idempotency.PendingTTL = time.Minute
+
+	deps := &helpers.Dependencies{
+		Config: config,
+		Redis:  redis.NewRing(&redis.RingOptions{Addrs: map[string]string{"server": addr}}),
+		Logger: log.New(),
+	}
+
+	deps.Logger.SetOutput(io.Discard)
+
+	app := echo.New()
+	app.Use(helpers.Inject(deps))
+	app.POST("/api/v1/orders", h.serve, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			helpers.SetCurrentUser(c, &database.Users{ID: 1})
+			return next(c)
+		}
+	}, Idempotent())
+
+	return app
+}
+
+func post(app *echo.Echo, target string, key string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	if key != "" {
+		request.Header.Set(HeaderIdempotencyKey, key)
+	}
+
+	recorder := httptest.NewRecorder()
+	app.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestRetriesAreReplayed(t *testing.T) {
+	h := &handler{}
+	app := newApp(redistest.Start(t, nil).Addr(), h)
+
+	first := post(app, "/api/v1/orders", "key-1", `{"price":1000}`)
+	second := post(app, "/api/v1/orders", "key-1", `{"price":1000}`)
+
+	if h.runs != 1 {
+		t.Fatalf("handler ran %d times, want once", h.runs)
+	}
+
+	if second.Code != first.Code || second.Body.String() != first.Body.String() || second.Header().Get(echo.HeaderLocation) != "/api/v1/orders/1" {
+		t.Fatalf("got %d %q, want the first response %d %q again", second.Code, second.Body.String(), first.Code, first.Body.String())
+	}
+
+	if first.Header().Get("Idempotent-Replayed") != "" || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatal("only the replayed response should be marked as replayed")
+	}
+
+	if post(app, "/api/v1/orders", "key-2", `{"price":1000}`); h.runs != 2 {
+		t.Fatalf("a new key ran the handler %d times in total, want twice", h.runs)
+	}
+}
+
+func TestReusedKeysWithAnotherBodyAreRejected(t *testing.T) {
+	h := &handler{}
+	app := newApp(redistest.Start(t, nil).Addr(), h)
+
+	post(app, "/api/v1/orders", "key-1", `{"price":1000}`)
+
+	if recorder := post(app, "/api/v1/orders", "key-1", `{"price":2000}`); recorder.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("answered %d, want 422", recorder.Code)
+	}
+
+	if h.runs != 1 {
+		t.Fatalf("handler ran %d times, want once", h.runs)
+	}
+}
+
+func TestConcurrentRetriesConflict(t *testing.T) {
+	h := &handler{started: make(chan struct{}), release: make(chan struct{})}
+	app := newApp(redistest.Start(t, nil).Addr(), h)
+	done := make(chan *httptest.ResponseRecorder)
+
+	go func() {
+		done <- post(app, "/api/v1/orders", "key-1", `{"price":1000}`)
+	}()
+
+	<-h.started
+
+	if recorder := post(app, "/api/v1/orders", "key-1", `{"price":1000}`); recorder.Code != http.StatusConflict {
+		t.Fatalf("a retry while the first request runs answered %d, want 409", recorder.Code)
+	}
+
+	close(h.release)
+
+	if recorder := <-done; recorder.Code != http.StatusCreated {
+		t.Fatalf("the first request answered %d, want 201", recorder.Code)
+	}
+}
+
+func TestServerErrorsAreNotStored(t *testing.T) {
+	h := &handler{}
+	app := newApp(redistest.Start(t, nil).Addr(), h)
+
+	if recorder := post(app, "/api/v1/orders?status=500", "key-1", `{"price":1000}`); recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("answered %d, want 500", recorder.Code)
+	}
+
+	if recorder := post(app, "/api/v1/orders?status=500", "key-1", `{"price":1000}`); recorder.Code != http.StatusInternalServerError || h.runs != 2 {
+		t.Fatalf("retry answered %d after %d runs, want the handler to run again", recorder.Code, h.runs)
+	}
+}
+
+func TestRequestsWithoutKeysAlwaysRun(t *testing.T) {
+	h := &handler{}
+	app := newApp(redistest.Start(t, nil).Addr(), h)
+
+	post(app, "/api/v1/orders", "", `{"price":1000}`)
+	post(app, "/api/v1/orders", "", `{"price":1000}`)
+
+	if h.runs != 2 {
+		t.Fatalf("handler ran %d times, want twice", h.runs)
+	}
+
+	if recorder := post(app, "/api/v1/orders", strings.Repeat("k", 256), `{}`); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("an overlong key answered %d, want 400", recorder.Code)
+	}
+}
+
+func TestPendingKeysExpireBeforeStoredOnes(t *testing.T) {
+	h := &handler{started: make(chan struct{}), release: make(chan struct{})}
+	server := redistest.Start(t, nil)
+	app := newApp(server.Addr(), h)
+	storeKey := "idempotency:user:1:" + digest(http.MethodPost, "/api/v1/orders", "key-1")
+	done := make(chan *httptest.ResponseRecorder)
+
+	go func() {
+		done <- post(app, "/api/v1/orders", "key-1", `{"price":1000}`)
+	}()
+
+	<-h.started
+
+	if ttl := server.TTL(storeKey); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("the pending key lives for %s, want at most a minute", ttl)
+	}
+
+	close(h.release)
+	<-done
+
+	if ttl := server.TTL(storeKey); ttl <= time.Minute {
+		t.Fatalf("the stored response lives for %s, want an hour", ttl)
+	}
+}
+
+func TestPanicsReleaseTheKey(t *testing.T) {
+	h := &handler{}
+	app := newApp(redistest.Start(t, nil).Addr(), h)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("the panic of the handler was swallowed")
+			}
+		}()
+
+		post(app, "/api/v1/orders?panic=1", "key-1", `{"price":1000}`)
+	}()
+
+	if recorder := post(app, "/api/v1/orders", "key-1", `{"price":1000}`); recorder.Code != http.StatusCreated || h.runs != 2 {
+		t.Fatalf("retry answered %d after %d runs, want the handler to run again", recorder.Code, h.runs)
+	}
+}
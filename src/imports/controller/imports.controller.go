@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sahamrakyat_test/database"
+	importsService "sahamrakyat_test/imports/service"
+	ordersService "sahamrakyat_test/orders/service"
+	usersService "sahamrakyat_test/users/service"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MaxFileSize bounds the size of an uploaded import file.
+const MaxFileSize = 10 << 20
+
+// maxFormOverhead is what the rest of a multipart form may add to the file.
+const maxFormOverhead = 1 << 20
+
+func Orders(c echo.Context) error {
+	return importFile(c, "orders", database.Orders{}, ordersService.CreateMany, "/api/v1/orders/import/errors/:id")
+}
+
+func Users(c echo.Context) error {
+	return importFile(c, "users", database.Users{}, usersService.CreateMany, "/api/v1/users/import/errors/:id")
+}
+
+func OrdersErrors(c echo.Context) error {
+	return errorFile(c, "orders")
+}
+
+func UsersErrors(c echo.Context) error {
+	return errorFile(c, "users")
+}
+
+// importFile imports the CSV file uploaded in the file field of a multipart
+// form. The dry_run field only checks the rows, mode picks between atomic
+// and best_effort.
+func importFile(c echo.Context, resource string, model interface{}, create importsService.CreateFunc, errorsPath string) error {
+	// The body is cut off before the form is parsed, so an oversized upload
+	// is never read in full.
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, MaxFileSize+maxFormOverhead)
+
+	header, err := c.FormFile("file")
+
+	if tooLarge := (&http.MaxBytesError{}); errors.As(err, &tooLarge) || (err == nil && header.Size > MaxFileSize) {
+		return c.JSON(http.StatusRequestEntityTooLarge, echo.Map{
+			"statusCode": http.StatusRequestEntityTooLarge,
+			"message":    fmt.Sprintf("Import files are limited to %d MB.", MaxFileSize>>20),
+		})
+	}
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"statusCode": http.StatusBadRequest,
+			"message":    "A CSV file is required in the file field.",
+		})
+	}
+
+	options := importsService.Options{Mode: c.FormValue("mode")}
+
+	if dryRun := c.FormValue("dry_run"); dryRun != "" {
+		if options.DryRun, err = strconv.ParseBool(dryRun); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"statusCode": http.StatusBadRequest,
+				"message":    "Failed to parse dry_run.",
+			})
+		}
+	}
+
+	file, err := header.Open()
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Failed to open file.",
+		})
+	}
+
+	defer file.Close()
+
+	report, httpErr := importsService.Import(c, resource, model, file, options, create, errorsPath)
+
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{
+			"statusCode": httpErr.Code,
+			"message":    httpErr.Message,
+		})
+	}
+
+	message := fmt.Sprintf("Successfully imported %s.", resource)
+
+	if options.DryRun {
+		message = fmt.Sprintf("Successfully checked %s.", resource)
+	}
+
+	return c.JSON(report.Status(), echo.Map{
+		"statusCode": report.Status(),
+		"message":    message,
+		"data":       report,
+	})
+}
+
+// errorFile downloads the invalid rows of an earlier import.
+func errorFile(c echo.Context, resource string) error {
+	content, err := importsService.ErrorFile(c, resource)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-import-errors-%s.csv", resource, c.Param("id"))))
+
+	return c.Blob(http.StatusOK, "text/csv; charset=utf-8", content)
+}
@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// countingReader counts how much of a body was read.
+type countingReader struct {
+	io.Reader
+	read int
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.read += n
+
+	return n, err
+}
+
+func TestOversizedUploadsAreCutOff(t *testing.T) {
+	content := &bytes.Buffer{}
+	form := multipart.NewWriter(content)
+	file, _ := form.CreateFormFile("file", "orders.csv")
+
+	file.Write(bytes.Repeat([]byte("a"), 3*MaxFileSize))
+	form.Close()
+
+	body := &countingReader{Reader: content}
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/orders/import", body)
+	request.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+
+	recorder := httptest.NewRecorder()
+	c := echo.New().NewContext(request, recorder)
+
+	if err := Orders(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("answered %d, want 413: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if body.read > MaxFileSize+maxFormOverhead+64<<10 {
+		t.Fatalf("read %d bytes of the upload, want at most the limit", body.read)
+	}
+}
@@ -0,0 +1,408 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sahamrakyat_test/helpers"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/cache/v8"
+	"github.com/labstack/echo/v4"
+)
+
+// ErrorFileTTL is how long the error file of an import can be downloaded.
+const ErrorFileTTL = 24 * time.Hour
+
+// maxReportedErrors bounds the errors listed in the response, the error file
+// always lists every invalid row.
+const maxReportedErrors = 100
+
+// CreateFunc checks and creates the items of one resource, like the
+// CreateMany of the orders and users services.
+type CreateFunc func(c echo.Context, items []json.RawMessage, mode string, dryRun bool) (*helpers.BulkReport, *echo.HTTPError)
+
+// Options of one import.
+type Options struct {
+	Mode   string
+	DryRun bool
+}
+
+// RowError is an invalid row of an import file, Line counts the header.
+type RowError struct {
+	Line   int        `json:"line"`
+	Status int        `json:"status"`
+	Error  string     `json:"error"`
+	Fields []echo.Map `json:"fields,omitempty"`
+}
+
+// Report is the result of an import. Imported counts the rows written, or
+// the rows that would be in a dry run.
+type Report struct {
+	Mode      string     `json:"mode"`
+	DryRun    bool       `json:"dry_run"`
+	Rows      int        `json:"rows"`
+	Imported  int        `json:"imported"`
+	Failed    int        `json:"failed"`
+	Errors    []RowError `json:"errors"`
+	ErrorFile string     `json:"error_file,omitempty"`
+}
+
+// Status is the status of the whole import: 200 when every row was valid,
+// 207 when only some were and 422 when none were.
+func (r *Report) Status() int {
+	if r.Failed == 0 {
+		return http.StatusOK
+	} else if r.Imported > 0 {
+		return http.StatusMultiStatus
+	}
+
+	return http.StatusUnprocessableEntity
+}
+
+// redacted columns are left empty in error files, they are kept in the
+// cache for a day.
+var redacted = map[string]bool{"password": true}
+
+// errorFile is an error file kept in the cache for its uploader, by
+// helpers.ClientIdentity, to download.
+type errorFile struct {
+	Resource string
+	Owner    string
+	Content  []byte
+}
+
+// row is a line of an import file and the JSON item it was decoded into.
+type row struct {
+	line   int
+	record []string
+	item   json.RawMessage
+	err    string
+}
+
+// Import reads a CSV file of a resource with a header row of JSON field
+// names of model, and hands every row it can decode to create as a JSON
+// item, so rows are checked by the same validate tags and rules as the
+// bulk endpoint. Rows are written in batches, in best effort mode unless
+// the caller asks for atomic. When any row is invalid, a copy of the
+// invalid rows with their line and error is kept for ErrorFileTTL and its
+// path returned in the report, under errorsPath.
+func Import(c echo.Context, resource string, model interface{}, r io.Reader, options Options, create CreateFunc, errorsPath string) (*Report, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	if options.Mode == "" {
+		options.Mode = helpers.BulkBestEffort
+	}
+
+	if options.Mode != helpers.BulkAtomic && options.Mode != helpers.BulkBestEffort {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Mode must be %s or %s.", helpers.BulkAtomic, helpers.BulkBestEffort))
+	}
+
+	header, rows, err := parse(r, reflect.TypeOf(model))
+
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Failed to read %s file: %s.", resource, err))
+	}
+
+	if len(rows) == 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("The %s file has no rows.", resource))
+	}
+
+	report := &Report{Mode: options.Mode, DryRun: options.DryRun, Rows: len(rows), Errors: []RowError{}}
+	failures := make([]*RowError, len(rows))
+
+	items := []json.RawMessage{}
+	positions := []int{}
+
+	for i, row := range rows {
+		if row.err != "" {
+			failures[i] = &RowError{Line: row.line, Status: http.StatusBadRequest, Error: row.err}
+			continue
+		}
+
+		items = append(items, row.item)
+		positions = append(positions, i)
+	}
+
+	// An atomic import with rows that could not be decoded writes nothing,
+	// the other rows are still checked so the error file lists them all.
+	skipped := options.Mode == helpers.BulkAtomic && len(items) < len(rows)
+
+	if len(items) > 0 {
+		bulk, httpErr := create(c, items, options.Mode, options.DryRun || skipped)
+
+		if httpErr != nil {
+			return nil, httpErr
+		}
+
+		for k, result := range bulk.Items {
+			i := positions[k]
+
+			if result.Status < http.StatusBadRequest && skipped {
+				failures[i] = &RowError{Line: rows[i].line, Status: http.StatusFailedDependency, Error: "Not written, another row failed."}
+				continue
+			} else if result.Status < http.StatusBadRequest {
+				report.Imported++
+				continue
+			}
+
+			failures[i] = &RowError{Line: rows[i].line, Status: result.Status, Error: result.Error, Fields: result.Fields}
+		}
+	}
+
+	invalid := [][]string{}
+
+	for i, failure := range failures {
+		if failure == nil {
+			continue
+		}
+
+		report.Failed++
+
+		if len(report.Errors) < maxReportedErrors {
+			report.Errors = append(report.Errors, *failure)
+		}
+
+		record := append([]string{strconv.Itoa(failure.Line), failure.Error + fields(failure.Fields)}, rows[i].record...)
+
+		for column, name := range header {
+			if redacted[name] && column+2 < len(record) {
+				record[column+2] = ""
+			}
+		}
+
+		invalid = append(invalid, record)
+	}
+
+	if len(invalid) > 0 {
+		id, err := saveErrorFile(c, resource, append([]string{"line", "error"}, header...), invalid)
+
+		if err != nil {
+			logger.Errorf("Failed to keep error file of %s import: %v", resource, err)
+		} else {
+			report.ErrorFile = strings.Replace(errorsPath, ":id", id, 1)
+		}
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return report, nil
+}
+
+// ErrorFile returns the error file of an import of resource by the id in
+// the path, to the client that uploaded it only, its user or API key.
+func ErrorFile(c echo.Context, resource string) ([]byte, *echo.HTTPError) {
+	file := &errorFile{}
+
+	if err := helpers.Deps(c).Cache.Get(c.Request().Context(), errorFileKey(c.Param("id")), file); err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Error file not found or expired.")
+	}
+
+	if file.Resource != resource || file.Owner != helpers.ClientIdentity(c) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Error file not found or expired.")
+	}
+
+	return file.Content, nil
+}
+
+func saveErrorFile(c echo.Context, resource string, header []string, records [][]string) (string, error) {
+	content := &bytes.Buffer{}
+	writer := csv.NewWriter(content)
+
+	writer.Write(header)
+	writer.WriteAll(records)
+
+	if err := writer.Error(); err != nil {
+		return "", err
+	}
+
+	random := make([]byte, 16)
+
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	id := hex.EncodeToString(random)
+
+	file := &errorFile{Resource: resource, Owner: helpers.ClientIdentity(c), Content: content.Bytes()}
+
+	err := helpers.Deps(c).Cache.Set(&cache.Item{
+		Ctx:   c.Request().Context(),
+		Key:   errorFileKey(id),
+		Value: file,
+		TTL:   ErrorFileTTL,
+		// Only one instance handled the upload, the download may hit another.
+		SkipLocalCache: true,
+	})
+
+	return id, err
+}
+
+func errorFileKey(id string) string {
+	return fmt.Sprintf("imports:errors:%s", id)
+}
+
+// fields appends the fields that failed validation to an error message.
+func fields(fields []echo.Map) string {
+	if len(fields) == 0 {
+		return ""
+	}
+
+	names := make([]string, len(fields))
+
+	for i, field := range fields {
+		names[i] = fmt.Sprintf("%v (%v)", field["field"], field["tag"])
+	}
+
+	return " " + strings.Join(names, ", ")
+}
+
+// parse reads every row of a CSV file into a JSON item of modelType. The
+// header names JSON fields of the model, values are typed after the field
+// they go to and empty values are left out.
+func parse(r io.Reader, modelType reflect.Type) ([]string, []row, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	fields := fieldsOf(modelType)
+	kinds := make([]reflect.Type, len(header))
+
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		header[i] = name
+
+		kind, ok := fields[name]
+
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown column %q", name)
+		}
+
+		kinds[i] = kind
+	}
+
+	rows := []row{}
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		row := row{line: line, record: record}
+		item := map[string]json.RawMessage{}
+
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+
+			if i >= len(header) {
+				row.err = "Too many columns."
+				break
+			}
+
+			if value == "" {
+				continue
+			}
+
+			raw, err := encode(kinds[i], value)
+
+			if err != nil {
+				row.err = fmt.Sprintf("Invalid %s: %s.", header[i], err)
+				break
+			}
+
+			item[header[i]] = raw
+		}
+
+		if row.err == "" {
+			row.item, _ = json.Marshal(item)
+		}
+
+		rows = append(rows, row)
+	}
+
+	return header, rows, nil
+}
+
+// fieldsOf maps the JSON names of the fields a model reads from requests to
+// their types.
+func fieldsOf(modelType reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+
+	for i := 0; i < modelType.NumField(); i++ {
+		field := modelType.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		if name == "" || name == "-" || strings.Contains(field.Tag.Get("gorm"), "foreignKey") {
+			continue
+		}
+
+		fields[name] = field.Type
+	}
+
+	return fields
+}
+
+// encode turns a CSV value into the JSON of a field of fieldType.
+func encode(fieldType reflect.Type, value string) (json.RawMessage, error) {
+	if fieldType.Kind() == reflect.Pointer {
+		fieldType = fieldType.Elem()
+	}
+
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, errors.New("not a whole number")
+		}
+
+		return json.RawMessage(value), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, errors.New("not a positive whole number")
+		}
+
+		return json.RawMessage(value), nil
+	case reflect.Float32, reflect.Float64:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return nil, errors.New("not a number")
+		}
+
+		return json.RawMessage(value), nil
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+
+		if err != nil {
+			return nil, errors.New("not true or false")
+		}
+
+		return json.Marshal(parsed)
+	}
+
+	if fieldType == reflect.TypeOf(time.Time{}) {
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return nil, errors.New("not an RFC 3339 time")
+		}
+	}
+
+	return json.Marshal(value)
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sahamrakyat_test/database"
+	"sahamrakyat_test/helpers"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/cache/v8"
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// memoryRedis keeps what the cache stores in Redis in a map, so error files
+// survive the local cache they skip.
+type memoryRedis struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (m *memoryRedis) Set(ctx context.Context, key string, value interface{}, _ time.Duration) *redis.StatusCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values[key] = string(value.([]byte))
+
+	return redis.NewStatusResult("OK", nil)
+}
+
+func (m *memoryRedis) SetXX(ctx context.Context, key string, value interface{}, ttl time.Duration) *redis.BoolCmd {
+	m.Set(ctx, key, value, ttl)
+	return redis.NewBoolResult(true, nil)
+}
+
+func (m *memoryRedis) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) *redis.BoolCmd {
+	m.Set(ctx, key, value, ttl)
+	return redis.NewBoolResult(true, nil)
+}
+
+func (m *memoryRedis) Get(_ context.Context, key string) *redis.StringCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.values[key]
+
+	if !ok {
+		return redis.NewStringResult("", redis.Nil)
+	}
+
+	return redis.NewStringResult(value, nil)
+}
+
+func (m *memoryRedis) Del(_ context.Context, keys ...string) *redis.IntCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.values, key)
+	}
+
+	return redis.NewIntResult(int64(len(keys)), nil)
+}
+
+type item struct {
+	Ticker   string `json:"ticker"`
+	Quantity int64  `json:"quantity"`
+	Password string `json:"password"`
+}
+
+// creator fails items whose quantity is not a whole lot, like orders, and
+// records how it was called.
+type creator struct {
+	items  int
+	dryRun bool
+}
+
+func (cr *creator) create(c echo.Context, items []json.RawMessage, mode string, dryRun bool) (*helpers.BulkReport, *echo.HTTPError) {
+	cr.items, cr.dryRun = len(items), dryRun
+	report := helpers.NewBulkReport(mode, len(items))
+
+	for i, raw := range items {
+		decoded := item{}
+		json.Unmarshal(raw, &decoded)
+
+		if decoded.Quantity%database.DefaultLotSize != 0 {
+			report.Fail(i, http.StatusBadRequest, "Quantity must be a multiple of the lot size.")
+			continue
+		}
+
+		report.Succeed(i, uint(i+1), http.StatusCreated)
+	}
+
+	return report, nil
+}
+
+const file = `ticker,quantity,password
+BBCA,100,secret
+TLKM,many,secret
+ASII,150,secret
+BBRI,200,secret
+`
+
+// client returns a request context of user id sharing deps.
+func client(deps *helpers.Dependencies, id uint) echo.Context {
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/orders/import", nil)
+	request = request.WithContext(helpers.WithDependencies(request.Context(), deps))
+
+	c := echo.New().NewContext(request, httptest.NewRecorder())
+	helpers.SetCurrentUser(c, &database.Users{ID: id})
+
+	return c
+}
+
+func newDeps() *helpers.Dependencies {
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	return &helpers.Dependencies{
+		Cache:  &helpers.Cache{Cache: cache.New(&cache.Options{Redis: &memoryRedis{values: map[string]string{}}})},
+		Logger: logger,
+	}
+}
+
+func TestImportReportsInvalidRows(t *testing.T) {
+	deps := newDeps()
+	cr := &creator{}
+
+	report, err := Import(client(deps, 1), "orders", item{}, strings.NewReader(file), Options{}, cr.create, "/api/v1/orders/import/errors/:id")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Mode != helpers.BulkBestEffort || report.Rows != 4 || report.Imported != 2 || report.Failed != 2 || report.Status() != http.StatusMultiStatus {
+		t.Fatalf("got %+v, want 2 of 4 rows imported", report)
+	}
+
+	// The row that could not be decoded never reaches create.
+	if cr.items != 3 || cr.dryRun {
+		t.Fatalf("created %d items, dry run %v, want the 3 decoded rows written", cr.items, cr.dryRun)
+	}
+
+	if len(report.Errors) != 2 || report.Errors[0].Line != 3 || report.Errors[1].Line != 4 || report.Errors[1].Status != http.StatusBadRequest {
+		t.Fatalf("got errors %+v, want lines 3 and 4", report.Errors)
+	}
+
+	if !strings.HasPrefix(report.ErrorFile, "/api/v1/orders/import/errors/") {
+		t.Fatalf("got error file %q", report.ErrorFile)
+	}
+
+	id := strings.TrimPrefix(report.ErrorFile, "/api/v1/orders/import/errors/")
+	owner := client(deps, 1)
+	owner.SetParamNames("id")
+	owner.SetParamValues(id)
+
+	content, err := ErrorFile(owner, "orders")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(string(content)), "\n"); len(lines) != 3 || lines[0] != "line,error,ticker,quantity,password" || !strings.HasPrefix(lines[2], "4,") || strings.Contains(string(content), "secret") {
+		t.Fatalf("got error file:\n%s\nwant the header and lines 3 and 4 without passwords", content)
+	}
+
+	other := client(deps, 2)
+	other.SetParamNames("id")
+	other.SetParamValues(id)
+
+	if _, err := ErrorFile(other, "orders"); err == nil || err.Code != http.StatusNotFound {
+		t.Fatalf("another user got %v, want 404", err)
+	}
+
+	if _, err := ErrorFile(owner, "users"); err == nil || err.Code != http.StatusNotFound {
+		t.Fatalf("the error file of another resource answered %v, want 404", err)
+	}
+}
+
+func TestAtomicImportWritesNothingWithInvalidRows(t *testing.T) {
+	cr := &creator{}
+
+	report, err := Import(client(newDeps(), 1), "orders", item{}, strings.NewReader(file), Options{Mode: helpers.BulkAtomic}, cr.create, "/:id")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cr.dryRun || report.Imported != 0 || report.Failed != 4 || report.Status() != http.StatusUnprocessableEntity {
+		t.Fatalf("got %+v, dry run %v, want every row failed and nothing written", report, cr.dryRun)
+	}
+
+	if report.Errors[0].Line != 2 || report.Errors[0].Status != http.StatusFailedDependency {
+		t.Fatalf("got %+v, want the valid row skipped", report.Errors[0])
+	}
+}
+
+func TestImportRejectsBadFiles(t *testing.T) {
+	cases := map[string]string{
+		"unknown column": "ticker,price\nBBCA,1000\n",
+		"no rows":        "ticker,quantity\n",
+		"empty":          "",
+	}
+
+	for name, content := range cases {
+		cr := &creator{}
+
+		if _, err := Import(client(newDeps(), 1), "orders", item{}, strings.NewReader(content), Options{}, cr.create, "/:id"); err == nil || err.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %v, want 400", name, err)
+		}
+
+		if cr.items != 0 {
+			t.Errorf("%s: created %d items, want none", name, cr.items)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// Package engine is an in-process limit order book matching buy and sell
+// orders per stock with price-time priority.
+//
+// Each stock has its own book guarded by its own lock, so orders on
+// different stocks are matched concurrently while orders on the same stock
+// are matched one at a time in arrival order. Trades execute at the price of
+// the resting order.
+package engine
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	SideBuy  = "buy"
+	SideSell = "sell"
+)
+
+type Order struct {
+	ID        uint
+	StocksID  uint
+	Side      string
+	Price     uint
+	Quantity  uint      // open quantity still to be matched
+	ExpiresAt time.Time // zero when the order never expires
+	sequence  uint64
+}
+
+type Trade struct {
+	StocksID    uint
+	BuyOrderID  uint
+	SellOrderID uint
+	Price       uint
+	Quantity    uint
+	ExecutedAt  time.Time
+}
+
+type Engine struct {
+	mu       sync.Mutex
+	books    map[uint]*book
+	sequence uint64
+	clock    func() time.Time
+}
+
+type book struct {
+	mu   sync.Mutex
+	bids []*Order // best first: highest price, then oldest
+	asks []*Order // best first: lowest price, then oldest
+}
+
+var defaultEngine = New()
+
+// Default returns the engine shared by the whole process.
+func Default() *Engine {
+	return defaultEngine
+}
+
+func New() *Engine {
+	return &Engine{books: map[uint]*book{}, clock: time.Now}
+}
+
+// NewReplay returns an engine with a logical clock starting at start and
+// advancing by step each time it is read, so replaying the same orders
+// always produces identical trades including their timestamps.
+func NewReplay(start time.Time, step time.Duration) *Engine {
+	engine := New()
+	now := start
+
+	engine.clock = func() time.Time {
+		executedAt := now
+		now = now.Add(step)
+		return executedAt
+	}
+
+	return engine
+}
+
+// Replay submits orders one after another and returns every trade produced,
+// in execution order. Meant to be used with an engine from NewReplay.
+func (e *Engine) Replay(orders []Order) []Trade {
+	trades := []Trade{}
+
+	for _, order := range orders {
+		trades = append(trades, e.Submit(order)...)
+	}
+
+	return trades
+}
+
+// Submit matches an incoming order against the opposite side of its book and
+// rests whatever quantity is left.
+func (e *Engine) Submit(order Order) []Trade {
+	trades, _ := e.SubmitWith(order, nil)
+	return trades
+}
+
+// SubmitWith matches like Submit, but only changes the book once apply
+// accepted the trades. apply runs under the lock of the book, so it is meant
+// to persist the trades; when it fails the book is left as it was and its
+// error returned. A nil apply accepts every match.
+func (e *Engine) SubmitWith(order Order, apply func(trades []Trade) error) ([]Trade, error) {
+	b := e.book(order.StocksID)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	order.sequence = e.nextSequence()
+
+	trades := []Trade{}
+	now := e.now()
+
+	opposite := &b.asks
+	if order.Side == SideSell {
+		opposite = &b.bids
+	}
+
+	// Resting orders before next were filled or have expired, the one at
+	// next keeps rest when it was only partially filled.
+	next := 0
+	partial := false
+	var rest uint
+
+	for order.Quantity > 0 && next < len(*opposite) {
+		resting := (*opposite)[next]
+
+		if resting.expired(now) {
+			next++
+			continue
+		}
+
+		if !order.crosses(resting) {
+			break
+		}
+
+		quantity := order.Quantity
+		if resting.Quantity < quantity {
+			quantity = resting.Quantity
+		}
+
+		trade := Trade{
+			StocksID:   order.StocksID,
+			Price:      resting.Price,
+			Quantity:   quantity,
+			ExecutedAt: now,
+		}
+
+		if order.Side == SideBuy {
+			trade.BuyOrderID, trade.SellOrderID = order.ID, resting.ID
+		} else {
+			trade.BuyOrderID, trade.SellOrderID = resting.ID, order.ID
+		}
+
+		trades = append(trades, trade)
+
+		order.Quantity -= quantity
+
+		if quantity == resting.Quantity {
+			next++
+		} else {
+			partial, rest = true, resting.Quantity-quantity
+		}
+
+		if order.Quantity > 0 {
+			now = e.now()
+		}
+	}
+
+	if apply != nil {
+		if err := apply(trades); err != nil {
+			return nil, err
+		}
+	}
+
+	*opposite = (*opposite)[next:]
+
+	if partial {
+		(*opposite)[0].Quantity = rest
+	}
+
+	if order.Quantity > 0 {
+		b.insert(&order)
+	}
+
+	return trades, nil
+}
+
+// Restore puts an order back on its book without matching it, used to
+// rebuild the books from the database after a restart. Orders must be
+// restored in the order they were originally placed.
+func (e *Engine) Restore(order Order) {
+	if order.Quantity == 0 {
+		return
+	}
+
+	b := e.book(order.StocksID)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	order.sequence = e.nextSequence()
+	b.insert(&order)
+}
+
+// Cancel removes a resting order from its book, reporting whether it was
+// found.
+func (e *Engine) Cancel(stocksID uint, orderID uint) bool {
+	b := e.book(stocksID)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, side := range []*[]*Order{&b.bids, &b.asks} {
+		for i, order := range *side {
+			if order.ID == orderID {
+				*side = append((*side)[:i], (*side)[i+1:]...)
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Depth returns a copy of the resting orders of a stock, best first.
+func (e *Engine) Depth(stocksID uint) (bids []Order, asks []Order) {
+	b := e.book(stocksID)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, order := range b.bids {
+		bids = append(bids, *order)
+	}
+
+	for _, order := range b.asks {
+		asks = append(asks, *order)
+	}
+
+	return bids, asks
+}
+
+func (e *Engine) book(stocksID uint) *book {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b, ok := e.books[stocksID]
+
+	if !ok {
+		b = &book{}
+		e.books[stocksID] = b
+	}
+
+	return b
+}
+
+func (e *Engine) nextSequence() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.sequence++
+	return e.sequence
+}
+
+func (e *Engine) now() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.clock()
+}
+
+func (b *book) insert(order *Order) {
+	side := &b.asks
+	if order.Side == SideBuy {
+		side = &b.bids
+	}
+
+	// Find the first resting order the new one has priority over, equal
+	// prices keep arrival order.
+	i := sort.Search(len(*side), func(i int) bool {
+		if order.Side == SideBuy {
+			return (*side)[i].Price < order.Price
+		}
+		return (*side)[i].Price > order.Price
+	})
+
+	*side = append(*side, nil)
+	copy((*side)[i+1:], (*side)[i:])
+	(*side)[i] = order
+}
+
+func (o *Order) crosses(resting *Order) bool {
+	if o.Side == SideBuy {
+		return o.Price >= resting.Price
+	}
+	return o.Price <= resting.Price
+}
+
+func (o *Order) expired(now time.Time) bool {
+	return !o.ExpiresAt.IsZero() && !now.Before(o.ExpiresAt)
+}
@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+
+func TestPriceTimePriority(t *testing.T) {
+	e := NewReplay(start, time.Second)
+
+	e.Submit(Order{ID: 1, StocksID: 1, Side: SideSell, Price: 101, Quantity: 100})
+	e.Submit(Order{ID: 2, StocksID: 1, Side: SideSell, Price: 100, Quantity: 100})
+	e.Submit(Order{ID: 3, StocksID: 1, Side: SideSell, Price: 100, Quantity: 100})
+
+	trades := e.Submit(Order{ID: 4, StocksID: 1, Side: SideBuy, Price: 101, Quantity: 300})
+
+	sellers := []uint{}
+
+	for _, trade := range trades {
+		sellers = append(sellers, trade.SellOrderID)
+
+		if trade.BuyOrderID != 4 {
+			t.Errorf("trade %+v does not belong to buy order 4", trade)
+		}
+	}
+
+	if want := []uint{2, 3, 1}; !reflect.DeepEqual(sellers, want) {
+		t.Fatalf("matched sell orders %v, want %v", sellers, want)
+	}
+
+	if trades[0].Price != 100 || trades[2].Price != 101 {
+		t.Errorf("trades did not execute at the resting prices: %+v", trades)
+	}
+}
+
+func TestPartialFill(t *testing.T) {
+	e := New()
+
+	e.Submit(Order{ID: 1, StocksID: 1, Side: SideBuy, Price: 100, Quantity: 500})
+	trades := e.Submit(Order{ID: 2, StocksID: 1, Side: SideSell, Price: 99, Quantity: 200})
+
+	if len(trades) != 1 || trades[0].Quantity != 200 || trades[0].Price != 100 {
+		t.Fatalf("got trades %+v, want 200 @ 100", trades)
+	}
+
+	bids, asks := e.Depth(1)
+
+	if len(asks) != 0 || len(bids) != 1 || bids[0].ID != 1 || bids[0].Quantity != 300 {
+		t.Fatalf("got bids %+v and asks %+v, want buy order 1 resting with 300", bids, asks)
+	}
+
+	trades = e.Submit(Order{ID: 3, StocksID: 1, Side: SideSell, Price: 100, Quantity: 400})
+
+	if len(trades) != 1 || trades[0].Quantity != 300 {
+		t.Fatalf("got trades %+v, want 300", trades)
+	}
+
+	bids, asks = e.Depth(1)
+
+	if len(bids) != 0 || len(asks) != 1 || asks[0].ID != 3 || asks[0].Quantity != 100 {
+		t.Fatalf("got bids %+v and asks %+v, want sell order 3 resting with 100", bids, asks)
+	}
+}
+
+func TestNoCross(t *testing.T) {
+	e := New()
+
+	e.Submit(Order{ID: 1, StocksID: 1, Side: SideSell, Price: 101, Quantity: 100})
+
+	if trades := e.Submit(Order{ID: 2, StocksID: 1, Side: SideBuy, Price: 100, Quantity: 100}); len(trades) != 0 {
+		t.Fatalf("orders that do not cross traded: %+v", trades)
+	}
+
+	if trades := e.Submit(Order{ID: 3, StocksID: 2, Side: SideBuy, Price: 101, Quantity: 100}); len(trades) != 0 {
+		t.Fatalf("orders on different stocks traded: %+v", trades)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	e := New()
+
+	e.Submit(Order{ID: 1, StocksID: 1, Side: SideSell, Price: 100, Quantity: 100})
+	e.Submit(Order{ID: 2, StocksID: 1, Side: SideSell, Price: 100, Quantity: 100})
+
+	if !e.Cancel(1, 1) {
+		t.Fatal("resting order 1 was not found")
+	}
+
+	if e.Cancel(1, 1) {
+		t.Fatal("order 1 was cancelled twice")
+	}
+
+	trades := e.Submit(Order{ID: 3, StocksID: 1, Side: SideBuy, Price: 100, Quantity: 200})
+
+	if len(trades) != 1 || trades[0].SellOrderID != 2 {
+		t.Fatalf("got trades %+v, want only sell order 2", trades)
+	}
+}
+
+func TestExpiredOrdersAreSkipped(t *testing.T) {
+	e := NewReplay(start, time.Second)
+
+	e.Submit(Order{ID: 1, StocksID: 1, Side: SideSell, Price: 99, Quantity: 100, ExpiresAt: start.Add(time.Second)})
+	e.Submit(Order{ID: 2, StocksID: 1, Side: SideSell, Price: 100, Quantity: 100})
+
+	trades := e.Submit(Order{ID: 3, StocksID: 1, Side: SideBuy, Price: 100, Quantity: 100})
+
+	if len(trades) != 1 || trades[0].SellOrderID != 2 {
+		t.Fatalf("got trades %+v, want only sell order 2", trades)
+	}
+
+	if _, asks := e.Depth(1); len(asks) != 0 {
+		t.Fatalf("expired order is still resting: %+v", asks)
+	}
+}
+
+func TestSubmitWithLeavesTheBookOnFailure(t *testing.T) {
+	e := New()
+	failure := errors.New("database down")
+
+	e.Submit(Order{ID: 1, StocksID: 1, Side: SideSell, Price: 100, Quantity: 100})
+	e.Submit(Order{ID: 2, StocksID: 1, Side: SideSell, Price: 100, Quantity: 100})
+
+	trades, err := e.SubmitWith(Order{ID: 3, StocksID: 1, Side: SideBuy, Price: 100, Quantity: 150}, func([]Trade) error {
+		return failure
+	})
+
+	if err != failure || trades != nil {
+		t.Fatalf("got %+v and %v, want the error of apply", trades, err)
+	}
+
+	bids, asks := e.Depth(1)
+
+	if len(bids) != 0 || len(asks) != 2 || asks[0].Quantity != 100 || asks[1].Quantity != 100 {
+		t.Fatalf("book changed after a failed apply: bids %+v, asks %+v", bids, asks)
+	}
+
+	trades, err = e.SubmitWith(Order{ID: 3, StocksID: 1, Side: SideBuy, Price: 100, Quantity: 150}, func([]Trade) error {
+		return nil
+	})
+
+	if err != nil || len(trades) != 2 {
+		t.Fatalf("got %+v and %v, want two trades", trades, err)
+	}
+
+	if _, asks := e.Depth(1); len(asks) != 1 || asks[0].ID != 2 || asks[0].Quantity != 50 {
+		t.Fatalf("got asks %+v, want sell order 2 resting with 50", asks)
+	}
+}
+
+func TestReplayIsDeterministic(t *testing.T) {
+	orders := []Order{
+		{ID: 1, StocksID: 1, Side: SideSell, Price: 102, Quantity: 300},
+		{ID: 2, StocksID: 1, Side: SideSell, Price: 101, Quantity: 200},
+		{ID: 3, StocksID: 2, Side: SideBuy, Price: 50, Quantity: 100},
+		{ID: 4, StocksID: 1, Side: SideBuy, Price: 102, Quantity: 400},
+		{ID: 5, StocksID: 2, Side: SideSell, Price: 49, Quantity: 100},
+		{ID: 6, StocksID: 1, Side: SideBuy, Price: 103, Quantity: 200},
+	}
+
+	first := NewReplay(start, time.Millisecond).Replay(orders)
+	second := NewReplay(start, time.Millisecond).Replay(orders)
+
+	if len(first) == 0 {
+		t.Fatal("replay produced no trades")
+	}
+
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("replays differ:\n%+v\n%+v", first, second)
+	}
+
+	for i := 1; i < len(first); i++ {
+		if !first[i].ExecutedAt.After(first[i-1].ExecutedAt) {
+			t.Fatalf("trade %d did not execute after trade %d: %+v", i, i-1, first)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module sahamrakyat_test/matching/engine
+
+go 1.19
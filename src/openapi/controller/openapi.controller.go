@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"sahamrakyat_test/openapi/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+// JSON serves the OpenAPI document, which may be filled in after the route
+// is registered. An empty document is a 500.
+func JSON(document *service.Document) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if document.OpenAPI == "" {
+			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+				"statusCode": http.StatusInternalServerError,
+				"message":    "The API document could not be built.",
+			})
+		}
+
+		return c.JSON(http.StatusOK, document)
+	}
+}
+
+// Docs serves a Redoc page rendering the document at specURL.
+func Docs(title string, specURL string) echo.HandlerFunc {
+	page := fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+<title>%s</title>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body>
+<redoc spec-url="%s"></redoc>
+<script src="https://cdn.redoc.ly/redoc/v2.1.2/bundles/redoc.standalone.js"></script>
+</body>
+</html>
+`, html.EscapeString(title), html.EscapeString(specURL))
+
+	return func(c echo.Context) error {
+		return c.HTML(http.StatusOK, page)
+	}
+}
@@ -0,0 +1,250 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"sahamrakyat_test/helpers"
+	"sahamrakyat_test/openapi/service"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Validate checks every request against the operation the document has for
+// its route before it reaches the controller: path and query parameters,
+// and JSON bodies. A request that breaks the document answers 400 with the
+// violations, like a failed validation in the services. Routes the document
+// does not describe, and form bodies, are left to their controllers.
+//
+// With APP_ENV=development the JSON responses are checked as well, and a
+// response that breaks the document is logged, since it is a bug in either
+// the handler or the document. The document may be filled in after the
+// middleware is created.
+func Validate(document *service.Document) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			operation := document.Operation(c.Request().Method, c.Path())
+
+			if operation == nil {
+				return next(c)
+			}
+
+			violations := parameters(c, document, operation)
+
+			bodyViolations, err := body(c, document, operation)
+
+			if err != nil {
+				return reply(c, http.StatusBadRequest, "Failed to read request body.")
+			}
+
+			violations = append(violations, bodyViolations...)
+
+			if len(violations) > 0 {
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"statusCode": http.StatusBadRequest,
+					"message": echo.Map{
+						"message": "Failed to validate request.",
+						"error":   violations,
+					},
+				})
+			}
+
+			if deps := helpers.Deps(c); deps == nil || deps.Config.App.Env != "development" {
+				return next(c)
+			}
+
+			return checkResponse(c, next, document, operation)
+		}
+	}
+}
+
+// parameters checks the path and query parameters of a request. Query
+// parameters are optional unless required, and ones the operation does not
+// list are ignored.
+func parameters(c echo.Context, document *service.Document, operation *service.PathOperation) []service.Violation {
+	violations := []service.Violation{}
+	query := c.QueryParams()
+
+	for _, parameter := range operation.Parameters {
+		var raw string
+
+		switch parameter.In {
+		case "path":
+			raw = c.Param(parameter.Name)
+		case "query":
+			if !query.Has(parameter.Name) {
+				if parameter.Required {
+					violations = append(violations, service.Violation{In: "query", Field: parameter.Name, Tag: "required"})
+				}
+
+				continue
+			}
+
+			raw = query.Get(parameter.Name)
+		default:
+			continue
+		}
+
+		violations = append(violations, document.Check(parameter.In, parameter.Name, parameter.Schema, coerce(document.Resolve(parameter.Schema), raw))...)
+	}
+
+	return violations
+}
+
+// coerce types a parameter after its schema, values that are not of the
+// type are left as strings for Check to report.
+func coerce(schema *service.Schema, raw string) interface{} {
+	if schema == nil {
+		return raw
+	}
+
+	if schema.Is("integer") || schema.Is("number") {
+		if _, err := strconv.ParseFloat(raw, 64); err == nil {
+			return json.Number(raw)
+		}
+	}
+
+	if schema.Is("boolean") {
+		if value, err := strconv.ParseBool(raw); err == nil {
+			return value
+		}
+	}
+
+	return raw
+}
+
+// body checks a JSON request body, and puts it back for the controller. An
+// empty body is checked as an empty object, so missing required fields are
+// reported.
+func body(c echo.Context, document *service.Document, operation *service.PathOperation) ([]service.Violation, error) {
+	if operation.RequestBody == nil {
+		return nil, nil
+	}
+
+	mediaType, ok := operation.RequestBody.Content[echo.MIMEApplicationJSON]
+
+	if !ok {
+		return nil, nil
+	}
+
+	request := c.Request()
+
+	if contentType := request.Header.Get(echo.HeaderContentType); contentType != "" && !isJSON(contentType) {
+		// Bind answers other media types itself.
+		return nil, nil
+	}
+
+	content, err := io.ReadAll(request.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.Body = io.NopCloser(bytes.NewReader(content))
+
+	if len(bytes.TrimSpace(content)) == 0 {
+		content = []byte("{}")
+	}
+
+	value, err := decode(content)
+
+	if err != nil {
+		return []service.Violation{{In: "body", Tag: "json", Param: err.Error()}}, nil
+	}
+
+	return document.Check("body", "", mediaType.Schema, value), nil
+}
+
+// checkResponse runs the handler and logs where its JSON response breaks
+// the document.
+func checkResponse(c echo.Context, next echo.HandlerFunc, document *service.Document, operation *service.PathOperation) error {
+	recorder := &responseRecorder{ResponseWriter: c.Response().Writer}
+	c.Response().Writer = recorder
+
+	err := next(c)
+
+	c.Response().Writer = recorder.ResponseWriter
+
+	if err != nil || !c.Response().Committed || !isJSON(c.Response().Header().Get(echo.HeaderContentType)) {
+		return err
+	}
+
+	response, ok := operation.Responses[strconv.Itoa(c.Response().Status)]
+
+	if !ok {
+		response = operation.Responses["default"]
+	}
+
+	logger := helpers.Deps(c).Logger
+	route := c.Request().Method + " " + c.Path()
+
+	if response == nil || response.Content[echo.MIMEApplicationJSON] == nil {
+		logger.Warnf("Response of %s has an undocumented status %d.", route, c.Response().Status)
+		return nil
+	}
+
+	value, decodeErr := decode(recorder.body.Bytes())
+
+	if decodeErr != nil {
+		logger.Warnf("Response of %s is not JSON: %v", route, decodeErr)
+		return nil
+	}
+
+	if violations := document.Check("response", "", response.Content[echo.MIMEApplicationJSON].Schema, value); len(violations) > 0 {
+		logger.Warnf("Response %d of %s does not match the API document: %+v", c.Response().Status, route, violations)
+	}
+
+	return nil
+}
+
+func decode(content []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	var value interface{}
+
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == echo.MIMEApplicationJSON || strings.HasSuffix(mediaType, "+json"))
+}
+
+func reply(c echo.Context, status int, message string) error {
+	return c.JSON(status, echo.Map{
+		"statusCode": status,
+		"message":    message,
+	})
+}
+
+// responseRecorder keeps a copy of the body written through it.
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return r.ResponseWriter.(http.Hijacker).Hijack()
+}
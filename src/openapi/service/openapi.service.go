@@ -0,0 +1,876 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Binary is the type of uploaded files in form bodies.
+type Binary []byte
+
+// Operation documents one route for Build, keyed by "METHOD /path" with the
+// path as registered in echo.
+type Operation struct {
+	Summary     string
+	Description string
+	// Permission the route requires, nothing for routes any user may call.
+	Permission string
+	// Public routes need no token or API key.
+	Public bool
+	// Parameters are the query parameters, and path parameters other than
+	// numeric ids, which are documented without being listed.
+	Parameters []*Parameter
+	// Body is a value of the type the request body is decoded into.
+	Body interface{}
+	// Partial bodies may leave out fields that are otherwise required.
+	Partial bool
+	// Form bodies are sent as multipart/form-data instead of JSON.
+	Form bool
+	// Data is a value of the type of the data field of the response.
+	Data interface{}
+	// Status of a successful response, 200 when left out.
+	Status int
+	// Files are the media types of a response that is a file download
+	// instead of JSON.
+	Files []string
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// Schema is the part of JSON Schema the document uses.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Types                []string           `json:"-"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+}
+
+// MarshalJSON writes type as a string when the schema has one type and as
+// a list when it is nullable.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	type plain Schema
+
+	out := struct {
+		Type interface{} `json:"type,omitempty"`
+		*plain
+	}{plain: (*plain)(s)}
+
+	if len(s.Types) == 1 {
+		out.Type = s.Types[0]
+	} else if len(s.Types) > 1 {
+		out.Type = s.Types
+	}
+
+	return json.Marshal(out)
+}
+
+// Is tells whether the schema allows values of a JSON type.
+func (s *Schema) Is(jsonType string) bool {
+	for _, t := range s.Types {
+		if t == jsonType {
+			return true
+		}
+	}
+
+	return false
+}
+
+type Document struct {
+	OpenAPI    string                               `json:"openapi"`
+	Info       Info                                 `json:"info"`
+	Paths      map[string]map[string]*PathOperation `json:"paths"`
+	Components Components                           `json:"components"`
+	Security   []map[string][]string                `json:"security"`
+	operations map[string]*PathOperation
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type PathOperation struct {
+	OperationID string                 `json:"operationId"`
+	Summary     string                 `json:"summary,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Tags        []string               `json:"tags"`
+	Parameters  []*Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody           `json:"requestBody,omitempty"`
+	Responses   map[string]*Response   `json:"responses"`
+	Security    *[]map[string][]string `json:"security,omitempty"`
+	Permission  string                 `json:"x-permission,omitempty"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// Operation returns the documented operation of a route, by its method and
+// path as registered in echo.
+func (d *Document) Operation(method string, path string) *PathOperation {
+	return d.operations[method+" "+path]
+}
+
+// Resolve follows a $ref to the component it names.
+func (d *Document) Resolve(schema *Schema) *Schema {
+	for schema != nil && schema.Ref != "" {
+		schema = d.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+
+	return schema
+}
+
+// Key is how a route is looked up in the operations given to Build.
+func Key(route *echo.Route) string {
+	return route.Method + " " + route.Path
+}
+
+var catchAll = runtime.FuncForPC(reflect.ValueOf(echo.NotFoundHandler).Pointer()).Name()
+
+// Documented leaves out the catch-all routes echo adds for group
+// middleware, which are not part of the API.
+func Documented(routes []*echo.Route) []*echo.Route {
+	documented := []*echo.Route{}
+
+	for _, route := range routes {
+		if route.Name == catchAll || route.Method == echo.RouteNotFound {
+			continue
+		}
+
+		documented = append(documented, route)
+	}
+
+	return documented
+}
+
+// Missing lists the routes without an operation, sorted.
+func Missing(routes []*echo.Route, operations map[string]Operation) []string {
+	missing := []string{}
+
+	for _, route := range Documented(routes) {
+		if _, ok := operations[Key(route)]; !ok {
+			missing = append(missing, Key(route))
+		}
+	}
+
+	sort.Strings(missing)
+
+	return missing
+}
+
+// Build writes the OpenAPI 3.1 document of the routes of an app. Schemas
+// are generated from the json and validate tags of the types in operations,
+// so the document follows the models. It fails when a route has no
+// operation.
+func Build(info Info, routes []*echo.Route, operations map[string]Operation) (*Document, error) {
+	if missing := Missing(routes, operations); len(missing) > 0 {
+		return nil, fmt.Errorf("routes without an OpenAPI operation: %s", strings.Join(missing, ", "))
+	}
+
+	generator := &generator{schemas: map[string]*Schema{}, names: map[reflect.Type]string{}}
+
+	document := &Document{
+		OpenAPI: "3.1.0",
+		Info:    info,
+		Paths:   map[string]map[string]*PathOperation{},
+		Components: Components{
+			Schemas: generator.schemas,
+			SecuritySchemes: map[string]*SecurityScheme{
+				"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "Access token from /api/v1/auth/login."},
+				"apiKey": {Type: "apiKey", In: "header", Name: "Authorization", Description: "An API key, sent as \"ApiKey <key>\"."},
+			},
+		},
+		Security:   []map[string][]string{{"bearer": {}}, {"apiKey": {}}},
+		operations: map[string]*PathOperation{},
+	}
+
+	generator.schemas["Error"] = &Schema{
+		Types: []string{"object"},
+		Properties: map[string]*Schema{
+			"statusCode": {Types: []string{"integer"}},
+			"message":    {Types: []string{"string", "object"}, Description: "A message, or the message and the fields that failed validation."},
+		},
+		Required: []string{"statusCode", "message"},
+	}
+
+	for _, route := range Documented(routes) {
+		spec := operations[Key(route)]
+		path, pathParameters := convertPath(route.Path)
+
+		operation := &PathOperation{
+			OperationID: operationID(route),
+			Summary:     spec.Summary,
+			Description: spec.Description,
+			Tags:        []string{tag(route.Path)},
+			Responses:   map[string]*Response{},
+			Permission:  spec.Permission,
+		}
+
+		if spec.Permission != "" {
+			operation.Description = strings.TrimSpace(operation.Description + "\n\nRequires the " + spec.Permission + " permission.")
+		}
+
+		if spec.Public {
+			operation.Security = &[]map[string][]string{}
+		}
+
+		for _, name := range pathParameters {
+			parameter := &Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Types: []string{"integer"}, Minimum: float(1)}}
+
+			for _, documented := range spec.Parameters {
+				if documented.In == "path" && documented.Name == name {
+					parameter = documented
+				}
+			}
+
+			operation.Parameters = append(operation.Parameters, parameter)
+		}
+
+		for _, parameter := range spec.Parameters {
+			if parameter.In != "path" {
+				operation.Parameters = append(operation.Parameters, parameter)
+			}
+		}
+
+		if spec.Body != nil {
+			schema := generator.schemaOf(reflect.TypeOf(spec.Body))
+			mediaType := echo.MIMEApplicationJSON
+
+			if spec.Partial {
+				schema = generator.partial(schema)
+			}
+
+			if spec.Form {
+				mediaType = echo.MIMEMultipartForm
+			}
+
+			operation.RequestBody = &RequestBody{Required: true, Content: map[string]*MediaType{mediaType: {Schema: schema}}}
+		}
+
+		status := spec.Status
+
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		success := &Response{Description: http.StatusText(status), Content: map[string]*MediaType{}}
+
+		if len(spec.Files) > 0 {
+			for _, mediaType := range spec.Files {
+				success.Content[mediaType] = &MediaType{Schema: &Schema{Types: []string{"string"}, Format: "binary"}}
+			}
+		} else {
+			envelope := &Schema{
+				Types: []string{"object"},
+				Properties: map[string]*Schema{
+					"statusCode": {Types: []string{"integer"}},
+					"message":    {Types: []string{"string"}},
+				},
+				Required: []string{"statusCode", "message"},
+			}
+
+			if spec.Data != nil {
+				envelope.Properties["data"] = generator.schemaOf(reflect.TypeOf(spec.Data))
+				envelope.Required = append(envelope.Required, "data")
+			}
+
+			success.Content[echo.MIMEApplicationJSON] = &MediaType{Schema: envelope}
+		}
+
+		operation.Responses[strconv.Itoa(status)] = success
+
+		failure := func(status int) {
+			operation.Responses[strconv.Itoa(status)] = &Response{
+				Description: http.StatusText(status),
+				Content:     map[string]*MediaType{echo.MIMEApplicationJSON: {Schema: &Schema{Ref: "#/components/schemas/Error"}}},
+			}
+		}
+
+		if spec.Body != nil || len(operation.Parameters) > 0 {
+			failure(http.StatusBadRequest)
+		}
+
+		if !spec.Public && strings.HasPrefix(route.Path, "/api/") {
+			failure(http.StatusUnauthorized)
+		}
+
+		if spec.Permission != "" {
+			failure(http.StatusForbidden)
+		}
+
+		if len(pathParameters) > 0 {
+			failure(http.StatusNotFound)
+		}
+
+		operation.Responses["default"] = &Response{
+			Description: "Error",
+			Content:     map[string]*MediaType{echo.MIMEApplicationJSON: {Schema: &Schema{Ref: "#/components/schemas/Error"}}},
+		}
+
+		if document.Paths[path] == nil {
+			document.Paths[path] = map[string]*PathOperation{}
+		}
+
+		document.Paths[path][strings.ToLower(route.Method)] = operation
+		document.operations[Key(route)] = operation
+	}
+
+	return document, nil
+}
+
+var pathParameter = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+// convertPath turns an echo path into an OpenAPI one, listing its
+// parameters.
+func convertPath(path string) (string, []string) {
+	names := []string{}
+
+	for _, match := range pathParameter.FindAllStringSubmatch(path, -1) {
+		names = append(names, match[1])
+	}
+
+	return pathParameter.ReplaceAllString(path, "{$1}"), names
+}
+
+// tag groups operations by the resource their path starts with.
+func tag(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/api/v1"), "/")
+
+	if !strings.HasPrefix(path, "/api/") {
+		return "operations"
+	}
+
+	if len(segments) > 1 && segments[1] != "" {
+		resource, _, _ := strings.Cut(segments[1], ".")
+		return resource
+	}
+
+	return "api"
+}
+
+func operationID(route *echo.Route) string {
+	id := strings.ToLower(route.Method)
+
+	for _, segment := range strings.Split(strings.TrimPrefix(route.Path, "/api/v1"), "/") {
+		segment = strings.Trim(strings.NewReplacer(":", "by_", "-", "_", ".", "_").Replace(segment), "_")
+
+		if segment != "" {
+			id += "_" + segment
+		}
+	}
+
+	return id
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	durationType  = reflect.TypeOf(time.Duration(0))
+	deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+	rawType       = reflect.TypeOf(json.RawMessage{})
+	binaryType    = reflect.TypeOf(Binary{})
+)
+
+// generator keeps the component schemas of the structs it has seen, by
+// name.
+type generator struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func (g *generator) schemaOf(t reflect.Type) *Schema {
+	nullable := false
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+
+	var schema *Schema
+
+	switch t {
+	case timeType:
+		schema = &Schema{Types: []string{"string"}, Format: "date-time"}
+	case deletedAtType:
+		return &Schema{Types: []string{"string", "null"}, Format: "date-time"}
+	case durationType:
+		schema = &Schema{Types: []string{"integer"}, Description: "Nanoseconds."}
+	case rawType:
+		return &Schema{}
+	case binaryType:
+		return &Schema{Types: []string{"string"}, Format: "binary"}
+	}
+
+	if schema == nil {
+		switch t.Kind() {
+		case reflect.Bool:
+			schema = &Schema{Types: []string{"boolean"}}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			schema = &Schema{Types: []string{"integer"}}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			schema = &Schema{Types: []string{"integer"}, Minimum: float(0)}
+		case reflect.Float32, reflect.Float64:
+			schema = &Schema{Types: []string{"number"}}
+		case reflect.String:
+			schema = &Schema{Types: []string{"string"}}
+		case reflect.Slice, reflect.Array:
+			if t.Elem().Kind() == reflect.Uint8 {
+				schema = &Schema{Types: []string{"string"}, Format: "byte"}
+			} else {
+				schema = &Schema{Types: []string{"array"}, Items: g.schemaOf(t.Elem())}
+			}
+		case reflect.Map:
+			schema = &Schema{Types: []string{"object"}, AdditionalProperties: g.schemaOf(t.Elem())}
+		case reflect.Struct:
+			// Related records are left out of responses unless preloaded.
+			return g.component(t)
+		default:
+			return &Schema{}
+		}
+	}
+
+	if nullable {
+		schema.Types = append(schema.Types, "null")
+	}
+
+	return schema
+}
+
+// component registers the schema of a struct once, under its type name, and
+// refers to it.
+func (g *generator) component(t reflect.Type) *Schema {
+	name, ok := g.names[t]
+
+	if !ok {
+		name = t.Name()
+
+		if _, taken := g.schemas[name]; taken || name == "" {
+			pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+			name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+		}
+
+		schema := &Schema{Types: []string{"object"}, Properties: map[string]*Schema{}}
+
+		g.names[t] = name
+		g.schemas[name] = schema
+
+		g.fields(t, schema)
+	}
+
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func (g *generator) fields(t reflect.Type, schema *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		if name == "-" {
+			continue
+		}
+
+		embedded := field.Type
+
+		if embedded.Kind() == reflect.Pointer {
+			embedded = embedded.Elem()
+		}
+
+		if field.Anonymous && name == "" && embedded.Kind() == reflect.Struct {
+			g.fields(embedded, schema)
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		property := g.schemaOf(field.Type)
+
+		if description := field.Tag.Get("doc"); description != "" {
+			property.Description = description
+		}
+
+		if constrain(property, embedded, field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+
+		schema.Properties[name] = property
+	}
+}
+
+// partial copies a body schema without its required fields.
+func (g *generator) partial(schema *Schema) *Schema {
+	if schema.Ref == "" {
+		return schema
+	}
+
+	resolved := *g.schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	resolved.Required = nil
+
+	return &resolved
+}
+
+// constrain adds the rules of a validate tag to the schema of a field of
+// type t, and tells whether the field is required.
+func constrain(schema *Schema, t reflect.Type, tag string) bool {
+	required := false
+	alphanum := false
+	uppercase := false
+
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+
+		switch name {
+		case "required":
+			required = true
+		case "required_without":
+			schema.Description = strings.TrimSpace(schema.Description + " Required when " + strings.ToLower(param) + " is left out.")
+		case "min", "gte":
+			bound(schema, t, param, true)
+		case "max", "lte":
+			bound(schema, t, param, false)
+		case "oneof":
+			for _, value := range strings.Fields(param) {
+				if number, err := strconv.ParseFloat(value, 64); err == nil && t.Kind() != reflect.String {
+					schema.Enum = append(schema.Enum, number)
+				} else {
+					schema.Enum = append(schema.Enum, value)
+				}
+			}
+
+			if schema.Is("null") {
+				schema.Enum = append(schema.Enum, nil)
+			}
+		case "email":
+			schema.Format = "email"
+		case "url":
+			schema.Format = "uri"
+		case "uuid":
+			schema.Format = "uuid"
+		case "e164":
+			schema.Pattern = `^\+[1-9][0-9]{1,14}$`
+		case "alphanum":
+			alphanum = true
+		case "uppercase":
+			uppercase = true
+		}
+	}
+
+	switch {
+	case alphanum && uppercase:
+		schema.Pattern = "^[A-Z0-9]+$"
+	case alphanum:
+		schema.Pattern = "^[A-Za-z0-9]+$"
+	case uppercase:
+		schema.Pattern = "^[^a-z]*$"
+	}
+
+	return required
+}
+
+// bound sets a min or max rule, which limits the length of strings, the
+// items of lists and the value of numbers.
+func bound(schema *Schema, t reflect.Type, param string, lower bool) {
+	value, err := strconv.ParseFloat(param, 64)
+
+	if err != nil {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		if lower {
+			schema.MinLength = length(value)
+		} else {
+			schema.MaxLength = length(value)
+		}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if lower {
+			schema.MinItems = length(value)
+		} else {
+			schema.MaxItems = length(value)
+		}
+	default:
+		if lower {
+			schema.Minimum = float(value)
+		} else {
+			schema.Maximum = float(value)
+		}
+	}
+}
+
+func float(value float64) *float64 {
+	return &value
+}
+
+func length(value float64) *int {
+	n := int(value)
+	return &n
+}
+
+// Violation is a value that does not match its schema. Field is where the
+// value is, a parameter name or a dotted path into a body, and Tag the
+// keyword it breaks, named like the validate tags where one exists.
+type Violation struct {
+	In    string      `json:"in"`
+	Field string      `json:"field"`
+	Tag   string      `json:"tag"`
+	Param string      `json:"param,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+var formats = map[string]*regexp.Regexp{
+	"email": regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`),
+	"uuid":  regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`),
+	"uri":   regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*:\S+$`),
+}
+
+var patterns sync.Map
+
+// pattern compiles the pattern of a schema once.
+func pattern(expression string) *regexp.Regexp {
+	if compiled, ok := patterns.Load(expression); ok {
+		return compiled.(*regexp.Regexp)
+	}
+
+	compiled := regexp.MustCompile(expression)
+	patterns.Store(expression, compiled)
+
+	return compiled
+}
+
+// Check lists where a decoded JSON value breaks a schema of the document.
+// Numbers must be decoded as json.Number. Properties the schema does not
+// name are allowed.
+func (d *Document) Check(in string, field string, schema *Schema, value interface{}) []Violation {
+	schema = d.Resolve(schema)
+
+	if schema == nil {
+		return nil
+	}
+
+	violation := func(tag string, param interface{}) []Violation {
+		v := Violation{In: in, Field: field, Tag: tag, Value: value}
+
+		if param != nil {
+			v.Param = fmt.Sprint(param)
+		}
+
+		if _, ok := value.(map[string]interface{}); ok {
+			v.Value = nil
+		} else if _, ok := value.([]interface{}); ok {
+			v.Value = nil
+		}
+
+		return []Violation{v}
+	}
+
+	jsonType := typeOf(value)
+
+	if len(schema.Types) > 0 && !schema.Is(jsonType) && !(jsonType == "integer" && schema.Is("number")) {
+		return violation("type", strings.Join(schema.Types, " "))
+	}
+
+	if len(schema.Enum) > 0 && !contains(schema.Enum, value) {
+		return violation("oneof", enum(schema.Enum))
+	}
+
+	violations := []Violation{}
+
+	switch value := value.(type) {
+	case json.Number:
+		number, _ := value.Float64()
+
+		if schema.Minimum != nil && number < *schema.Minimum {
+			violations = append(violations, violation("min", *schema.Minimum)...)
+		}
+
+		if schema.Maximum != nil && number > *schema.Maximum {
+			violations = append(violations, violation("max", *schema.Maximum)...)
+		}
+	case string:
+		length := len([]rune(value))
+
+		if schema.MinLength != nil && length < *schema.MinLength {
+			violations = append(violations, violation("min", *schema.MinLength)...)
+		}
+
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			violations = append(violations, violation("max", *schema.MaxLength)...)
+		}
+
+		if schema.Pattern != "" && !pattern(schema.Pattern).MatchString(value) {
+			violations = append(violations, violation("pattern", schema.Pattern)...)
+		}
+
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				violations = append(violations, violation("format", schema.Format)...)
+			}
+		} else if format, ok := formats[schema.Format]; ok && !format.MatchString(value) {
+			violations = append(violations, violation("format", schema.Format)...)
+		}
+	case []interface{}:
+		if schema.MinItems != nil && len(value) < *schema.MinItems {
+			violations = append(violations, violation("min", *schema.MinItems)...)
+		}
+
+		if schema.MaxItems != nil && len(value) > *schema.MaxItems {
+			violations = append(violations, violation("max", *schema.MaxItems)...)
+		}
+
+		if schema.Items != nil {
+			for i, item := range value {
+				violations = append(violations, d.Check(in, join(field, strconv.Itoa(i)), schema.Items, item)...)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range schema.Required {
+			if _, ok := value[name]; !ok {
+				violations = append(violations, Violation{In: in, Field: join(field, name), Tag: "required"})
+			}
+		}
+
+		names := make([]string, 0, len(value))
+
+		for name := range value {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			if property, ok := schema.Properties[name]; ok {
+				violations = append(violations, d.Check(in, join(field, name), property, value[name])...)
+			} else if schema.AdditionalProperties != nil {
+				violations = append(violations, d.Check(in, join(field, name), schema.AdditionalProperties, value[name])...)
+			}
+		}
+	}
+
+	return violations
+}
+
+// typeOf is the JSON Schema type of a decoded JSON value.
+func typeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return "integer"
+		}
+
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+
+	return ""
+}
+
+func contains(values []interface{}, value interface{}) bool {
+	for _, allowed := range values {
+		if number, ok := value.(json.Number); ok {
+			if allowed, ok := allowed.(float64); ok {
+				if parsed, err := number.Float64(); err == nil && parsed == allowed {
+					return true
+				}
+			}
+
+			continue
+		}
+
+		if allowed == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func enum(values []interface{}) string {
+	names := make([]string, len(values))
+
+	for i, value := range values {
+		if value == nil {
+			names[i] = "null"
+		} else {
+			names[i] = fmt.Sprint(value)
+		}
+	}
+
+	return strings.Join(names, " ")
+}
+
+func join(field string, name string) string {
+	if field == "" {
+		return name
+	}
+
+	return field + "." + name
+}
@@ -6,6 +6,7 @@ require (
 	github.com/go-playground/validator v9.31.0+incompatible
 	github.com/go-redis/cache/v8 v8.4.4
 	github.com/labstack/echo/v4 v4.10.2
+	gorm.io/gorm v1.25.1
 )
 
 require (
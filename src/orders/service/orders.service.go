@@ -1,21 +1,40 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strconv"
+	"sync"
 	"time"
 	"sahamrakyat_test/database"
 	"sahamrakyat_test/helpers"
+	"sahamrakyat_test/matching/engine"
+	portfoliosService "sahamrakyat_test/portfolios/service"
+	stocksService "sahamrakyat_test/stocks/service"
+	walletsService "sahamrakyat_test/wallets/service"
 
 	"github.com/go-playground/validator"
 	"github.com/go-redis/cache/v8"
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
+// The books are restored from the database once, restored tells whether it
+// succeeded yet.
+var (
+	restoreBooks sync.Mutex
+	restored     bool
+)
+
+const booksUnavailable = "Order matching is unavailable, try again later."
+
+var errNoOwner = errors.New("order has no owner")
+
 func Create(c echo.Context) (*database.Orders, *echo.HTTPError) {
-	logger := helpers.InitLogger()
+	logger := helpers.Deps(c).Logger
 
 	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
 
@@ -57,64 +76,107 @@ func Create(c echo.Context) (*database.Orders, *echo.HTTPError) {
 		})
 	}
 
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
+	db := helpers.Deps(c).DB
 
-	db.Create(order)
+	stock, err := stocksService.FindTradable(c.Request().Context(), db, *order.StocksID)
 
-	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
-
-	return order, nil
-}
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Stock not found or not tradable.")
+	}
 
-func GetAll(c echo.Context) (*[]database.Orders, *echo.HTTPError) {
-	logger := helpers.InitLogger()
+	if stock.LotSize == 0 || order.Quantity%stock.LotSize != 0 {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("Quantity must be a multiple of the lot size (%d).", stock.LotSize))
+	}
 
-	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+	if !order.ExpiredAt.IsZero() && !order.ExpiredAt.After(time.Now()) {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Expiry must be in the future.")
+	}
 
-	var take int
-	var skip int
+	if order.Side == "" {
+		order.Side = database.OrderSideBuy
+	}
 
-	takeQuery := c.QueryParam("take")
+	// Orders of users without orders:manage always belong to themselves.
+	if !helpers.Can(c, "orders:manage") {
+		user := helpers.CurrentUser(c)
 
-	if takeQuery != "" {
-		if val, err := strconv.ParseInt(takeQuery, 10, 32); err != nil {
-			return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse take.")
-		} else {
-			take = int(val)
+		if user == nil || user.HistoriesID == nil {
+			return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "User has no history to attach orders to.")
 		}
-	} else {
-		take = -1
+
+		order.HistoriesID = user.HistoriesID
+	}
+
+	// Fills are only ever recorded by the service, never taken from the request.
+	order.FilledQuantity = 0
+	order.Status = database.OrderStatusOpen
+
+	books, err := matchingEngine(db)
+
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, booksUnavailable)
 	}
 
-	skipQuery := c.QueryParam("skip")
+	err = walletsService.Transaction(c.Request().Context(), db, func(tx *gorm.DB) error {
+		// The transaction may be retried, so never reuse an id from a rolled back attempt.
+		order.ID = 0
 
-	if skipQuery != "" {
-		if val, err := strconv.ParseInt(skipQuery, 10, 32); err != nil {
-			return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse skip.")
-		} else {
-			skip = int(val)
+		if result := tx.Create(order); result.Error != nil {
+			return result.Error
 		}
-	} else {
-		skip = -1
+
+		return reserve(tx, order)
+	})
+
+	if err != nil {
+		status, message := createFailure(c, err)
+		return nil, echo.NewHTTPError(status, message)
+	}
+
+	if err := submit(c, db, books, order, stock); err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to match order, it was cancelled.")
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return order, nil
+}
+
+func GetAll(c echo.Context) (*[]database.Orders, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	filter, httpErr := Filter(c)
+
+	if httpErr != nil {
+		return nil, httpErr
 	}
 
 	orders := &[]database.Orders{}
 
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
+	db := helpers.Deps(c).DB
 
-	db.Limit(take).Offset(skip).Find(orders)
+	db.Scopes(filter).Find(orders)
 
-	cacheClient := helpers.InitRedisCache()
+	cacheKey, cacheable := helpers.ListCacheKey(c, "orders", "orders:manage")
 
-	if err := cacheClient.Get(c.Request().Context(), "orders", orders); err == nil {
-		return orders, nil
-	} else if err != nil {
-		if err := cacheClient.Set(&cache.Item{
-			Ctx:   c.Request().Context(),
-			Key:   "orders",
-			Value: orders,
-		}); err != nil {
-			fmt.Printf("Failed to cache orders: %v", err)
+	// Only whole lists are cached, filtered and paged ones are always read.
+	if cacheable && c.QueryString() == "" {
+		cacheClient := helpers.Deps(c).Cache
+
+		if err := cacheClient.Get(c.Request().Context(), cacheKey, orders); err == nil {
+			return orders, nil
+		} else if err != nil {
+			if err := cacheClient.Set(&cache.Item{
+				Ctx:   c.Request().Context(),
+				Key:   cacheKey,
+				Value: orders,
+			}); err != nil {
+				fmt.Printf("Failed to cache orders: %v", err)
+			}
 		}
 	}
 
@@ -124,7 +186,7 @@ func GetAll(c echo.Context) (*[]database.Orders, *echo.HTTPError) {
 }
 
 func Get(c echo.Context) (*database.Orders, *echo.HTTPError) {
-	logger := helpers.InitLogger()
+	logger := helpers.Deps(c).Logger
 
 	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
 
@@ -142,24 +204,20 @@ func Get(c echo.Context) (*database.Orders, *echo.HTTPError) {
 		ID: uint(id),
 	}
 
-	if err := c.Bind(order); err != nil {
-		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind order.")
-	}
-
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
+	db := helpers.Deps(c).DB
 
-	if result := db.Find(order); result.Error != nil || result.RowsAffected < 0 {
+	if result := db.Scopes(Owned(c)).Find(order); result.Error != nil || result.RowsAffected < 1 {
 		return nil, echo.NewHTTPError(http.StatusNotFound, "Order not found.")
 	}
 
-	cacheClient := helpers.InitRedisCache()
+	cacheClient := helpers.Deps(c).Cache
 
-	if err := cacheClient.Get(c.Request().Context(), fmt.Sprintf("order:%d", order.ID), order); err == nil {
+	if err := cacheClient.Get(c.Request().Context(), fmt.Sprintf("order:%d", id), order); err == nil {
 		return order, nil
 	} else if err != nil {
 		if err := cacheClient.Set(&cache.Item{
 			Ctx:   c.Request().Context(),
-			Key:   fmt.Sprintf("order:%d", order.ID),
+			Key:   fmt.Sprintf("order:%d", id),
 			Value: order,
 		}); err != nil {
 			fmt.Printf("Failed to cache order: %v", err)
@@ -171,8 +229,9 @@ func Get(c echo.Context) (*database.Orders, *echo.HTTPError) {
 	return order, nil
 }
 
+// Update renames an order or moves its expiry, like an item of BulkUpdate.
 func Update(c echo.Context) (*database.Orders, *echo.HTTPError) {
-	logger := helpers.InitLogger()
+	logger := helpers.Deps(c).Logger
 
 	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
 
@@ -186,28 +245,62 @@ func Update(c echo.Context) (*database.Orders, *echo.HTTPError) {
 		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse order id.")
 	}
 
-	order := &database.Orders{
-		ID: uint(id),
-	}
+	patch := &OrderPatch{}
 
-	if err := c.Bind(order); err != nil {
-		fmt.Println(err)
+	if err := c.Bind(patch); err != nil {
+		logger.Error(err)
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind order.")
 	}
 
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
+	// The order is the one of the path, whatever id the body names.
+	patch.ID = uint(id)
+
+	if err := validator.New().Struct(patch); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"message": "Failed to validate order.",
+			"error":   helpers.ValidationFields(err),
+		})
+	}
+
+	db := helpers.Deps(c).DB
+
+	books, err := matchingEngine(db)
+
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, booksUnavailable)
+	}
+
+	order := &database.Orders{}
 
-	if result := db.Find(order); result.Error != nil || result.RowsAffected < 0 {
+	if result := db.Scopes(Owned(c)).Limit(1).Find(order, id); result.Error != nil || result.RowsAffected < 1 {
 		return nil, echo.NewHTTPError(http.StatusNotFound, "Order not found.")
 	}
 
-	cacheClient := helpers.InitRedisCache()
+	change, httpErr := patch.changes(order)
 
-	if err := cacheClient.Delete(c.Request().Context(), fmt.Sprintf("order:%d", order.ID)); err == nil {
-		cacheClient.Delete(c.Request().Context(), fmt.Sprintf("order:%d", id))
+	if httpErr != nil {
+		return nil, httpErr
 	}
 
-	db.Save(order)
+	if len(change) > 0 {
+		if err := db.Model(&database.Orders{}).Where("id = ?", order.ID).Updates(change).Error; err != nil {
+			logger.Error(err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to update order.")
+		}
+	}
+
+	helpers.Deps(c).Cache.Delete(c.Request().Context(), fmt.Sprintf("order:%d", id))
+	invalidate(c.Request().Context(), db, order.HistoriesID)
+
+	if patch.ExpiredAt != nil && order.StocksID != nil && books.Cancel(*order.StocksID, order.ID) {
+		rest(db, books, *order)
+	}
+
+	if err := db.First(order, id).Error; err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get order.")
+	}
 
 	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
 
@@ -215,7 +308,7 @@ func Update(c echo.Context) (*database.Orders, *echo.HTTPError) {
 }
 
 func Delete(c echo.Context) (*database.Orders, *echo.HTTPError) {
-	logger := helpers.InitLogger()
+	logger := helpers.Deps(c).Logger
 
 	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
 
@@ -233,26 +326,980 @@ func Delete(c echo.Context) (*database.Orders, *echo.HTTPError) {
 		ID: uint(id),
 	}
 
-	if err := c.Bind(order); err != nil {
-		fmt.Println(err)
+	if err := c.Bind(&database.Orders{}); err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind order.")
 	}
 
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
+	db := helpers.Deps(c).DB
 
-	if result := db.Find(order); result.Error != nil || result.RowsAffected < 0 {
+	if result := db.Scopes(Owned(c)).Find(order); result.Error != nil || result.RowsAffected < 1 {
 		return nil, echo.NewHTTPError(http.StatusNotFound, "Order not found.")
 	}
 
-	cacheClient := helpers.InitRedisCache()
+	cacheClient := helpers.Deps(c).Cache
+
+	cacheClient.Delete(c.Request().Context(), fmt.Sprintf("order:%d", id))
+
+	books, err := matchingEngine(db)
+
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, booksUnavailable)
+	}
+
+	cancelled := order.StocksID != nil && books.Cancel(*order.StocksID, order.ID)
+
+	err = walletsService.Transaction(c.Request().Context(), db, func(tx *gorm.DB) error {
+		if err := closeOrder(tx, order, database.OrderStatusCancelled); err != nil {
+			return err
+		}
+
+		return tx.Delete(order).Error
+	})
+
+	if err != nil {
+		if cancelled {
+			rest(db, books, *order)
+		}
 
-	if err := cacheClient.Delete(c.Request().Context(), fmt.Sprintf("order:%d", order.ID)); err == nil {
-		cacheClient.Delete(c.Request().Context(), fmt.Sprintf("order:%d", id))
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete order.")
 	}
 
-	db.Delete(order)
+	invalidate(c.Request().Context(), db, order.HistoriesID)
 
 	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
 
 	return order, nil
 }
+
+// reserve holds the funds a new buy order may spend, and checks the shares
+// a new sell order offers are held. Orders without an owner are never
+// settled, sell orders of theirs are left unchecked.
+func reserve(tx *gorm.DB, order *database.Orders) error {
+	owner, err := walletsService.Owner(tx, order.HistoriesID)
+
+	if err != nil {
+		return err
+	}
+
+	if order.Side != database.OrderSideBuy {
+		if owner == nil {
+			return nil
+		}
+
+		return portfoliosService.Cover(tx, *order.HistoriesID, *order.StocksID)
+	}
+
+	if owner == nil {
+		return errNoOwner
+	}
+
+	return walletsService.Reserve(tx, owner.ID, order.ID, int64(order.Price)*int64(order.Quantity))
+}
+
+// createFailure is the status and message of an order that could not be
+// created.
+func createFailure(c echo.Context, err error) (int, string) {
+	if errors.Is(err, walletsService.ErrInsufficientFunds) {
+		return http.StatusUnprocessableEntity, "Insufficient funds."
+	} else if errors.Is(err, portfoliosService.ErrInsufficientShares) {
+		return http.StatusUnprocessableEntity, "Insufficient shares."
+	} else if errors.Is(err, errNoOwner) {
+		return http.StatusUnprocessableEntity, "Buy orders must belong to a user."
+	}
+
+	helpers.Deps(c).Logger.Error(err)
+
+	return http.StatusInternalServerError, "Failed to create order."
+}
+
+// submit puts a newly created order on its book and records the trades it
+// produced, reloading the order with its fills. The book only changes once
+// the trades are committed. An order whose trades could not be recorded is
+// cancelled, so it holds no funds while it is off the book, and the error is
+// returned.
+func submit(c echo.Context, db *gorm.DB, books *engine.Engine, order *database.Orders, stock *database.Stocks) error {
+	ctx := c.Request().Context()
+	logger := helpers.Deps(c).Logger
+
+	trades, err := books.SubmitWith(engine.Order{
+		ID:        order.ID,
+		StocksID:  stock.ID,
+		Side:      order.Side,
+		Price:     order.Price,
+		Quantity:  order.Quantity,
+		ExpiresAt: order.ExpiredAt,
+	}, func(trades []engine.Trade) error {
+		if len(trades) == 0 {
+			return nil
+		}
+
+		return applyTrades(ctx, db, stock, trades)
+	})
+
+	if err != nil {
+		logger.Errorf("Failed to record trades of order %d: %v", order.ID, err)
+
+		cancelErr := walletsService.Transaction(ctx, db, func(tx *gorm.DB) error {
+			return closeOrder(tx, order, database.OrderStatusCancelled)
+		})
+
+		if cancelErr != nil {
+			logger.Errorf("Failed to cancel order %d: %v", order.ID, cancelErr)
+		}
+
+		helpers.Deps(c).Cache.Delete(ctx, fmt.Sprintf("order:%d", order.ID))
+		invalidate(ctx, db, order.HistoriesID)
+
+		return err
+	}
+
+	if len(trades) > 0 {
+		db.First(order, order.ID)
+	}
+
+	invalidate(ctx, db, order.HistoriesID)
+
+	return nil
+}
+
+// invalidate drops what is cached about the orders of a history: the
+// portfolio of its owner, and the order lists and history lists, which
+// preload orders, they show up in.
+func invalidate(ctx context.Context, db *gorm.DB, historiesID *uint) {
+	portfoliosService.Invalidate(ctx, db, historiesID)
+
+	owners := []uint{}
+
+	if historiesID != nil {
+		db.Model(&database.Users{}).Where("histories_id = ?", *historiesID).Pluck("id", &owners)
+	}
+
+	cacheClient := helpers.DepsFrom(ctx).Cache
+	cacheClient.InvalidateLists(ctx, "orders", owners...)
+	cacheClient.InvalidateLists(ctx, "histories", owners...)
+}
+
+// Owned limits order queries to the orders of the current user, unless they
+// hold orders:manage.
+func Owned(c echo.Context) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if helpers.Can(c, "orders:manage") {
+			return db
+		}
+
+		if user := helpers.CurrentUser(c); user != nil && user.HistoriesID != nil {
+			return db.Where("orders.histories_id = ?", *user.HistoriesID)
+		}
+
+		return db.Where("1 = 0")
+	}
+}
+
+// matchingEngine returns the shared matching engine, rebuilding its books
+// from the open orders in the database the first time it is used. A failed
+// rebuild is retried on the next call, the books are never used half built.
+func matchingEngine(db *gorm.DB) (*engine.Engine, error) {
+	restoreBooks.Lock()
+	defer restoreBooks.Unlock()
+
+	if restored {
+		return engine.Default(), nil
+	}
+
+	orders := []database.Orders{}
+
+	result := db.Where("status IN ? AND stocks_id IS NOT NULL", []string{database.OrderStatusOpen, database.OrderStatusPartial}).Order("created_at, id").Find(&orders)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to restore the order books: %w", result.Error)
+	}
+
+	for _, order := range orders {
+		engine.Default().Restore(engine.Order{
+			ID:        order.ID,
+			StocksID:  *order.StocksID,
+			Side:      order.Side,
+			Price:     order.Price,
+			Quantity:  order.Quantity - order.FilledQuantity,
+			ExpiresAt: order.ExpiredAt,
+		})
+	}
+
+	restored = true
+
+	return engine.Default(), nil
+}
+
+// rest puts an order taken off its book back on it, after the write that
+// was to close or change it failed. It is reloaded first, fills may have
+// been recorded since it was loaded.
+func rest(db *gorm.DB, books *engine.Engine, order database.Orders) {
+	if err := db.First(&order, order.ID).Error; err != nil || order.StocksID == nil {
+		return
+	}
+
+	books.Restore(engine.Order{
+		ID:        order.ID,
+		StocksID:  *order.StocksID,
+		Side:      order.Side,
+		Price:     order.Price,
+		Quantity:  order.Quantity - order.FilledQuantity,
+		ExpiresAt: order.ExpiredAt,
+	})
+}
+
+// Key of the advisory lock held by the process serving the order books.
+const booksLockID = 7256823002
+
+// ClaimBooks makes this process the only one serving the order books. The
+// books live in memory, so a second instance would match against books of
+// its own. The claim is an advisory lock on a connection of its own, which
+// Postgres drops with the connection if the process dies; release gives it
+// back on shutdown.
+func ClaimBooks(ctx context.Context, db *gorm.DB) (release func(), err error) {
+	sqlDB, err := db.DB()
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := false
+
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", booksLockID).Scan(&claimed); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if !claimed {
+		conn.Close()
+		return nil, errors.New("another instance is serving the order books, only one may run at a time")
+	}
+
+	return func() {
+		conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", booksLockID)
+		conn.Close()
+	}, nil
+}
+
+// applyTrades stores the trades produced by the matching engine, records the
+// fills on both orders of every trade, settles them against the wallets of
+// their owners and writes a history entry for each.
+func applyTrades(ctx context.Context, db *gorm.DB, stock *database.Stocks, trades []engine.Trade) error {
+	err := walletsService.Transaction(ctx, db, func(tx *gorm.DB) error {
+		for _, trade := range trades {
+			for _, id := range []uint{trade.BuyOrderID, trade.SellOrderID} {
+				result := tx.Model(&database.Orders{}).Where("id = ?", id).Updates(map[string]interface{}{
+					"filled_quantity": gorm.Expr("filled_quantity + ?", trade.Quantity),
+					"status":          gorm.Expr("CASE WHEN filled_quantity + ? >= quantity THEN ? ELSE ? END", trade.Quantity, database.OrderStatusFilled, database.OrderStatusPartial),
+				})
+
+				if result.Error != nil {
+					return result.Error
+				}
+			}
+
+			record := &database.Trades{
+				BuyOrderID:  trade.BuyOrderID,
+				SellOrderID: trade.SellOrderID,
+				StocksID:    trade.StocksID,
+				Price:       trade.Price,
+				Quantity:    trade.Quantity,
+				ExecutedAt:  trade.ExecutedAt,
+			}
+
+			if result := tx.Create(record); result.Error != nil {
+				return result.Error
+			}
+
+			if err := settle(tx, trade); err != nil {
+				return err
+			}
+
+			history := &database.Histories{
+				Descriptions: fmt.Sprintf("Matched %d %s @ %d between buy order #%d and sell order #%d.", trade.Quantity, stock.Ticker, trade.Price, trade.BuyOrderID, trade.SellOrderID),
+			}
+
+			if result := tx.Create(history); result.Error != nil {
+				return result.Error
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	counterparties := []database.Orders{}
+	cacheClient := helpers.DepsFrom(ctx).Cache
+
+	for _, trade := range trades {
+		cacheClient.Delete(ctx, fmt.Sprintf("order:%d", trade.BuyOrderID))
+		cacheClient.Delete(ctx, fmt.Sprintf("order:%d", trade.SellOrderID))
+	}
+
+	db.Select("id", "histories_id").Where("id IN ?", orderIDs(trades)).Find(&counterparties)
+
+	for _, order := range counterparties {
+		invalidate(ctx, db, order.HistoriesID)
+	}
+
+	return nil
+}
+
+func orderIDs(trades []engine.Trade) []uint {
+	ids := []uint{}
+
+	for _, trade := range trades {
+		ids = append(ids, trade.BuyOrderID, trade.SellOrderID)
+	}
+
+	return ids
+}
+
+// settle moves cash between the owners of both orders of a trade. Buyers
+// pay out of the funds held when their order was placed, orders without an
+// owner are not settled.
+func settle(tx *gorm.DB, trade engine.Trade) error {
+	buyOrder := &database.Orders{}
+	sellOrder := &database.Orders{}
+
+	if result := tx.Unscoped().First(buyOrder, trade.BuyOrderID); result.Error != nil {
+		return result.Error
+	}
+
+	if result := tx.Unscoped().First(sellOrder, trade.SellOrderID); result.Error != nil {
+		return result.Error
+	}
+
+	quantity := int64(trade.Quantity)
+
+	if buyer, err := walletsService.Owner(tx, buyOrder.HistoriesID); err != nil {
+		return err
+	} else if buyer != nil {
+		cost := int64(trade.Price) * quantity
+		improvement := (int64(buyOrder.Price) - int64(trade.Price)) * quantity
+
+		if err := walletsService.SettleBuy(tx, buyer.ID, buyOrder.ID, cost, improvement); err != nil {
+			return err
+		}
+	}
+
+	if seller, err := walletsService.Owner(tx, sellOrder.HistoriesID); err != nil {
+		return err
+	} else if seller != nil {
+		if err := walletsService.SettleSell(tx, seller.ID, sellOrder.ID, int64(trade.Price)*quantity); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// closeOrder moves an order that can still be filled to a final status and
+// releases whatever funds are still held for it.
+func closeOrder(tx *gorm.DB, order *database.Orders, status string) error {
+	if order.Status != database.OrderStatusOpen && order.Status != database.OrderStatusPartial {
+		return nil
+	}
+
+	if result := tx.Model(order).Update("status", status); result.Error != nil {
+		return result.Error
+	}
+
+	owner, err := walletsService.Owner(tx, order.HistoriesID)
+
+	if err != nil || owner == nil {
+		return err
+	}
+
+	return walletsService.ReleaseOrder(tx, owner.ID, order.ID)
+}
+
+// Expire closes every open order whose expiry has passed, taking it off the
+// books and releasing its held funds. It returns how many were expired.
+func Expire(ctx context.Context, db *gorm.DB) (int, error) {
+	orders := []database.Orders{}
+
+	result := db.WithContext(ctx).Where("status IN ? AND expired_at > ? AND expired_at <= ?", []string{database.OrderStatusOpen, database.OrderStatusPartial}, time.Time{}, time.Now()).Find(&orders)
+
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	books, err := matchingEngine(db)
+
+	if err != nil {
+		return 0, err
+	}
+
+	cacheClient := helpers.DepsFrom(ctx).Cache
+	expired := 0
+
+	for i := range orders {
+		order := &orders[i]
+
+		cancelled := order.StocksID != nil && books.Cancel(*order.StocksID, order.ID)
+
+		err := walletsService.Transaction(ctx, db, func(tx *gorm.DB) error {
+			return closeOrder(tx, order, database.OrderStatusExpired)
+		})
+
+		if err != nil {
+			if cancelled {
+				rest(db, books, *order)
+			}
+
+			return expired, err
+		}
+
+		cacheClient.Delete(ctx, fmt.Sprintf("order:%d", order.ID))
+		invalidate(ctx, db, order.HistoriesID)
+
+		expired++
+	}
+
+	return expired, nil
+}
+
+// RunExpiryWorker runs Expire every ORDERS_EXPIRY_INTERVAL, with the
+// dependencies carried by ctx, until ctx is done. A run in progress is
+// finished before it returns.
+func RunExpiryWorker(ctx context.Context) {
+	deps := helpers.DepsFrom(ctx)
+	db := deps.DB
+	logger := deps.Logger
+
+	ticker := time.NewTicker(deps.Config.Orders.ExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			start := time.Now()
+			runCtx, span := helpers.Tracer().Start(helpers.WithDependencies(context.Background(), deps), "worker orders_expiry")
+			count, err := Expire(runCtx, db)
+			span.End()
+
+			helpers.ObserveWorker("orders_expiry", start, err)
+			helpers.WorkerItems.WithLabelValues("orders_expiry").Add(float64(count))
+
+			if err != nil {
+				logger.Errorf("Failed to expire orders: %v", err)
+			} else if count > 0 {
+				logger.Infof("Expired %d orders", count)
+			}
+		}
+	}
+}
+
+// OrderPatch is the body of Update and an item of BulkUpdate, fields left
+// out are kept.
+type OrderPatch struct {
+	ID        uint       `json:"id" validate:"required"`
+	Name      *string    `json:"name" validate:"omitempty,min=1,max=255"`
+	ExpiredAt *time.Time `json:"expired_at"`
+}
+
+// changes are the columns the patch changes on order. Only open orders can
+// move their expiry, and only into the future.
+func (p *OrderPatch) changes(order *database.Orders) (map[string]interface{}, *echo.HTTPError) {
+	change := map[string]interface{}{}
+
+	if p.Name != nil {
+		change["name"] = *p.Name
+	}
+
+	if p.ExpiredAt != nil {
+		if order.Status != database.OrderStatusOpen && order.Status != database.OrderStatusPartial {
+			return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Only open orders can change their expiry.")
+		}
+
+		if !p.ExpiredAt.IsZero() && !p.ExpiredAt.After(time.Now()) {
+			return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Expiry must be in the future.")
+		}
+
+		change["expired_at"] = *p.ExpiredAt
+	}
+
+	return change, nil
+}
+
+// BulkCreate creates every order of a bulk request, each checked like
+// Create checks one. Buy orders reserve their funds in the same transaction
+// their rows are inserted in, and are only submitted to the matching engine
+// once it is committed.
+func BulkCreate(c echo.Context) (*helpers.BulkReport, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	request, httpErr := helpers.BindBulk(c, false)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	report, httpErr := CreateMany(c, request.Items, request.Mode, false)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return report, nil
+}
+
+// CreateMany creates orders from JSON items, reporting on each, see
+// helpers.BulkReport for the modes. With dryRun items are only checked and
+// the valid ones reported with 200, nothing is written.
+func CreateMany(c echo.Context, items []json.RawMessage, mode string, dryRun bool) (*helpers.BulkReport, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+	ctx := c.Request().Context()
+	db := helpers.Deps(c).DB
+	report := helpers.NewBulkReport(mode, len(items))
+	validate := validator.New()
+	user := helpers.CurrentUser(c)
+	manage := helpers.Can(c, "orders:manage")
+
+	orders := make([]*database.Orders, len(items))
+	stocks := map[uint]*database.Stocks{}
+	valid := []int{}
+
+	for i, item := range items {
+		order := &database.Orders{}
+
+		if err := json.Unmarshal(item, order); err != nil {
+			report.Fail(i, http.StatusBadRequest, "Failed to bind order.")
+			continue
+		}
+
+		if err := validate.Struct(order); err != nil {
+			report.Invalid(i, err)
+			continue
+		}
+
+		stock, ok := stocks[*order.StocksID]
+
+		if !ok {
+			found, err := stocksService.FindTradable(ctx, db, *order.StocksID)
+
+			if err != nil {
+				logger.Error(err)
+			}
+
+			stock = found
+			stocks[*order.StocksID] = found
+		}
+
+		if stock == nil {
+			report.Fail(i, http.StatusUnprocessableEntity, "Stock not found or not tradable.")
+			continue
+		}
+
+		if stock.LotSize == 0 || order.Quantity%stock.LotSize != 0 {
+			report.Fail(i, http.StatusUnprocessableEntity, fmt.Sprintf("Quantity must be a multiple of the lot size (%d).", stock.LotSize))
+			continue
+		}
+
+		if !order.ExpiredAt.IsZero() && !order.ExpiredAt.After(time.Now()) {
+			report.Fail(i, http.StatusUnprocessableEntity, "Expiry must be in the future.")
+			continue
+		}
+
+		if order.Side == "" {
+			order.Side = database.OrderSideBuy
+		}
+
+		if !manage {
+			if user == nil || user.HistoriesID == nil {
+				report.Fail(i, http.StatusUnprocessableEntity, "User has no history to attach orders to.")
+				continue
+			}
+
+			order.HistoriesID = user.HistoriesID
+		}
+
+		order.FilledQuantity = 0
+		order.Status = database.OrderStatusOpen
+
+		orders[i] = order
+		valid = append(valid, i)
+	}
+
+	if dryRun {
+		for _, index := range report.Write(valid, func([]int) (int, error) { return -1, nil }, nil) {
+			report.Succeed(index, 0, http.StatusOK)
+		}
+
+		return report, nil
+	}
+
+	books, err := matchingEngine(db)
+
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, booksUnavailable)
+	}
+
+	written := report.Write(valid, func(indexes []int) (int, error) {
+		batch := make([]*database.Orders, len(indexes))
+
+		for position, index := range indexes {
+			batch[position] = orders[index]
+		}
+
+		failed := -1
+
+		err := walletsService.Transaction(ctx, db, func(tx *gorm.DB) error {
+			failed = -1
+
+			for _, order := range batch {
+				order.ID = 0
+			}
+
+			if result := tx.CreateInBatches(batch, helpers.BulkChunk); result.Error != nil {
+				return result.Error
+			}
+
+			for position, order := range batch {
+				if err := reserve(tx, order); err != nil {
+					failed = position
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		return failed, err
+	}, func(err error) (int, string) {
+		return createFailure(c, err)
+	})
+
+	for _, index := range written {
+		order := orders[index]
+
+		if err := submit(c, db, books, order, stocks[*order.StocksID]); err != nil {
+			report.Fail(index, http.StatusInternalServerError, "Failed to match order, it was cancelled.")
+			continue
+		}
+
+		report.Succeed(index, order.ID, http.StatusCreated)
+	}
+
+	return report, nil
+}
+
+// BulkUpdate renames orders or moves their expiry. An order that gets a new
+// expiry goes back on its book behind the orders already resting at its
+// price.
+func BulkUpdate(c echo.Context) (*helpers.BulkReport, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	request, httpErr := helpers.BindBulk(c, false)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	ctx := c.Request().Context()
+	db := helpers.Deps(c).DB
+	report := helpers.NewBulkReport(request.Mode, request.Len())
+
+	books, err := matchingEngine(db)
+
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, booksUnavailable)
+	}
+	validate := validator.New()
+
+	patches := make([]*OrderPatch, request.Len())
+	ids := []uint{}
+
+	for i, item := range request.Items {
+		patch := &OrderPatch{}
+
+		if err := json.Unmarshal(item, patch); err != nil {
+			report.Fail(i, http.StatusBadRequest, "Failed to bind order.")
+			continue
+		}
+
+		if err := validate.Struct(patch); err != nil {
+			report.Invalid(i, err)
+			continue
+		}
+
+		patches[i] = patch
+		ids = append(ids, patch.ID)
+	}
+
+	found := []database.Orders{}
+
+	if result := db.Scopes(Owned(c)).Where("id IN ?", ids).Find(&found); result.Error != nil {
+		logger.Error(result.Error)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get orders.")
+	}
+
+	byID := map[uint]database.Orders{}
+
+	for _, order := range found {
+		byID[order.ID] = order
+	}
+
+	changes := make([]map[string]interface{}, request.Len())
+	valid := []int{}
+
+	for i, patch := range patches {
+		if patch == nil {
+			continue
+		}
+
+		order, ok := byID[patch.ID]
+
+		if !ok {
+			report.Fail(i, http.StatusNotFound, "Order not found.")
+			continue
+		}
+
+		change, httpErr := patch.changes(&order)
+
+		if httpErr != nil {
+			report.Fail(i, httpErr.Code, fmt.Sprint(httpErr.Message))
+			continue
+		}
+
+		changes[i] = change
+		valid = append(valid, i)
+	}
+
+	written := report.Write(valid, func(indexes []int) (int, error) {
+		failed := -1
+
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			failed = -1
+
+			for position, index := range indexes {
+				if len(changes[index]) == 0 {
+					continue
+				}
+
+				if result := tx.Model(&database.Orders{}).Where("id = ?", patches[index].ID).Updates(changes[index]); result.Error != nil {
+					failed = position
+					return result.Error
+				}
+			}
+
+			return nil
+		})
+
+		return failed, err
+	}, func(err error) (int, string) {
+		logger.Error(err)
+		return http.StatusInternalServerError, "Failed to update order."
+	})
+
+	cacheClient := helpers.Deps(c).Cache
+
+	for _, index := range written {
+		patch := patches[index]
+		order := byID[patch.ID]
+
+		cacheClient.Delete(ctx, fmt.Sprintf("order:%d", order.ID))
+		invalidate(ctx, db, order.HistoriesID)
+
+		if patch.ExpiredAt != nil && order.StocksID != nil && books.Cancel(*order.StocksID, order.ID) {
+			rest(db, books, order)
+		}
+
+		report.Succeed(index, order.ID, http.StatusOK)
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return report, nil
+}
+
+// BulkDelete cancels and deletes orders by id, releasing the funds still
+// held for them. Orders are taken off their books first so nothing fills
+// them while they are deleted, those that then fail to delete are put back.
+func BulkDelete(c echo.Context) (*helpers.BulkReport, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	request, httpErr := helpers.BindBulk(c, true)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	ctx := c.Request().Context()
+	db := helpers.Deps(c).DB
+	report := helpers.NewBulkReport(request.Mode, request.Len())
+
+	books, err := matchingEngine(db)
+
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, booksUnavailable)
+	}
+
+	found := []database.Orders{}
+
+	if result := db.Scopes(Owned(c)).Where("id IN ?", request.IDs).Find(&found); result.Error != nil {
+		logger.Error(result.Error)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get orders.")
+	}
+
+	byID := map[uint]database.Orders{}
+
+	for _, order := range found {
+		byID[order.ID] = order
+	}
+
+	valid := []int{}
+
+	for i, id := range request.IDs {
+		if _, ok := byID[id]; !ok {
+			report.Fail(i, http.StatusNotFound, "Order not found.")
+			continue
+		}
+
+		valid = append(valid, i)
+	}
+
+	// Nothing is written in an atomic request with a missing order.
+	cancelled := request.Mode == helpers.BulkBestEffort || report.Failed == 0
+
+	if cancelled {
+		for _, index := range valid {
+			if order := byID[request.IDs[index]]; order.StocksID != nil {
+				books.Cancel(*order.StocksID, order.ID)
+			}
+		}
+	}
+
+	written := report.Write(valid, func(indexes []int) (int, error) {
+		failed := -1
+
+		err := walletsService.Transaction(ctx, db, func(tx *gorm.DB) error {
+			failed = -1
+
+			for position, index := range indexes {
+				// closeOrder changes the status it is given, keep the loaded one for retries.
+				order := byID[request.IDs[index]]
+
+				if err := closeOrder(tx, &order, database.OrderStatusCancelled); err != nil {
+					failed = position
+					return err
+				}
+
+				if result := tx.Delete(&order); result.Error != nil {
+					failed = position
+					return result.Error
+				}
+			}
+
+			return nil
+		})
+
+		return failed, err
+	}, func(err error) (int, string) {
+		logger.Error(err)
+		return http.StatusInternalServerError, "Failed to delete order."
+	})
+
+	deleted := map[int]bool{}
+	cacheClient := helpers.Deps(c).Cache
+
+	for _, index := range written {
+		order := byID[request.IDs[index]]
+
+		deleted[index] = true
+
+		cacheClient.Delete(ctx, fmt.Sprintf("order:%d", order.ID))
+		invalidate(ctx, db, order.HistoriesID)
+
+		report.Succeed(index, order.ID, http.StatusOK)
+	}
+
+	for _, index := range valid {
+		order := byID[request.IDs[index]]
+
+		if !cancelled || deleted[index] || order.StocksID == nil || (order.Status != database.OrderStatusOpen && order.Status != database.OrderStatusPartial) {
+			continue
+		}
+
+		rest(db, books, order)
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return report, nil
+}
+
+// Filter is the query of a list request: the orders the caller may see,
+// optionally only those of a status, side or stock and created between from
+// and to, paged with take and skip.
+func Filter(c echo.Context) (func(*gorm.DB) *gorm.DB, *echo.HTTPError) {
+	take, skip, httpErr := helpers.Page(c)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	period, httpErr := helpers.Period(c, "orders.created_at")
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	conditions := map[string]interface{}{}
+
+	if status := c.QueryParam("status"); status != "" {
+		switch status {
+		case database.OrderStatusOpen, database.OrderStatusPartial, database.OrderStatusFilled, database.OrderStatusCancelled, database.OrderStatusExpired:
+			conditions["orders.status"] = status
+		default:
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Status must be open, partial, filled, cancelled or expired.")
+		}
+	}
+
+	if side := c.QueryParam("side"); side != "" {
+		if side != database.OrderSideBuy && side != database.OrderSideSell {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Side must be buy or sell.")
+		}
+
+		conditions["orders.side"] = side
+	}
+
+	if stockQuery := c.QueryParam("stocks_id"); stockQuery != "" {
+		stockID, err := strconv.ParseUint(stockQuery, 10, 32)
+
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse stocks_id.")
+		}
+
+		conditions["orders.stocks_id"] = stockID
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Scopes(Owned(c), period).Where(conditions).Limit(take).Offset(skip)
+	}, nil
+}
@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"net/http"
+	"sahamrakyat_test/portfolios/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+func Get(c echo.Context) error {
+	data, err := service.Get(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully get portfolio.",
+		"data":       data,
+	})
+}
@@ -0,0 +1,311 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sahamrakyat_test/database"
+	"sahamrakyat_test/helpers"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/cache/v8"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	MethodFIFO    = "fifo"
+	MethodAverage = "average"
+)
+
+type Holding struct {
+	StocksID    uint    `json:"stocks_id"`
+	Ticker      string  `json:"ticker"`
+	Quantity    uint    `json:"quantity"`
+	AverageCost float64 `json:"average_cost"`
+	CostBasis   float64 `json:"cost_basis"`
+	RealizedPL  float64 `json:"realized_pl"`
+}
+
+type Portfolio struct {
+	UsersID    uint      `json:"users_id"`
+	Method     string    `json:"method"`
+	Holdings   []Holding `json:"holdings"`
+	RealizedPL float64   `json:"realized_pl"`
+}
+
+// ErrInsufficientShares is returned by Cover when a user offers more shares
+// than they hold.
+var ErrInsufficientShares = errors.New("insufficient shares")
+
+// Fill is one execution seen from the user's side of a trade.
+type Fill struct {
+	StocksID uint
+	Ticker   string
+	Side     string
+	Quantity uint
+	Price    uint
+}
+
+// A lot is a block of shares bought at the same price, consumed oldest
+// first when the FIFO method is used.
+type lot struct {
+	quantity uint
+	price    float64
+}
+
+type position struct {
+	lots       []lot
+	quantity   uint
+	cost       float64
+	realizedPL float64
+}
+
+func Get(c echo.Context) (*Portfolio, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	if c.Param("id") == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "User id is required.")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse user id.")
+	}
+
+	if !helpers.OwnsUser(c, uint(id), "portfolios:manage") {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "User not found.")
+	}
+
+	db := helpers.Deps(c).DB
+
+	user := &database.Users{}
+
+	if result := db.Limit(1).Find(user, id); result.Error != nil || result.RowsAffected < 1 {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "User not found.")
+	}
+
+	portfolio := &Portfolio{}
+
+	cacheClient := helpers.Deps(c).Cache
+
+	if err := cacheClient.Get(c.Request().Context(), fmt.Sprintf("portfolio:%d", id), portfolio); err == nil {
+		return portfolio, nil
+	}
+
+	fills := []Fill{}
+
+	if user.HistoriesID != nil {
+		orderIDs := []uint{}
+		owned := map[uint]bool{}
+		trades := []database.Trades{}
+
+		db.Unscoped().Model(&database.Orders{}).Where("histories_id = ?", *user.HistoriesID).Pluck("id", &orderIDs)
+
+		for _, id := range orderIDs {
+			owned[id] = true
+		}
+
+		if len(orderIDs) > 0 {
+			db.Preload("Stock", func(tx *gorm.DB) *gorm.DB {
+				return tx.Unscoped()
+			}).Where("buy_order_id IN ? OR sell_order_id IN ?", orderIDs, orderIDs).Order("executed_at, id").Find(&trades)
+		}
+
+		for _, trade := range trades {
+			fill := Fill{StocksID: trade.StocksID, Quantity: trade.Quantity, Price: trade.Price}
+
+			if trade.Stock != nil {
+				fill.Ticker = trade.Stock.Ticker
+			}
+
+			// A user trading against their own order gets both legs.
+			if owned[trade.BuyOrderID] {
+				fill.Side = database.OrderSideBuy
+				fills = append(fills, fill)
+			}
+
+			if owned[trade.SellOrderID] {
+				fill.Side = database.OrderSideSell
+				fills = append(fills, fill)
+			}
+		}
+	}
+
+	portfolio = Compute(fills, helpers.Deps(c).Config.Portfolio.CostMethod)
+	portfolio.UsersID = user.ID
+
+	if err := cacheClient.Set(&cache.Item{
+		Ctx:   c.Request().Context(),
+		Key:   fmt.Sprintf("portfolio:%d", id),
+		Value: portfolio,
+	}); err != nil {
+		logger.Errorf("Failed to cache portfolio: %v", err)
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return portfolio, nil
+}
+
+// Compute derives holdings from the user's side of their trades, which must
+// be given in execution order. Selling more than is held only closes the
+// position, short positions are not tracked.
+func Compute(fills []Fill, method string) *Portfolio {
+	positions := map[uint]*position{}
+	tickers := map[uint]string{}
+
+	for _, fill := range fills {
+		p, ok := positions[fill.StocksID]
+
+		if !ok {
+			p = &position{}
+			positions[fill.StocksID] = p
+			tickers[fill.StocksID] = fill.Ticker
+		}
+
+		price := float64(fill.Price)
+
+		if fill.Side == database.OrderSideSell {
+			p.sell(fill.Quantity, price, method)
+		} else {
+			p.buy(fill.Quantity, price)
+		}
+	}
+
+	portfolio := &Portfolio{Method: method, Holdings: []Holding{}}
+
+	for stocksID, p := range positions {
+		holding := Holding{
+			StocksID:   stocksID,
+			Ticker:     tickers[stocksID],
+			Quantity:   p.quantity,
+			CostBasis:  round(p.cost),
+			RealizedPL: round(p.realizedPL),
+		}
+
+		if p.quantity > 0 {
+			holding.AverageCost = round(p.cost / float64(p.quantity))
+		}
+
+		portfolio.Holdings = append(portfolio.Holdings, holding)
+		portfolio.RealizedPL += p.realizedPL
+	}
+
+	sort.Slice(portfolio.Holdings, func(i, j int) bool {
+		return portfolio.Holdings[i].Ticker < portfolio.Holdings[j].Ticker
+	})
+
+	portfolio.RealizedPL = round(portfolio.RealizedPL)
+
+	return portfolio
+}
+
+// Cover checks that the owner of a history holds enough shares of a stock
+// for every open sell order of theirs on it, including one just placed.
+// Holdings are their bought minus their sold quantity. It reads through the
+// transaction placing the order and fails with ErrInsufficientShares.
+func Cover(tx *gorm.DB, historiesID uint, stocksID uint) error {
+	var bought, sold, offered int64
+
+	for side, total := range map[string]*int64{"buy_order_id": &bought, "sell_order_id": &sold} {
+		err := tx.Model(&database.Trades{}).
+			Joins("JOIN orders ON orders.id = trades."+side).
+			Where("orders.histories_id = ? AND trades.stocks_id = ?", historiesID, stocksID).
+			Select("COALESCE(SUM(trades.quantity), 0)").
+			Scan(total).Error
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err := tx.Model(&database.Orders{}).
+		Where("histories_id = ? AND stocks_id = ? AND side = ? AND status IN ?", historiesID, stocksID, database.OrderSideSell, []string{database.OrderStatusOpen, database.OrderStatusPartial}).
+		Select("COALESCE(SUM(quantity - filled_quantity), 0)").
+		Scan(&offered).Error
+
+	if err != nil {
+		return err
+	}
+
+	if offered > bought-sold {
+		return ErrInsufficientShares
+	}
+
+	return nil
+}
+
+// Invalidate drops the cached portfolio of every user attached to the
+// given history, called whenever one of their orders changes.
+func Invalidate(ctx context.Context, db *gorm.DB, historiesID *uint) {
+	if historiesID == nil {
+		return
+	}
+
+	userIDs := []uint{}
+
+	db.Model(&database.Users{}).Where("histories_id = ?", *historiesID).Pluck("id", &userIDs)
+
+	cacheClient := helpers.DepsFrom(ctx).Cache
+
+	for _, id := range userIDs {
+		cacheClient.Delete(ctx, fmt.Sprintf("portfolio:%d", id))
+	}
+}
+
+func (p *position) buy(quantity uint, price float64) {
+	p.lots = append(p.lots, lot{quantity: quantity, price: price})
+	p.quantity += quantity
+	p.cost += float64(quantity) * price
+}
+
+func (p *position) sell(quantity uint, price float64, method string) {
+	if quantity > p.quantity {
+		quantity = p.quantity
+	}
+
+	if quantity == 0 {
+		return
+	}
+
+	if method == MethodAverage {
+		average := p.cost / float64(p.quantity)
+
+		p.realizedPL += (price - average) * float64(quantity)
+		p.cost -= average * float64(quantity)
+		p.quantity -= quantity
+
+		return
+	}
+
+	for quantity > 0 {
+		head := &p.lots[0]
+		take := quantity
+
+		if head.quantity < take {
+			take = head.quantity
+		}
+
+		p.realizedPL += (price - head.price) * float64(take)
+		p.cost -= head.price * float64(take)
+		p.quantity -= take
+		head.quantity -= take
+		quantity -= take
+
+		if head.quantity == 0 {
+			p.lots = p.lots[1:]
+		}
+	}
+}
+
+func round(value float64) float64 {
+	return math.Round(value*100) / 100
+}
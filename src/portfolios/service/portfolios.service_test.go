@@ -0,0 +1,75 @@
+package service
+
+import (
+	"reflect"
+	"sahamrakyat_test/database"
+	"testing"
+)
+
+var fills = []Fill{
+	{StocksID: 1, Ticker: "BBCA", Side: database.OrderSideBuy, Quantity: 100, Price: 1000},
+	{StocksID: 1, Ticker: "BBCA", Side: database.OrderSideBuy, Quantity: 100, Price: 1200},
+	{StocksID: 1, Ticker: "BBCA", Side: database.OrderSideSell, Quantity: 150, Price: 1300},
+}
+
+func TestComputeFIFO(t *testing.T) {
+	portfolio := Compute(fills, MethodFIFO)
+
+	// The first lot is sold whole for 300 each and half of the second for
+	// 100 each, the other half is left at 1200.
+	want := []Holding{{StocksID: 1, Ticker: "BBCA", Quantity: 50, AverageCost: 1200, CostBasis: 60000, RealizedPL: 35000}}
+
+	if !reflect.DeepEqual(portfolio.Holdings, want) || portfolio.RealizedPL != 35000 || portfolio.Method != MethodFIFO {
+		t.Fatalf("got %+v, want holdings %+v", portfolio, want)
+	}
+}
+
+func TestComputeAverage(t *testing.T) {
+	portfolio := Compute(fills, MethodAverage)
+
+	// Every share cost 1100 on average, so each sold for 200 more.
+	want := []Holding{{StocksID: 1, Ticker: "BBCA", Quantity: 50, AverageCost: 1100, CostBasis: 55000, RealizedPL: 30000}}
+
+	if !reflect.DeepEqual(portfolio.Holdings, want) || portfolio.RealizedPL != 30000 {
+		t.Fatalf("got %+v, want holdings %+v", portfolio, want)
+	}
+}
+
+func TestComputeClosesOversoldPositions(t *testing.T) {
+	for _, method := range []string{MethodFIFO, MethodAverage} {
+		portfolio := Compute([]Fill{
+			{StocksID: 1, Ticker: "BBCA", Side: database.OrderSideBuy, Quantity: 100, Price: 1000},
+			{StocksID: 1, Ticker: "BBCA", Side: database.OrderSideSell, Quantity: 300, Price: 900},
+			{StocksID: 1, Ticker: "BBCA", Side: database.OrderSideSell, Quantity: 100, Price: 900},
+		}, method)
+
+		want := []Holding{{StocksID: 1, Ticker: "BBCA", RealizedPL: -10000}}
+
+		if !reflect.DeepEqual(portfolio.Holdings, want) {
+			t.Errorf("%s: got %+v, want a closed position with %+v", method, portfolio.Holdings, want)
+		}
+	}
+}
+
+func TestComputeSumsStocks(t *testing.T) {
+	portfolio := Compute(append([]Fill{
+		{StocksID: 2, Ticker: "TLKM", Side: database.OrderSideBuy, Quantity: 300, Price: 3000},
+		{StocksID: 2, Ticker: "TLKM", Side: database.OrderSideSell, Quantity: 100, Price: 2950},
+	}, fills...), MethodFIFO)
+
+	if len(portfolio.Holdings) != 2 || portfolio.Holdings[0].Ticker != "BBCA" || portfolio.Holdings[1].Ticker != "TLKM" {
+		t.Fatalf("got holdings %+v, want BBCA and TLKM in that order", portfolio.Holdings)
+	}
+
+	if portfolio.Holdings[1].Quantity != 200 || portfolio.RealizedPL != 35000-5000 {
+		t.Fatalf("got %+v, want 200 TLKM and a realized P&L of 30000", portfolio)
+	}
+}
+
+func TestComputeWithoutFills(t *testing.T) {
+	portfolio := Compute(nil, MethodFIFO)
+
+	if portfolio.Holdings == nil || len(portfolio.Holdings) != 0 || portfolio.RealizedPL != 0 {
+		t.Fatalf("got %+v, want no holdings", portfolio)
+	}
+}
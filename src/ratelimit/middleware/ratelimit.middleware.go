@@ -0,0 +1,388 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sahamrakyat_test/database"
+	"sahamrakyat_test/helpers"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+// Rule allows Limit requests within any Window long stretch of time.
+type Rule struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Stricter limits for single routes, by method and route path, counted on
+// top of the limit every client has across all routes. RATE_LIMIT_ROUTES
+// overrides or extends them, e.g.
+// "POST /api/v1/orders=10/1m,POST /api/v1/auth/login=5/1m".
+var routeRules = map[string]Rule{
+	"POST /api/v1/orders":        {Limit: 30, Window: time.Minute},
+	"POST /api/v1/auth/login":    {Limit: 10, Window: time.Minute},
+	"POST /api/v1/auth/signup":   {Limit: 10, Window: time.Minute},
+	"POST /api/v1/auth/password": {Limit: 5, Window: time.Minute},
+}
+
+// The sorted set at KEYS[1] holds one member per request in the window,
+// scored by its time in milliseconds. The request is only recorded when it
+// is allowed, so rejected retries do not extend the wait.
+var slidingWindow = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+
+local count = redis.call("ZCARD", KEYS[1])
+local allowed = 0
+
+if count < limit then
+	redis.call("ZADD", KEYS[1], now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+
+redis.call("PEXPIRE", KEYS[1], window)
+
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+local reset = window
+
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+
+return {allowed, count, reset}
+`)
+
+var (
+	rulesOnce sync.Once
+	rules     map[string]Rule
+	fallback  Rule
+)
+
+type check struct {
+	key  string
+	rule Rule
+}
+
+type result struct {
+	rule      Rule
+	member    string
+	allowed   bool
+	remaining int
+	reset     time.Duration
+}
+
+// anonymousCountKey holds what LimitAnonymous counted for a request, so
+// Limit can take it back once the client turns out to be identified.
+const anonymousCountKey = "ratelimitAnonymousCount"
+
+// hit is a request recorded in the window at key.
+type hit struct {
+	key    string
+	member string
+}
+
+// Counted is a request counted against the limits of its client by Count.
+type Counted struct {
+	hits     []hit
+	tightest *result
+}
+
+// LimitAnonymous counts every request against the IP address it comes
+// from before authentication runs, so requests failing it are limited too.
+// Limit releases the count again for requests that turn out to carry valid
+// credentials, and counts them against their API key or user instead.
+func LimitAnonymous() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			counted := Count(c.Request().Context(), "ip:"+c.RealIP(), Rule{}, c.Request().Method+" "+c.Path())
+
+			setHeaders(c, counted)
+
+			if !counted.Allowed() {
+				return reject(c, counted)
+			}
+
+			c.Set(anonymousCountKey, counted)
+
+			return next(c)
+		}
+	}
+}
+
+// Limit counts requests in a sliding window per identified client, the API
+// key or user they come from, and answers 429 once the client is over the
+// default or route specific limit. It has to run after authentication, and
+// after LimitAnonymous, which already counted the anonymous requests.
+// Limits are kept in Redis so they hold across instances and restarts, and
+// requests are let through when Redis is down.
+func Limit() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			apiKey := helpers.CurrentAPIKey(c)
+
+			if apiKey == nil && helpers.CurrentUser(c) == nil {
+				return next(c)
+			}
+
+			ctx := c.Request().Context()
+
+			if anonymous, ok := c.Get(anonymousCountKey).(*Counted); ok {
+				anonymous.Release(ctx)
+			}
+
+			counted := Count(ctx, helpers.ClientIdentity(c), ClientRule(ctx, apiKey), c.Request().Method+" "+c.Path())
+
+			setHeaders(c, counted)
+
+			if !counted.Allowed() {
+				return reject(c, counted)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// Count records a request of a client, named by helpers.Identity, against
+// rule and the limit of its route, "<method> <path>" as in routeRules. A
+// zero rule is the RATE_LIMIT_DEFAULT every user and IP address gets. It
+// serves every transport the API is served on, the dependencies are taken
+// from ctx.
+func Count(ctx context.Context, identity string, rule Rule, route string) *Counted {
+	deps := helpers.DepsFrom(ctx)
+
+	loadRules(deps)
+
+	if rule.Limit == 0 {
+		rule = fallback
+	}
+
+	checks := []check{{key: "ratelimit:" + identity, rule: rule}}
+
+	if rule, ok := rules[route]; ok {
+		checks = append(checks, check{key: fmt.Sprintf("ratelimit:%s:%s", identity, route), rule: rule})
+	}
+
+	counted := &Counted{hits: []hit{}}
+
+	for _, check := range checks {
+		current, err := allow(ctx, deps.Redis, check.key, check.rule)
+
+		if err != nil {
+			deps.Logger.Error(err)
+			counted.tightest = nil
+			return counted
+		}
+
+		if current.allowed {
+			counted.hits = append(counted.hits, hit{key: check.key, member: current.member})
+		}
+
+		if tightest := counted.tightest; tightest == nil || !current.allowed || (tightest.allowed && current.remaining < tightest.remaining) {
+			counted.tightest = current
+		}
+
+		if !current.allowed {
+			break
+		}
+	}
+
+	if !counted.Allowed() {
+		kind, _, _ := strings.Cut(identity, ":")
+		_, path, _ := strings.Cut(route, " ")
+		helpers.RateLimitRejections.WithLabelValues(path, kind).Inc()
+	}
+
+	return counted
+}
+
+// Allowed tells whether the request is within every limit it was counted
+// against, or could not be counted.
+func (c *Counted) Allowed() bool {
+	return c.tightest == nil || c.tightest.allowed
+}
+
+// Headers are the RateLimit headers describing the tightest limit the
+// request was counted against, with Retry-After when it was rejected.
+func (c *Counted) Headers() map[string]string {
+	if c.tightest == nil {
+		return map[string]string{}
+	}
+
+	headers := map[string]string{
+		"RateLimit-Limit":     strconv.Itoa(c.tightest.rule.Limit),
+		"RateLimit-Remaining": strconv.Itoa(c.tightest.remaining),
+		"RateLimit-Reset":     c.resetSeconds(),
+		"RateLimit-Policy":    fmt.Sprintf("%d;w=%d", c.tightest.rule.Limit, int(c.tightest.rule.Window.Seconds())),
+	}
+
+	if !c.tightest.allowed {
+		headers[echo.HeaderRetryAfter] = c.resetSeconds()
+	}
+
+	return headers
+}
+
+// Release takes the request back out of every window it was recorded in.
+func (c *Counted) Release(ctx context.Context) {
+	deps := helpers.DepsFrom(ctx)
+
+	for _, hit := range c.hits {
+		if err := deps.Redis.ZRem(ctx, hit.key, hit.member).Err(); err != nil {
+			deps.Logger.Error(err)
+		}
+	}
+}
+
+func (c *Counted) resetSeconds() string {
+	return strconv.Itoa(int(math.Ceil(c.tightest.reset.Seconds())))
+}
+
+func setHeaders(c echo.Context, counted *Counted) {
+	for name, value := range counted.Headers() {
+		c.Response().Header().Set(name, value)
+	}
+}
+
+// reject answers 429 to a client over its limit.
+func reject(c echo.Context, counted *Counted) error {
+	return c.JSON(http.StatusTooManyRequests, echo.Map{
+		"statusCode": http.StatusTooManyRequests,
+		"message":    fmt.Sprintf("Too many requests, retry in %s seconds.", counted.resetSeconds()),
+	})
+}
+
+// ClientRule returns the limit across all routes of a client: the key's
+// own rate_limit per minute or API_KEY_RATE_LIMIT for API keys, and the
+// zero rule standing for RATE_LIMIT_DEFAULT for users.
+func ClientRule(ctx context.Context, apiKey *database.APIKeys) Rule {
+	if apiKey == nil {
+		return Rule{}
+	}
+
+	limit := int(apiKey.RateLimit)
+
+	if limit == 0 {
+		limit = helpers.DepsFrom(ctx).Config.RateLimit.APIKey
+	}
+
+	return Rule{Limit: limit, Window: time.Minute}
+}
+
+func allow(ctx context.Context, client redis.Scripter, key string, rule Rule) (*result, error) {
+	now := time.Now()
+	member := fmt.Sprintf("%d-%d", now.UnixNano(), rand.Int63())
+
+	raw, err := slidingWindow.Run(ctx, client, []string{key},
+		now.UnixMilli(),
+		rule.Window.Milliseconds(),
+		rule.Limit,
+		member,
+	).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	reply, ok := raw.([]interface{})
+
+	if !ok || len(reply) != 3 {
+		return nil, fmt.Errorf("unexpected rate limit reply %v", raw)
+	}
+
+	values := make([]int64, len(reply))
+
+	for i, value := range reply {
+		if values[i], err = toInt64(value); err != nil {
+			return nil, err
+		}
+	}
+
+	return &result{
+		rule:      rule,
+		member:    member,
+		allowed:   values[0] == 1,
+		remaining: rule.Limit - int(values[1]),
+		reset:     time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
+
+func toInt64(value interface{}) (int64, error) {
+	if n, ok := value.(int64); ok {
+		return n, nil
+	}
+
+	return 0, fmt.Errorf("unexpected rate limit reply %v", value)
+}
+
+func loadRules(deps *helpers.Dependencies) {
+	rulesOnce.Do(func() {
+		logger := deps.Logger
+
+		fallback = Rule{Limit: 120, Window: time.Minute}
+
+		if rule, err := parseRule(deps.Config.RateLimit.Default); err != nil {
+			logger.Errorf("Ignoring RATE_LIMIT_DEFAULT: %v", err)
+		} else {
+			fallback = rule
+		}
+
+		rules = map[string]Rule{}
+
+		for route, rule := range routeRules {
+			rules[route] = rule
+		}
+
+		for _, entry := range strings.Split(deps.Config.RateLimit.Routes, ",") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+
+			route, value, found := strings.Cut(entry, "=")
+			rule, err := parseRule(value)
+
+			if !found || err != nil {
+				logger.Errorf("Ignoring RATE_LIMIT_ROUTES entry %q", entry)
+				continue
+			}
+
+			rules[strings.Join(strings.Fields(route), " ")] = rule
+		}
+	})
+}
+
+// parseRule reads limits written as "<requests>/<window>", e.g. "120/1m".
+func parseRule(value string) (Rule, error) {
+	limit, window, found := strings.Cut(strings.TrimSpace(value), "/")
+
+	if !found {
+		return Rule{}, fmt.Errorf("expected <requests>/<window>, got %q", value)
+	}
+
+	n, err := strconv.Atoi(limit)
+
+	if err != nil || n < 1 {
+		return Rule{}, fmt.Errorf("invalid request count %q", limit)
+	}
+
+	d, err := time.ParseDuration(window)
+
+	if err != nil || d < time.Second {
+		return Rule{}, fmt.Errorf("invalid window %q", window)
+	}
+
+	return Rule{Limit: n, Window: d}, nil
+}
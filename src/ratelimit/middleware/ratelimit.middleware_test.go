@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sahamrakyat_test/database"
+	"sahamrakyat_test/helpers"
+	"sahamrakyat_test/helpers/redistest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// windows runs the sliding window script of the limiter in Go, and ZREM on
+// the windows it keeps.
+type windows map[string]map[string]int64
+
+func (w windows) run(args []string) (string, bool) {
+	switch strings.ToLower(args[0]) {
+	case "evalsha":
+		return "-NOSCRIPT No matching script.\r\n", true
+	case "eval":
+		key := args[3]
+		now, _ := strconv.ParseInt(args[4], 10, 64)
+		window, _ := strconv.ParseInt(args[5], 10, 64)
+		limit, _ := strconv.Atoi(args[6])
+
+		members := w[key]
+
+		if members == nil {
+			members = map[string]int64{}
+			w[key] = members
+		}
+
+		for member, score := range members {
+			if score <= now-window {
+				delete(members, member)
+			}
+		}
+
+		allowed := 0
+
+		if len(members) < limit {
+			members[args[7]] = now
+			allowed = 1
+		}
+
+		scores := []int64{}
+
+		for _, score := range members {
+			scores = append(scores, score)
+		}
+
+		sort.Slice(scores, func(i, j int) bool { return scores[i] < scores[j] })
+
+		reset := window
+
+		if len(scores) > 0 {
+			reset = scores[0] + window - now
+		}
+
+		return fmt.Sprintf("*3\r\n:%d\r\n:%d\r\n:%d\r\n", allowed, len(members), reset), true
+	case "zrem":
+		removed := int64(0)
+
+		if _, ok := w[args[1]][args[2]]; ok {
+			delete(w[args[1]], args[2])
+			removed = 1
+		}
+
+		return redistest.Int(removed), true
+	default:
+		return "", false
+	}
+}
+
+func startRedis(t *testing.T) string {
+	return redistest.Start(t, windows{}.run).Addr()
+}
+
+// newApp serves GET /api/v1/trades behind the limiters, as the given user
+// when userID is not 0, answering 401 to anonymous requests like
+// authentication does. Every client may make 3 requests a minute.
+func newApp(addr string) *echo.Echo {
+	config := &helpers.Config{}
+	config.RateLimit.Default = "3/1m"
+	config.RateLimit.APIKey = 600
+
+	deps := &helpers.Dependencies{
+		Config: config,
+		Redis:  redis.NewRing(&redis.RingOptions{Addrs: map[string]string{"server": addr}}),
+		Logger: log.New(),
+	}
+
+	deps.Logger.SetOutput(io.Discard)
+
+	authenticate := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id, _ := strconv.ParseUint(c.Request().Header.Get("X-User"), 10, 32)
+
+			if id == 0 {
+				return c.NoContent(http.StatusUnauthorized)
+			}
+
+			helpers.SetCurrentUser(c, &database.Users{ID: uint(id)})
+
+			return next(c)
+		}
+	}
+
+	app := echo.New()
+	app.IPExtractor = echo.ExtractIPDirect()
+	app.Use(helpers.Inject(deps))
+	app.GET("/api/v1/trades", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, LimitAnonymous(), authenticate, Limit())
+
+	return app
+}
+
+func get(app *echo.Echo, address string, userID int) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/api/v1/trades", nil)
+	request.RemoteAddr = address + ":1234"
+
+	if userID != 0 {
+		request.Header.Set("X-User", strconv.Itoa(userID))
+	}
+
+	recorder := httptest.NewRecorder()
+	app.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestFailedAuthenticationIsLimitedPerAddress(t *testing.T) {
+	app := newApp(startRedis(t))
+
+	for i := 0; i < 3; i++ {
+		if recorder := get(app, "192.0.2.1", 0); recorder.Code != http.StatusUnauthorized {
+			t.Fatalf("request %d answered %d, want 401", i+1, recorder.Code)
+		}
+	}
+
+	recorder := get(app, "192.0.2.1", 0)
+
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get(echo.HeaderRetryAfter) == "" {
+		t.Fatalf("request over the limit answered %d with Retry-After %q, want 429 with a wait", recorder.Code, recorder.Header().Get(echo.HeaderRetryAfter))
+	}
+
+	if recorder := get(app, "192.0.2.2", 0); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("another address answered %d, want 401", recorder.Code)
+	}
+}
+
+func TestIdentifiedRequestsCountAgainstTheirUser(t *testing.T) {
+	app := newApp(startRedis(t))
+
+	for i := 0; i < 3; i++ {
+		recorder := get(app, "192.0.2.1", 1)
+
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("request %d answered %d, want 200", i+1, recorder.Code)
+		}
+
+		if remaining := recorder.Header().Get("RateLimit-Remaining"); remaining != strconv.Itoa(2-i) {
+			t.Errorf("request %d has RateLimit-Remaining %q, want %d", i+1, remaining, 2-i)
+		}
+	}
+
+	if recorder := get(app, "192.0.2.1", 1); recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit of the user answered %d, want 429", recorder.Code)
+	}
+
+	// The requests of user 1 were taken off the address they came from.
+	if recorder := get(app, "192.0.2.1", 2); recorder.Code != http.StatusOK {
+		t.Fatalf("another user on the same address answered %d, want 200", recorder.Code)
+	}
+
+	if recorder := get(app, "192.0.2.1", 0); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("an anonymous request from the same address answered %d, want 401", recorder.Code)
+	}
+}
+
+func TestRequestsPassWhileRedisIsDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := listener.Addr().String()
+	listener.Close()
+
+	app := newApp(addr)
+
+	for i := 0; i < 5; i++ {
+		if recorder := get(app, "192.0.2.1", 1); recorder.Code != http.StatusOK {
+			t.Fatalf("request %d answered %d, want 200", i+1, recorder.Code)
+		}
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	cases := map[string]*Rule{
+		"120/1m":  {Limit: 120, Window: time.Minute},
+		" 5/30s ": {Limit: 5, Window: 30 * time.Second},
+		"120":     nil,
+		"0/1m":    nil,
+		"10/10ms": nil,
+		"ten/1m":  nil,
+	}
+
+	for value, want := range cases {
+		rule, err := parseRule(value)
+
+		if want == nil {
+			if err == nil {
+				t.Errorf("parseRule(%q) = %+v, want an error", value, rule)
+			}
+
+			continue
+		}
+
+		if err != nil || rule != *want {
+			t.Errorf("parseRule(%q) = %+v, %v, want %+v", value, rule, err, *want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"net/http"
+	"sahamrakyat_test/stocks/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+func Create(c echo.Context) error {
+	data, err := service.Create(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"statusCode": http.StatusCreated,
+		"message":    "Successfully created new stock.",
+		"data":       data,
+	})
+}
+
+func GetAll(c echo.Context) error {
+	data, err := service.GetAll(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully get all stocks.",
+		"data":       data,
+	})
+}
+
+func Get(c echo.Context) error {
+	data, err := service.Get(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully get stock.",
+		"data":       data,
+	})
+}
+
+func Update(c echo.Context) error {
+	data, err := service.Update(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully updated stock.",
+		"data":       data,
+	})
+}
+
+func Delete(c echo.Context) error {
+	data, err := service.Delete(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully deleted stock.",
+		"data":       data,
+	})
+}
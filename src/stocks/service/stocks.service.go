@@ -0,0 +1,420 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sahamrakyat_test/database"
+	"sahamrakyat_test/helpers"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator"
+	"github.com/go-redis/cache/v8"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Columns a listing file must have in its header row, in any order. The
+// sector, lot_size and listing_status columns are optional.
+var importColumns = []string{"ticker", "company_name", "board"}
+
+func Create(c echo.Context) (*database.Stocks, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	// Binding over the default keeps it when lot_size is left out, while
+	// an explicit 0 still fails validation.
+	stock := &database.Stocks{LotSize: database.DefaultLotSize}
+
+	if err := c.Bind(stock); err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind stock.")
+	}
+
+	stock.Ticker = strings.ToUpper(strings.TrimSpace(stock.Ticker))
+
+	if err := validator.New().Struct(stock); err != nil {
+		logger.Error(err)
+
+		validationErrors := []echo.Map{}
+
+		if _, ok := err.(*validator.InvalidValidationError); ok {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Invalid argument passed.")
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+
+			validationErrors = append(validationErrors, echo.Map{
+				"namespace":       err.Namespace(),
+				"field":           err.Field(),
+				"structNamespace": err.StructNamespace(),
+				"structField":     err.StructField(),
+				"tag":             err.Tag(),
+				"actualTag":       err.ActualTag(),
+				"kind":            err.Kind(),
+				"type":            err.Type(),
+				"value":           err.Value(),
+				"param":           err.Param(),
+			})
+		}
+
+		return nil, echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"message": "Failed to validate stock.",
+			"error":   validationErrors,
+		})
+	}
+
+	db := helpers.Deps(c).DB
+
+	if result := db.Create(stock); result.Error != nil {
+		logger.Error(result.Error)
+		return nil, echo.NewHTTPError(http.StatusConflict, "Stock ticker already exists.")
+	}
+
+	cacheClient := helpers.Deps(c).Cache
+
+	cacheClient.Delete(c.Request().Context(), "stocks")
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return stock, nil
+}
+
+func GetAll(c echo.Context) (*[]database.Stocks, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	take, skip, httpErr := helpers.Page(c)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	// The whole instrument list is cached as one entry since it is small and
+	// rarely changes, pagination is applied on the cached list.
+	stocks := &[]database.Stocks{}
+
+	cacheClient := helpers.Deps(c).Cache
+
+	if err := cacheClient.Get(c.Request().Context(), "stocks", stocks); err != nil {
+		db := helpers.Deps(c).DB
+
+		db.Order("ticker").Find(stocks)
+
+		if err := cacheClient.Set(&cache.Item{
+			Ctx:   c.Request().Context(),
+			Key:   "stocks",
+			Value: stocks,
+		}); err != nil {
+			logger.Errorf("Failed to cache stocks: %v", err)
+		}
+	}
+
+	page := *stocks
+
+	if skip > 0 {
+		if skip > len(page) {
+			skip = len(page)
+		}
+		page = page[skip:]
+	}
+
+	if take >= 0 && take < len(page) {
+		page = page[:take]
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return &page, nil
+}
+
+func Get(c echo.Context) (*database.Stocks, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	if c.Param("id") == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Stock id is required.")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse stock id.")
+	}
+
+	db := helpers.Deps(c).DB
+
+	stock, err := Find(c.Request().Context(), db, uint(id))
+
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Stock not found.")
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return stock, nil
+}
+
+func Update(c echo.Context) (*database.Stocks, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	if c.Param("id") == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Stock id is required.")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse stock id.")
+	}
+
+	db := helpers.Deps(c).DB
+
+	stock := &database.Stocks{}
+
+	if result := db.Limit(1).Find(stock, id); result.Error != nil || result.RowsAffected < 1 {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Stock not found.")
+	}
+
+	if err := c.Bind(stock); err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind stock.")
+	}
+
+	stock.ID = uint(id)
+	stock.Ticker = strings.ToUpper(strings.TrimSpace(stock.Ticker))
+
+	if err := validator.New().Struct(stock); err != nil {
+		logger.Error(err)
+
+		validationErrors := []echo.Map{}
+
+		if _, ok := err.(*validator.InvalidValidationError); ok {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Invalid argument passed.")
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+
+			validationErrors = append(validationErrors, echo.Map{
+				"namespace":       err.Namespace(),
+				"field":           err.Field(),
+				"structNamespace": err.StructNamespace(),
+				"structField":     err.StructField(),
+				"tag":             err.Tag(),
+				"actualTag":       err.ActualTag(),
+				"kind":            err.Kind(),
+				"type":            err.Type(),
+				"value":           err.Value(),
+				"param":           err.Param(),
+			})
+		}
+
+		return nil, echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"message": "Failed to validate stock.",
+			"error":   validationErrors,
+		})
+	}
+
+	if result := db.Save(stock); result.Error != nil {
+		logger.Error(result.Error)
+		return nil, echo.NewHTTPError(http.StatusConflict, "Stock ticker already exists.")
+	}
+
+	cacheClient := helpers.Deps(c).Cache
+
+	cacheClient.Delete(c.Request().Context(), fmt.Sprintf("stock:%d", id))
+	cacheClient.Delete(c.Request().Context(), "stocks")
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return stock, nil
+}
+
+func Delete(c echo.Context) (*database.Stocks, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	if c.Param("id") == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Stock id is required.")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse stock id.")
+	}
+
+	db := helpers.Deps(c).DB
+
+	stock := &database.Stocks{}
+
+	if result := db.Limit(1).Find(stock, id); result.Error != nil || result.RowsAffected < 1 {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Stock not found.")
+	}
+
+	db.Delete(stock)
+
+	cacheClient := helpers.Deps(c).Cache
+
+	cacheClient.Delete(c.Request().Context(), fmt.Sprintf("stock:%d", id))
+	cacheClient.Delete(c.Request().Context(), "stocks")
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return stock, nil
+}
+
+// Find returns a stock by id, served from cache when possible.
+func Find(ctx context.Context, db *gorm.DB, id uint) (*database.Stocks, error) {
+	stock := &database.Stocks{}
+
+	cacheClient := helpers.DepsFrom(ctx).Cache
+
+	if err := cacheClient.Get(ctx, fmt.Sprintf("stock:%d", id), stock); err == nil {
+		return stock, nil
+	}
+
+	if result := db.Limit(1).Find(stock, id); result.Error != nil {
+		return nil, result.Error
+	} else if result.RowsAffected < 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	if err := cacheClient.Set(&cache.Item{
+		Ctx:   ctx,
+		Key:   fmt.Sprintf("stock:%d", id),
+		Value: stock,
+	}); err != nil {
+		fmt.Printf("Failed to cache stock: %v\n", err)
+	}
+
+	return stock, nil
+}
+
+// FindTradable returns a stock that orders can be placed on, rejecting
+// suspended and delisted instruments.
+func FindTradable(ctx context.Context, db *gorm.DB, id uint) (*database.Stocks, error) {
+	stock, err := Find(ctx, db, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if stock.ListingStatus != database.ListingStatusListed {
+		return nil, fmt.Errorf("stock %s is %s", stock.Ticker, stock.ListingStatus)
+	}
+
+	return stock, nil
+}
+
+// Import loads a listing file in CSV format, inserting new tickers and
+// updating existing ones. Every row is validated before anything is written,
+// so a bad file leaves the table untouched.
+func Import(ctx context.Context, db *gorm.DB, r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	columns := map[string]int{}
+
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range importColumns {
+		if _, ok := columns[name]; !ok {
+			return 0, fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	column := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	validate := validator.New()
+	stocks := []database.Stocks{}
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		stock := database.Stocks{
+			Ticker:        strings.ToUpper(column(record, "ticker")),
+			CompanyName:   column(record, "company_name"),
+			Board:         strings.ToLower(column(record, "board")),
+			Sector:        column(record, "sector"),
+			LotSize:       database.DefaultLotSize,
+			ListingStatus: strings.ToLower(column(record, "listing_status")),
+		}
+
+		if lotSize := column(record, "lot_size"); lotSize != "" {
+			val, err := strconv.ParseUint(lotSize, 10, 32)
+
+			if err != nil {
+				return 0, fmt.Errorf("line %d: invalid lot_size %q", line, lotSize)
+			}
+
+			stock.LotSize = uint(val)
+		}
+
+		if stock.ListingStatus == "" {
+			stock.ListingStatus = database.ListingStatusListed
+		}
+
+		if err := validate.Struct(stock); err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		stocks = append(stocks, stock)
+	}
+
+	if len(stocks) == 0 {
+		return 0, nil
+	}
+
+	// deleted_at is part of the update so re-importing a removed ticker
+	// brings it back instead of failing on the unique index.
+	result := db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "ticker"}},
+		DoUpdates: clause.AssignmentColumns([]string{"company_name", "board", "sector", "lot_size", "listing_status", "updated_at", "deleted_at"}),
+	}).CreateInBatches(&stocks, 500)
+
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	cacheClient := helpers.DepsFrom(ctx).Cache
+
+	cacheClient.Delete(ctx, "stocks")
+
+	for _, stock := range stocks {
+		cacheClient.Delete(ctx, fmt.Sprintf("stock:%d", stock.ID))
+	}
+
+	return len(stocks), nil
+}
@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"net/http"
+	"sahamrakyat_test/trades/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+func GetAll(c echo.Context) error {
+	data, err := service.GetAll(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully get all trades.",
+		"data":       data,
+	})
+}
+
+func Get(c echo.Context) error {
+	data, err := service.Get(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully get trade.",
+		"data":       data,
+	})
+}
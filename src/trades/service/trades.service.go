@@ -0,0 +1,181 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"sahamrakyat_test/database"
+	"sahamrakyat_test/helpers"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/cache/v8"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+func GetAll(c echo.Context) (*[]database.Trades, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	take, skip, httpErr := helpers.Page(c)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	period, httpErr := helpers.Period(c, "executed_at")
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	db := helpers.Deps(c).DB
+
+	query := db.Scopes(period).Limit(take).Offset(skip).Order("executed_at DESC, id DESC")
+
+	userQuery := c.QueryParam("user_id")
+
+	// Without trades:manage only the caller's own trades are listed.
+	if !helpers.Can(c, "trades:manage") {
+		current := helpers.CurrentUser(c)
+
+		if current == nil {
+			return nil, echo.NewHTTPError(http.StatusUnauthorized, "Missing bearer token.")
+		}
+
+		userQuery = strconv.FormatUint(uint64(current.ID), 10)
+	}
+
+	if userQuery != "" {
+		userID, err := strconv.ParseUint(userQuery, 10, 32)
+
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse user_id.")
+		}
+
+		// Orders belong to a user through the history they share. Cancelled
+		// orders keep their trades.
+		userOrders := db.Unscoped().Model(&database.Orders{}).Select("orders.id").Joins("JOIN users ON users.histories_id = orders.histories_id").Where("users.id = ?", userID)
+
+		query = query.Where("buy_order_id IN (?) OR sell_order_id IN (?)", userOrders, userOrders)
+	}
+
+	if ticker := c.QueryParam("ticker"); ticker != "" {
+		query = query.Where("stocks_id IN (?)", db.Model(&database.Stocks{}).Select("id").Where("ticker = ?", strings.ToUpper(ticker)))
+	}
+
+	trades := &[]database.Trades{}
+
+	if result := query.Find(trades); result.Error != nil {
+		logger.Error(result.Error)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get trades.")
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return trades, nil
+}
+
+func Get(c echo.Context) (*database.Trades, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	if c.Param("id") == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Trade id is required.")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse trade id.")
+	}
+
+	trade := &database.Trades{}
+
+	cacheClient := helpers.Deps(c).Cache
+
+	db := helpers.Deps(c).DB
+
+	// Only the row of a trade is cached, it never changes once executed. Its
+	// orders do, so they are always read.
+	if err := cacheClient.Get(c.Request().Context(), fmt.Sprintf("trade:%d", id), trade); err != nil {
+		if result := db.Limit(1).Find(trade, id); result.Error != nil || result.RowsAffected < 1 {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "Trade not found.")
+		}
+
+		if err := cacheClient.Set(&cache.Item{
+			Ctx:   c.Request().Context(),
+			Key:   fmt.Sprintf("trade:%d", id),
+			Value: trade,
+		}); err != nil {
+			logger.Errorf("Failed to cache trade: %v", err)
+		}
+	}
+
+	if err := load(db, trade); err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get trade.")
+	}
+
+	if !involves(c, trade) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Trade not found.")
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return trade, nil
+}
+
+// load reads the orders and stock of a trade. Orders cancelled and stocks
+// delisted since are soft deleted, but still part of it.
+func load(db *gorm.DB, trade *database.Trades) error {
+	orders := []database.Orders{}
+
+	if err := db.Unscoped().Where("id IN ?", []uint{trade.BuyOrderID, trade.SellOrderID}).Find(&orders).Error; err != nil {
+		return err
+	}
+
+	for i := range orders {
+		switch orders[i].ID {
+		case trade.BuyOrderID:
+			trade.BuyOrder = &orders[i]
+		case trade.SellOrderID:
+			trade.SellOrder = &orders[i]
+		}
+	}
+
+	stock := &database.Stocks{}
+
+	if err := db.Unscoped().Limit(1).Find(stock, trade.StocksID).Error; err != nil {
+		return err
+	}
+
+	trade.Stock = stock
+
+	return nil
+}
+
+// involves reports whether the current user may see the trade, either
+// because one of its orders is theirs or because they hold trades:manage.
+func involves(c echo.Context, trade *database.Trades) bool {
+	if helpers.Can(c, "trades:manage") {
+		return true
+	}
+
+	user := helpers.CurrentUser(c)
+
+	if user == nil || user.HistoriesID == nil {
+		return false
+	}
+
+	for _, order := range []*database.Orders{trade.BuyOrder, trade.SellOrder} {
+		if order != nil && order.HistoriesID != nil && *order.HistoriesID == *user.HistoriesID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"net/http"
+	"sahamrakyat_test/helpers"
 	"sahamrakyat_test/users/service"
 
 	"github.com/labstack/echo/v4"
@@ -91,3 +92,52 @@ func Delete(c echo.Context) error {
 		"data":       data,
 	})
 }
+
+func AssignRole(c echo.Context) error {
+	data, err := service.AssignRole(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully assigned role.",
+		"data":       data,
+	})
+}
+
+func BulkCreate(c echo.Context) error {
+	return bulk(c, service.BulkCreate, "Successfully processed bulk create.")
+}
+
+func BulkUpdate(c echo.Context) error {
+	return bulk(c, service.BulkUpdate, "Successfully processed bulk update.")
+}
+
+func BulkDelete(c echo.Context) error {
+	return bulk(c, service.BulkDelete, "Successfully processed bulk delete.")
+}
+
+// bulk answers a bulk request with its report, under the status of the
+// whole request: 200, 207 when only some items were written, 422 when none
+// were.
+func bulk(c echo.Context, run func(echo.Context) (*helpers.BulkReport, *echo.HTTPError), message string) error {
+	report, err := run(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(report.Status(), echo.Map{
+		"statusCode": report.Status(),
+		"message":    message,
+		"data":       report,
+	})
+}
@@ -1,21 +1,24 @@
 package service
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
 	"time"
+	authService "sahamrakyat_test/auth/service"
 	"sahamrakyat_test/database"
 	"sahamrakyat_test/helpers"
 
 	"github.com/go-playground/validator"
 	"github.com/go-redis/cache/v8"
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 func Create(c echo.Context) (*database.Users, *echo.HTTPError) {
-	logger := helpers.InitLogger()
+	logger := helpers.Deps(c).Logger
 
 	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
 
@@ -57,9 +60,42 @@ func Create(c echo.Context) (*database.Users, *echo.HTTPError) {
 		})
 	}
 
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
+	if user.PlainPassword != "" {
+		hash, err := authService.HashPassword(user.PlainPassword)
 
-	db.Create(user)
+		if err != nil {
+			logger.Error(err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to hash password.")
+		}
+
+		now := time.Now()
+
+		user.Password = hash
+		user.PasswordChangedAt = &now
+		user.PlainPassword = ""
+	}
+
+	if user.Email != nil {
+		email := strings.ToLower(strings.TrimSpace(*user.Email))
+		user.Email = &email
+	}
+
+	db := helpers.Deps(c).DB
+
+	if user.RolesID == nil {
+		role := &database.Roles{}
+
+		if result := db.Where("name = ?", database.RoleUser).Limit(1).Find(role); result.Error == nil && result.RowsAffected > 0 {
+			user.RolesID = &role.ID
+		}
+	}
+
+	if result := db.Create(user); result.Error != nil {
+		logger.Error(result.Error)
+		return nil, echo.NewHTTPError(http.StatusConflict, "Email or phone is already registered.")
+	}
+
+	helpers.Deps(c).Cache.InvalidateLists(c.Request().Context(), "users")
 
 	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
 
@@ -67,54 +103,38 @@ func Create(c echo.Context) (*database.Users, *echo.HTTPError) {
 }
 
 func GetAll(c echo.Context) (*[]database.Users, *echo.HTTPError) {
-	logger := helpers.InitLogger()
+	logger := helpers.Deps(c).Logger
 
 	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
 
-	var take int
-	var skip int
+	filter, httpErr := Filter(c)
 
-	takeQuery := c.QueryParam("take")
-
-	if takeQuery != "" {
-		if val, err := strconv.ParseInt(takeQuery, 10, 32); err != nil {
-			return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse take.")
-		} else {
-			take = int(val)
-		}
-	} else {
-		take = -1
-	}
-
-	skipQuery := c.QueryParam("skip")
-
-	if skipQuery != "" {
-		if val, err := strconv.ParseInt(skipQuery, 10, 32); err != nil {
-			return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse skip.")
-		} else {
-			skip = int(val)
-		}
-	} else {
-		skip = -1
+	if httpErr != nil {
+		return nil, httpErr
 	}
 
 	users := &[]database.Users{}
 
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
+	db := helpers.Deps(c).DB
 
-	db.Limit(take).Offset(skip).Find(users)
+	cacheKey, cacheable := helpers.ListCacheKey(c, "users", "users:manage")
 
-	cacheClient := helpers.InitRedisCache()
+	db.Scopes(filter).Find(users)
 
-	if err := cacheClient.Get(c.Request().Context(), "users", users); err == nil {
-		return users, nil
-	} else if err != nil {
-		if err := cacheClient.Set(&cache.Item{
-			Ctx:   c.Request().Context(),
-			Key:   "users",
-			Value: users,
-		}); err != nil {
-			fmt.Printf("Failed to cache users: %v", err)
+	// Only whole lists are cached, filtered and paged ones are always read.
+	if cacheable && c.QueryString() == "" {
+		cacheClient := helpers.Deps(c).Cache
+
+		if err := cacheClient.Get(c.Request().Context(), cacheKey, users); err == nil {
+			return users, nil
+		} else if err != nil {
+			if err := cacheClient.Set(&cache.Item{
+				Ctx:   c.Request().Context(),
+				Key:   cacheKey,
+				Value: users,
+			}); err != nil {
+				fmt.Printf("Failed to cache users: %v", err)
+			}
 		}
 	}
 
@@ -124,7 +144,7 @@ func GetAll(c echo.Context) (*[]database.Users, *echo.HTTPError) {
 }
 
 func Get(c echo.Context) (*database.Users, *echo.HTTPError) {
-	logger := helpers.InitLogger()
+	logger := helpers.Deps(c).Logger
 
 	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
 
@@ -138,21 +158,21 @@ func Get(c echo.Context) (*database.Users, *echo.HTTPError) {
 		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse user id.")
 	}
 
-	user := &database.Users{
-		ID: uint(id),
+	if !helpers.OwnsUser(c, uint(id), "users:manage") {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "User not found.")
 	}
 
-	if err := c.Bind(user); err != nil {
-		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind user.")
+	user := &database.Users{
+		ID: uint(id),
 	}
 
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
+	db := helpers.Deps(c).DB
 
 	if result := db.Find(user); result.Error != nil || result.RowsAffected < 1 {
 		return nil, echo.NewHTTPError(http.StatusBadRequest, "User not found.")
 	}
 
-	cacheClient := helpers.InitRedisCache()
+	cacheClient := helpers.Deps(c).Cache
 
 	if err := cacheClient.Get(c.Request().Context(), fmt.Sprintf("user:%d", id), user); err == nil {
 		return user, nil
@@ -171,8 +191,10 @@ func Get(c echo.Context) (*database.Users, *echo.HTTPError) {
 	return user, nil
 }
 
+// Update changes the name, email or phone of a user, like an item of
+// BulkUpdate.
 func Update(c echo.Context) (*database.Users, *echo.HTTPError) {
-	logger := helpers.InitLogger()
+	logger := helpers.Deps(c).Logger
 
 	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
 
@@ -186,27 +208,49 @@ func Update(c echo.Context) (*database.Users, *echo.HTTPError) {
 		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse user id.")
 	}
 
-	user := &database.Users{
-		ID: uint(id),
+	if !helpers.OwnsUser(c, uint(id), "users:manage") {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "User not found.")
 	}
 
-	if err := c.Bind(&database.Users{}); err != nil {
+	patch := &UserPatch{}
+
+	if err := c.Bind(patch); err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind user.")
 	}
 
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
+	// The user is the one of the path, whatever id the body names.
+	patch.ID = uint(id)
 
-	if result := db.First(&user); result.Error != nil || result.RowsAffected < 1 {
-		return nil, echo.NewHTTPError(http.StatusBadRequest, "User not found.")
+	if err := validator.New().Struct(patch); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+			"message": "Failed to validate user.",
+			"error":   helpers.ValidationFields(err),
+		})
 	}
 
-	cacheClient := helpers.InitRedisCache()
+	db := helpers.Deps(c).DB
+	user := &database.Users{}
 
-	if err := cacheClient.Get(c.Request().Context(), fmt.Sprintf("user:%d", id), user); err == nil {
-		cacheClient.Delete(c.Request().Context(), fmt.Sprintf("user:%d", id))
+	if result := db.Limit(1).Find(user, id); result.Error != nil || result.RowsAffected < 1 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "User not found.")
+	}
+
+	if change := patch.changes(); len(change) > 0 {
+		if err := db.Model(&database.Users{}).Where("id = ?", id).Updates(change).Error; err != nil {
+			logger.Error(err)
+			return nil, echo.NewHTTPError(http.StatusConflict, "Email or phone is already registered.")
+		}
 	}
 
-	db.Save(user)
+	cacheClient := helpers.Deps(c).Cache
+
+	cacheClient.Delete(c.Request().Context(), fmt.Sprintf("user:%d", id))
+	cacheClient.InvalidateLists(c.Request().Context(), "users", user.ID)
+
+	if err := db.First(user, id).Error; err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user.")
+	}
 
 	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
 
@@ -214,7 +258,7 @@ func Update(c echo.Context) (*database.Users, *echo.HTTPError) {
 }
 
 func Delete(c echo.Context) (*database.Users, *echo.HTTPError) {
-	logger := helpers.InitLogger()
+	logger := helpers.Deps(c).Logger
 
 	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
 
@@ -228,6 +272,10 @@ func Delete(c echo.Context) (*database.Users, *echo.HTTPError) {
 		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse user id.")
 	}
 
+	if !helpers.OwnsUser(c, uint(id), "users:manage") {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "User not found.")
+	}
+
 	user := &database.Users{
 		ID: uint(id),
 	}
@@ -236,13 +284,13 @@ func Delete(c echo.Context) (*database.Users, *echo.HTTPError) {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind user.")
 	}
 
-	db := helpers.ConnectDatabase(os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_NAME"))
+	db := helpers.Deps(c).DB
 
 	if result := db.First(&user); result.Error != nil || result.RowsAffected < 1 {
 		return nil, echo.NewHTTPError(http.StatusBadRequest, "User not found.")
 	}
 
-	cacheClient := helpers.InitRedisCache()
+	cacheClient := helpers.Deps(c).Cache
 
 	if err := cacheClient.Get(c.Request().Context(), fmt.Sprintf("user:%d", id), user); err == nil {
 		cacheClient.Delete(c.Request().Context(), fmt.Sprintf("user:%d", id))
@@ -250,7 +298,499 @@ func Delete(c echo.Context) (*database.Users, *echo.HTTPError) {
 
 	db.Delete(user)
 
+	cacheClient.InvalidateLists(c.Request().Context(), "users", user.ID)
+
 	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
 
 	return user, nil
 }
+
+type RoleAssignment struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// AssignRole replaces the role of a user, the new permissions apply from
+// their next request on.
+func AssignRole(c echo.Context) (*database.Users, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse user id.")
+	}
+
+	assignment := &RoleAssignment{}
+
+	if err := c.Bind(assignment); err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind role.")
+	}
+
+	if err := validator.New().Struct(assignment); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Role is required.")
+	}
+
+	db := helpers.Deps(c).DB
+
+	role := &database.Roles{}
+
+	if result := db.Where("name = ?", assignment.Role).Limit(1).Find(role); result.Error != nil || result.RowsAffected < 1 {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Role not found.")
+	}
+
+	user := &database.Users{}
+
+	if result := db.Limit(1).Find(user, id); result.Error != nil || result.RowsAffected < 1 {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "User not found.")
+	}
+
+	if result := db.Model(user).Update("roles_id", role.ID); result.Error != nil {
+		logger.Error(result.Error)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to assign role.")
+	}
+
+	helpers.Deps(c).Cache.Delete(c.Request().Context(), fmt.Sprintf("user:%d", id))
+	helpers.Deps(c).Cache.InvalidateLists(c.Request().Context(), "users", user.ID)
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return user, nil
+}
+
+// UserPatch is the body of Update and an item of BulkUpdate, fields left
+// out are kept.
+type UserPatch struct {
+	ID       uint    `json:"id" validate:"required"`
+	FullName *string `json:"full_name" validate:"omitempty,min=1,max=255"`
+	Email    *string `json:"email" validate:"omitempty,email,max=255"`
+	Phone    *string `json:"phone" validate:"omitempty,e164"`
+}
+
+// changes are the columns the patch changes, names are trimmed and emails
+// lower cased as on sign up.
+func (p *UserPatch) changes() map[string]interface{} {
+	change := map[string]interface{}{}
+
+	if p.FullName != nil {
+		change["full_name"] = strings.TrimSpace(*p.FullName)
+	}
+
+	if p.Email != nil {
+		change["email"] = strings.ToLower(strings.TrimSpace(*p.Email))
+	}
+
+	if p.Phone != nil {
+		change["phone"] = *p.Phone
+	}
+
+	return change
+}
+
+// BulkCreate creates every user of a bulk request, each checked like Create
+// checks one. Emails and phones already registered, or repeated within the
+// request, are reported before anything is written.
+func BulkCreate(c echo.Context) (*helpers.BulkReport, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	request, httpErr := helpers.BindBulk(c, false)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	report, httpErr := CreateMany(c, request.Items, request.Mode, false)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return report, nil
+}
+
+// CreateMany creates users from JSON items, reporting on each, see
+// helpers.BulkReport for the modes. With dryRun items are only checked and
+// the valid ones reported with 200, nothing is written.
+func CreateMany(c echo.Context, items []json.RawMessage, mode string, dryRun bool) (*helpers.BulkReport, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+	ctx := c.Request().Context()
+	db := helpers.Deps(c).DB
+	report := helpers.NewBulkReport(mode, len(items))
+	validate := validator.New()
+
+	users := make([]*database.Users, len(items))
+	parsed := []int{}
+	emails := []string{}
+	phones := []string{}
+
+	for i, item := range items {
+		user := &database.Users{}
+
+		if err := json.Unmarshal(item, user); err != nil {
+			report.Fail(i, http.StatusBadRequest, "Failed to bind user.")
+			continue
+		}
+
+		if err := validate.Struct(user); err != nil {
+			report.Invalid(i, err)
+			continue
+		}
+
+		if user.Email != nil {
+			email := strings.ToLower(strings.TrimSpace(*user.Email))
+			user.Email = &email
+			emails = append(emails, email)
+		}
+
+		if user.Phone != nil {
+			phones = append(phones, *user.Phone)
+		}
+
+		users[i] = user
+		parsed = append(parsed, i)
+	}
+
+	// Soft deleted users keep their email and phone, so they are looked up too.
+	registered := []database.Users{}
+
+	if len(emails) > 0 || len(phones) > 0 {
+		if result := db.Unscoped().Select("email", "phone").Where("email IN ? OR phone IN ?", emails, phones).Find(&registered); result.Error != nil {
+			logger.Error(result.Error)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get users.")
+		}
+	}
+
+	taken := map[string]bool{}
+
+	for _, user := range registered {
+		if user.Email != nil {
+			taken["email:"+*user.Email] = true
+		}
+
+		if user.Phone != nil {
+			taken["phone:"+*user.Phone] = true
+		}
+	}
+
+	var role *uint
+
+	defaultRole := &database.Roles{}
+
+	if result := db.Where("name = ?", database.RoleUser).Limit(1).Find(defaultRole); result.Error == nil && result.RowsAffected > 0 {
+		role = &defaultRole.ID
+	}
+
+	valid := []int{}
+
+	for _, i := range parsed {
+		user := users[i]
+		keys := []string{}
+
+		if user.Email != nil {
+			keys = append(keys, "email:"+*user.Email)
+		}
+
+		if user.Phone != nil {
+			keys = append(keys, "phone:"+*user.Phone)
+		}
+
+		conflict := false
+
+		for _, key := range keys {
+			conflict = conflict || taken[key]
+		}
+
+		if conflict {
+			report.Fail(i, http.StatusConflict, "Email or phone is already registered.")
+			continue
+		}
+
+		for _, key := range keys {
+			taken[key] = true
+		}
+
+		if user.PlainPassword != "" && !dryRun {
+			hash, err := authService.HashPassword(user.PlainPassword)
+
+			if err != nil {
+				logger.Error(err)
+				report.Fail(i, http.StatusInternalServerError, "Failed to hash password.")
+				continue
+			}
+
+			now := time.Now()
+
+			user.Password = hash
+			user.PasswordChangedAt = &now
+			user.PlainPassword = ""
+		}
+
+		if user.RolesID == nil {
+			user.RolesID = role
+		}
+
+		valid = append(valid, i)
+	}
+
+	if dryRun {
+		for _, index := range report.Write(valid, func([]int) (int, error) { return -1, nil }, nil) {
+			report.Succeed(index, 0, http.StatusOK)
+		}
+
+		return report, nil
+	}
+
+	written := report.Write(valid, func(indexes []int) (int, error) {
+		batch := make([]*database.Users, len(indexes))
+
+		for position, index := range indexes {
+			batch[position] = users[index]
+			batch[position].ID = 0
+		}
+
+		return -1, db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return tx.CreateInBatches(batch, helpers.BulkChunk).Error
+		})
+	}, func(err error) (int, string) {
+		logger.Error(err)
+		return http.StatusConflict, "Email or phone is already registered."
+	})
+
+	for _, index := range written {
+		report.Succeed(index, users[index].ID, http.StatusCreated)
+	}
+
+	if len(written) > 0 {
+		helpers.Deps(c).Cache.InvalidateLists(ctx, "users")
+	}
+
+	return report, nil
+}
+
+// BulkUpdate changes the name, email or phone of users.
+func BulkUpdate(c echo.Context) (*helpers.BulkReport, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	request, httpErr := helpers.BindBulk(c, false)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	ctx := c.Request().Context()
+	db := helpers.Deps(c).DB
+	report := helpers.NewBulkReport(request.Mode, request.Len())
+	validate := validator.New()
+
+	patches := make([]*UserPatch, request.Len())
+	ids := []uint{}
+
+	for i, item := range request.Items {
+		patch := &UserPatch{}
+
+		if err := json.Unmarshal(item, patch); err != nil {
+			report.Fail(i, http.StatusBadRequest, "Failed to bind user.")
+			continue
+		}
+
+		if err := validate.Struct(patch); err != nil {
+			report.Invalid(i, err)
+			continue
+		}
+
+		patches[i] = patch
+		ids = append(ids, patch.ID)
+	}
+
+	existing, err := existingUsers(db, ids)
+
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get users.")
+	}
+
+	changes := make([]map[string]interface{}, request.Len())
+	valid := []int{}
+
+	for i, patch := range patches {
+		if patch == nil {
+			continue
+		}
+
+		if !existing[patch.ID] {
+			report.Fail(i, http.StatusNotFound, "User not found.")
+			continue
+		}
+
+		changes[i] = patch.changes()
+		valid = append(valid, i)
+	}
+
+	written := report.Write(valid, func(indexes []int) (int, error) {
+		failed := -1
+
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			failed = -1
+
+			for position, index := range indexes {
+				if len(changes[index]) == 0 {
+					continue
+				}
+
+				if result := tx.Model(&database.Users{}).Where("id = ?", patches[index].ID).Updates(changes[index]); result.Error != nil {
+					failed = position
+					return result.Error
+				}
+			}
+
+			return nil
+		})
+
+		return failed, err
+	}, func(err error) (int, string) {
+		logger.Error(err)
+		return http.StatusConflict, "Email or phone is already registered."
+	})
+
+	cacheClient := helpers.Deps(c).Cache
+
+	for _, index := range written {
+		cacheClient.Delete(ctx, fmt.Sprintf("user:%d", patches[index].ID))
+		cacheClient.InvalidateLists(ctx, "users", patches[index].ID)
+		report.Succeed(index, patches[index].ID, http.StatusOK)
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return report, nil
+}
+
+// BulkDelete soft deletes users by id.
+func BulkDelete(c echo.Context) (*helpers.BulkReport, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	request, httpErr := helpers.BindBulk(c, true)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	ctx := c.Request().Context()
+	db := helpers.Deps(c).DB
+	report := helpers.NewBulkReport(request.Mode, request.Len())
+
+	existing, err := existingUsers(db, request.IDs)
+
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get users.")
+	}
+
+	valid := []int{}
+
+	for i, id := range request.IDs {
+		if !existing[id] {
+			report.Fail(i, http.StatusNotFound, "User not found.")
+			continue
+		}
+
+		valid = append(valid, i)
+	}
+
+	written := report.Write(valid, func(indexes []int) (int, error) {
+		ids := make([]uint, len(indexes))
+
+		for position, index := range indexes {
+			ids[position] = request.IDs[index]
+		}
+
+		return -1, db.WithContext(ctx).Delete(&database.Users{}, ids).Error
+	}, func(err error) (int, string) {
+		logger.Error(err)
+		return http.StatusInternalServerError, "Failed to delete user."
+	})
+
+	cacheClient := helpers.Deps(c).Cache
+
+	for _, index := range written {
+		cacheClient.Delete(ctx, fmt.Sprintf("user:%d", request.IDs[index]))
+		cacheClient.InvalidateLists(ctx, "users", request.IDs[index])
+		report.Succeed(index, request.IDs[index], http.StatusOK)
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return report, nil
+}
+
+// existingUsers tells which of ids belong to users that are not deleted.
+func existingUsers(db *gorm.DB, ids []uint) (map[uint]bool, error) {
+	found := []uint{}
+
+	if result := db.Model(&database.Users{}).Where("id IN ?", ids).Pluck("id", &found); result.Error != nil {
+		return nil, result.Error
+	}
+
+	existing := map[uint]bool{}
+
+	for _, id := range found {
+		existing[id] = true
+	}
+
+	return existing, nil
+}
+
+// Filter is the query of a list request: every user for callers with
+// users:manage and only the caller otherwise, optionally only those with an
+// email, phone or role, paged with take and skip.
+func Filter(c echo.Context) (func(*gorm.DB) *gorm.DB, *echo.HTTPError) {
+	take, skip, httpErr := helpers.Page(c)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	conditions := map[string]interface{}{}
+
+	for _, name := range []string{"email", "phone"} {
+		if value := c.QueryParam(name); value != "" {
+			conditions[name] = value
+		}
+	}
+
+	if roleQuery := c.QueryParam("roles_id"); roleQuery != "" {
+		roleID, err := strconv.ParseUint(roleQuery, 10, 32)
+
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse roles_id.")
+		}
+
+		conditions["roles_id"] = roleID
+	}
+
+	if helpers.Can(c, "users:manage") {
+		return func(db *gorm.DB) *gorm.DB {
+			return db.Where(conditions).Limit(take).Offset(skip)
+		}, nil
+	}
+
+	current := helpers.CurrentUser(c)
+
+	if current == nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Missing bearer token.")
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("id = ?", current.ID).Where(conditions).Limit(take).Offset(skip)
+	}, nil
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"net/http"
+	"sahamrakyat_test/wallets/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+func Get(c echo.Context) error {
+	data, err := service.Get(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully get wallet.",
+		"data":       data,
+	})
+}
+
+func GetAll(c echo.Context) error {
+	data, err := service.GetAll(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statusCode": http.StatusOK,
+		"message":    "Successfully get all wallet transactions.",
+		"data":       data,
+	})
+}
+
+func Deposit(c echo.Context) error {
+	data, err := service.Deposit(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"statusCode": http.StatusCreated,
+		"message":    "Successfully deposited funds.",
+		"data":       data,
+	})
+}
+
+func Withdraw(c echo.Context) error {
+	data, err := service.Withdraw(c)
+
+	if err != nil {
+		return c.JSON(err.Code, echo.Map{
+			"statusCode": err.Code,
+			"message":    err.Message,
+		})
+	}
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"statusCode": http.StatusCreated,
+		"message":    "Successfully withdrew funds.",
+		"data":       data,
+	})
+}
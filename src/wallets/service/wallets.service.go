@@ -0,0 +1,335 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sahamrakyat_test/database"
+	"sahamrakyat_test/helpers"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Serializable transactions are aborted by Postgres when they race with
+// another balance change, they are retried this many times in total.
+const maxAttempts = 3
+
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+type Balance struct {
+	UsersID   uint  `json:"users_id"`
+	Available int64 `json:"available"`
+	Held      int64 `json:"held"`
+	Total     int64 `json:"total"`
+}
+
+type Movement struct {
+	Amount int64 `json:"amount" validate:"required,min=1"`
+}
+
+func Get(c echo.Context) (*Balance, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	db := helpers.Deps(c).DB
+
+	user, httpErr := findUser(c, db)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	balance, err := GetBalance(db.WithContext(c.Request().Context()), user.ID)
+
+	if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get wallet balance.")
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return balance, nil
+}
+
+func GetAll(c echo.Context) (*[]database.WalletTransactions, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	take, skip, httpErr := helpers.Page(c)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	period, httpErr := helpers.Period(c, "wallet_transactions.created_at")
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	db := helpers.Deps(c).DB
+
+	user, httpErr := findUser(c, db)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	transactions := &[]database.WalletTransactions{}
+
+	db.Scopes(period).Limit(take).Offset(skip).Preload("Entries").Where("users_id = ?", user.ID).Order("id DESC").Find(transactions)
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return transactions, nil
+}
+
+func Deposit(c echo.Context) (*Balance, *echo.HTTPError) {
+	return move(c, database.WalletTransactionDeposit)
+}
+
+func Withdraw(c echo.Context) (*Balance, *echo.HTTPError) {
+	return move(c, database.WalletTransactionWithdrawal)
+}
+
+// Transaction runs fn in a serializable transaction so that balance checks
+// and the entries written after them cannot interleave with another balance
+// change of the same user.
+func Transaction(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	var err error
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		err = db.WithContext(ctx).Transaction(fn, &sql.TxOptions{Isolation: sql.LevelSerializable})
+
+		var pgErr *pgconn.PgError
+
+		if !errors.As(err, &pgErr) || pgErr.Code != "40001" {
+			return err
+		}
+	}
+
+	return err
+}
+
+// Owner returns the user an order belongs to through its history, or nil
+// when the order has none.
+func Owner(tx *gorm.DB, historiesID *uint) (*database.Users, error) {
+	if historiesID == nil {
+		return nil, nil
+	}
+
+	user := &database.Users{}
+
+	if result := tx.Where("histories_id = ?", *historiesID).Order("id").Limit(1).Find(user); result.Error != nil {
+		return nil, result.Error
+	} else if result.RowsAffected < 1 {
+		return nil, nil
+	}
+
+	return user, nil
+}
+
+func GetBalance(tx *gorm.DB, usersID uint) (*Balance, error) {
+	rows := []struct {
+		Account string
+		Amount  int64
+	}{}
+
+	result := tx.Model(&database.WalletEntries{}).Select("account, COALESCE(SUM(amount), 0) AS amount").Where("users_id = ?", usersID).Group("account").Scan(&rows)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	balance := &Balance{UsersID: usersID}
+
+	for _, row := range rows {
+		switch row.Account {
+		case database.WalletAccountAvailable:
+			balance.Available = row.Amount
+		case database.WalletAccountHeld:
+			balance.Held = row.Amount
+		}
+	}
+
+	balance.Total = balance.Available + balance.Held
+
+	return balance, nil
+}
+
+// Reserve holds funds for a buy order, failing with ErrInsufficientFunds
+// when the available balance does not cover it.
+func Reserve(tx *gorm.DB, usersID uint, ordersID uint, amount int64) error {
+	balance, err := GetBalance(tx, usersID)
+
+	if err != nil {
+		return err
+	}
+
+	if balance.Available < amount {
+		return ErrInsufficientFunds
+	}
+
+	return post(tx, usersID, &ordersID, database.WalletTransactionHold, amount, database.WalletAccountAvailable, database.WalletAccountHeld)
+}
+
+// ReleaseOrder returns whatever is still held for an order to the available
+// balance, used when the order is cancelled or expires.
+func ReleaseOrder(tx *gorm.DB, usersID uint, ordersID uint) error {
+	var held int64
+
+	result := tx.Model(&database.WalletEntries{}).
+		Select("COALESCE(SUM(wallet_entries.amount), 0)").
+		Joins("JOIN wallet_transactions ON wallet_transactions.id = wallet_entries.wallet_transactions_id").
+		Where("wallet_transactions.orders_id = ? AND wallet_entries.users_id = ? AND wallet_entries.account = ?", ordersID, usersID, database.WalletAccountHeld).
+		Scan(&held)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if held <= 0 {
+		return nil
+	}
+
+	return post(tx, usersID, &ordersID, database.WalletTransactionRelease, held, database.WalletAccountHeld, database.WalletAccountAvailable)
+}
+
+// SettleBuy pays for a fill out of the funds held for the buy order. When
+// the fill executed below the order's limit price, the difference is
+// released back to the available balance.
+func SettleBuy(tx *gorm.DB, usersID uint, ordersID uint, cost int64, improvement int64) error {
+	if err := post(tx, usersID, &ordersID, database.WalletTransactionSettlement, cost, database.WalletAccountHeld, database.WalletAccountExternal); err != nil {
+		return err
+	}
+
+	if improvement <= 0 {
+		return nil
+	}
+
+	return post(tx, usersID, &ordersID, database.WalletTransactionRelease, improvement, database.WalletAccountHeld, database.WalletAccountAvailable)
+}
+
+// SettleSell credits the proceeds of a fill to the seller.
+func SettleSell(tx *gorm.DB, usersID uint, ordersID uint, proceeds int64) error {
+	return post(tx, usersID, &ordersID, database.WalletTransactionSettlement, proceeds, database.WalletAccountExternal, database.WalletAccountAvailable)
+}
+
+func move(c echo.Context, kind string) (*Balance, *echo.HTTPError) {
+	logger := helpers.Deps(c).Logger
+
+	logger.Info(helpers.ApacheFormatLogger(c.Request().Method, c.Request().URL.Host, c.Request().Host, c.RealIP(), c.Request().UserAgent(), time.Now().String()))
+
+	movement := &Movement{}
+
+	if err := c.Bind(movement); err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to bind amount.")
+	}
+
+	if err := validator.New().Struct(movement); err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Amount must be a positive number.")
+	}
+
+	db := helpers.Deps(c).DB
+
+	user, httpErr := findUser(c, db)
+
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	balance := &Balance{}
+
+	err := Transaction(c.Request().Context(), db, func(tx *gorm.DB) error {
+		if kind == database.WalletTransactionWithdrawal {
+			current, err := GetBalance(tx, user.ID)
+
+			if err != nil {
+				return err
+			}
+
+			if current.Available < movement.Amount {
+				return ErrInsufficientFunds
+			}
+
+			if err := post(tx, user.ID, nil, kind, movement.Amount, database.WalletAccountAvailable, database.WalletAccountExternal); err != nil {
+				return err
+			}
+		} else if err := post(tx, user.ID, nil, kind, movement.Amount, database.WalletAccountExternal, database.WalletAccountAvailable); err != nil {
+			return err
+		}
+
+		updated, err := GetBalance(tx, user.ID)
+
+		if err != nil {
+			return err
+		}
+
+		balance = updated
+
+		return nil
+	})
+
+	if errors.Is(err, ErrInsufficientFunds) {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Insufficient funds.")
+	} else if err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failed to record %s.", kind))
+	}
+
+	logger.Info(fmt.Sprintf("[Response] %s %s %s %s %s", c.Response().Header().Get("Method"), c.Response().Header().Get("Host"), c.Response().Header().Get("RemoteAddr"), c.Response().Header().Get("UserAgent"), c.Response().Header().Get("Time")))
+
+	return balance, nil
+}
+
+// post writes a transaction moving amount from one account of the user to
+// another as a pair of entries.
+func post(tx *gorm.DB, usersID uint, ordersID *uint, kind string, amount int64, from string, to string) error {
+	transaction := &database.WalletTransactions{
+		UsersID:  usersID,
+		Type:     kind,
+		Amount:   amount,
+		OrdersID: ordersID,
+		Entries: []database.WalletEntries{
+			{UsersID: usersID, Account: from, Amount: -amount},
+			{UsersID: usersID, Account: to, Amount: amount},
+		},
+	}
+
+	return tx.Create(transaction).Error
+}
+
+func findUser(c echo.Context, db *gorm.DB) (*database.Users, *echo.HTTPError) {
+	if c.Param("id") == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "User id is required.")
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse user id.")
+	}
+
+	if !helpers.OwnsUser(c, uint(id), "wallets:manage") {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "User not found.")
+	}
+
+	user := &database.Users{}
+
+	if result := db.Limit(1).Find(user, id); result.Error != nil || result.RowsAffected < 1 {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "User not found.")
+	}
+
+	return user, nil
+}
@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sahamrakyat_test/database"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// stubDriver serves the balances of a ledger set up by a test, by the name
+// it is opened with, and accepts every insert.
+type stubDriver struct{}
+
+type stubConn struct {
+	balances map[string]int64
+	lastID   int64
+}
+
+type stubTx struct{}
+
+type stubRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+var (
+	ledgersMu sync.Mutex
+	ledgers   = map[string]map[string]int64{}
+)
+
+func (stubDriver) Open(name string) (driver.Conn, error) {
+	ledgersMu.Lock()
+	defer ledgersMu.Unlock()
+
+	return &stubConn{balances: ledgers[name]}, nil
+}
+
+func (*stubConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (*stubConn) Close() error { return nil }
+
+func (*stubConn) Begin() (driver.Tx, error) { return stubTx{}, nil }
+
+func (*stubConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) { return stubTx{}, nil }
+
+func (c *stubConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	c.lastID++
+	return stubResult(c.lastID), nil
+}
+
+// QueryContext answers the balance per account of GetBalance, and the sum
+// of an account for everything else.
+func (c *stubConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if strings.HasPrefix(query, "SELECT account") {
+		rows := &stubRows{columns: []string{"account", "amount"}}
+
+		for account, amount := range c.balances {
+			rows.values = append(rows.values, []driver.Value{account, amount})
+		}
+
+		return rows, nil
+	}
+
+	account, _ := args[len(args)-1].Value.(string)
+
+	return &stubRows{columns: []string{"sum"}, values: [][]driver.Value{{c.balances[account]}}}, nil
+}
+
+func (stubTx) Commit() error { return nil }
+
+func (stubTx) Rollback() error { return nil }
+
+type stubResult int64
+
+func (r stubResult) LastInsertId() (int64, error) { return int64(r), nil }
+
+func (r stubResult) RowsAffected() (int64, error) { return 1, nil }
+
+func (r *stubRows) Columns() []string { return r.columns }
+
+func (r *stubRows) Close() error { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+
+	return nil
+}
+
+func init() {
+	sql.Register("wallets-stub", stubDriver{})
+}
+
+// dialector runs GORM on a stubDriver pool.
+type dialector struct {
+	pool *sql.DB
+}
+
+func (d dialector) Name() string { return "postgres" }
+
+func (d dialector) Initialize(db *gorm.DB) error {
+	callbacks.RegisterDefaultCallbacks(db, &callbacks.Config{})
+	db.ConnPool = d.pool
+
+	return nil
+}
+
+func (dialector) Migrator(*gorm.DB) gorm.Migrator { return nil }
+
+func (dialector) DataTypeOf(*schema.Field) string { return "" }
+
+func (dialector) DefaultValueOf(*schema.Field) clause.Expression {
+	return clause.Expr{SQL: "DEFAULT"}
+}
+
+func (dialector) BindVarTo(writer clause.Writer, _ *gorm.Statement, _ interface{}) {
+	writer.WriteByte('?')
+}
+
+func (dialector) QuoteTo(writer clause.Writer, name string) {
+	writer.WriteString(name)
+}
+
+func (dialector) Explain(sql string, _ ...interface{}) string { return sql }
+
+// ledger opens a database with the given balance per account, recording
+// every wallet transaction posted to it.
+func ledger(t *testing.T, balances map[string]int64) (*gorm.DB, *[]database.WalletTransactions) {
+	ledgersMu.Lock()
+	ledgers[t.Name()] = balances
+	ledgersMu.Unlock()
+
+	pool, err := sql.Open("wallets-stub", t.Name())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { pool.Close() })
+
+	db, err := gorm.Open(dialector{pool: pool}, &gorm.Config{SkipDefaultTransaction: true, DisableAutomaticPing: true, Logger: logger.Discard})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posted := &[]database.WalletTransactions{}
+
+	db.Callback().Create().Before("gorm:create").Register("test:posted", func(tx *gorm.DB) {
+		if transaction, ok := tx.Statement.Dest.(*database.WalletTransactions); ok {
+			*posted = append(*posted, *transaction)
+		}
+	})
+
+	return db, posted
+}
+
+type movement struct {
+	kind   string
+	amount int64
+	from   string
+	to     string
+}
+
+func checkLedger(t *testing.T, posted []database.WalletTransactions, want []movement) {
+	t.Helper()
+
+	if len(posted) != len(want) {
+		t.Fatalf("posted %d transactions, want %d: %+v", len(posted), len(want), posted)
+	}
+
+	for i, transaction := range posted {
+		sum := int64(0)
+
+		for _, entry := range transaction.Entries {
+			sum += entry.Amount
+
+			if entry.UsersID != transaction.UsersID {
+				t.Errorf("transaction %d has an entry of user %d, want %d", i, entry.UsersID, transaction.UsersID)
+			}
+		}
+
+		if sum != 0 {
+			t.Errorf("entries of transaction %d sum to %d, want 0: %+v", i, sum, transaction.Entries)
+		}
+
+		got := movement{kind: transaction.Type, amount: transaction.Amount}
+
+		if len(transaction.Entries) == 2 {
+			got.from, got.to = transaction.Entries[0].Account, transaction.Entries[1].Account
+		}
+
+		if got != want[i] {
+			t.Errorf("transaction %d is %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestSettleBuyPaysFromHeldFunds(t *testing.T) {
+	db, posted := ledger(t, nil)
+
+	if err := SettleBuy(db, 1, 2, 10000, 500); err != nil {
+		t.Fatal(err)
+	}
+
+	checkLedger(t, *posted, []movement{
+		{kind: database.WalletTransactionSettlement, amount: 10000, from: database.WalletAccountHeld, to: database.WalletAccountExternal},
+		{kind: database.WalletTransactionRelease, amount: 500, from: database.WalletAccountHeld, to: database.WalletAccountAvailable},
+	})
+
+	for _, transaction := range *posted {
+		if transaction.OrdersID == nil || *transaction.OrdersID != 2 {
+			t.Errorf("transaction %+v does not belong to order 2", transaction)
+		}
+	}
+}
+
+func TestSettleSellCreditsProceeds(t *testing.T) {
+	db, posted := ledger(t, nil)
+
+	if err := SettleSell(db, 1, 3, 7500); err != nil {
+		t.Fatal(err)
+	}
+
+	checkLedger(t, *posted, []movement{
+		{kind: database.WalletTransactionSettlement, amount: 7500, from: database.WalletAccountExternal, to: database.WalletAccountAvailable},
+	})
+}
+
+func TestReserveHoldsAvailableFunds(t *testing.T) {
+	db, posted := ledger(t, map[string]int64{database.WalletAccountAvailable: 1000, database.WalletAccountHeld: 200})
+
+	if err := Reserve(db, 1, 2, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	checkLedger(t, *posted, []movement{
+		{kind: database.WalletTransactionHold, amount: 1000, from: database.WalletAccountAvailable, to: database.WalletAccountHeld},
+	})
+}
+
+func TestReserveRejectsInsufficientFunds(t *testing.T) {
+	// Held funds belong to other orders and do not count.
+	db, posted := ledger(t, map[string]int64{database.WalletAccountAvailable: 999, database.WalletAccountHeld: 5000})
+
+	if err := Reserve(db, 1, 2, 1000); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("got %v, want ErrInsufficientFunds", err)
+	}
+
+	checkLedger(t, *posted, nil)
+}
+
+func TestReleaseOrderReturnsWhatIsHeld(t *testing.T) {
+	db, posted := ledger(t, map[string]int64{database.WalletAccountHeld: 300})
+
+	if err := ReleaseOrder(db, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	checkLedger(t, *posted, []movement{
+		{kind: database.WalletTransactionRelease, amount: 300, from: database.WalletAccountHeld, to: database.WalletAccountAvailable},
+	})
+}
+
+func TestReleaseOrderWithNothingHeld(t *testing.T) {
+	db, posted := ledger(t, nil)
+
+	if err := ReleaseOrder(db, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	checkLedger(t, *posted, nil)
+}
+
+func TestGetBalance(t *testing.T) {
+	db, _ := ledger(t, map[string]int64{database.WalletAccountAvailable: 700, database.WalletAccountHeld: 300, database.WalletAccountExternal: -1000})
+
+	balance, err := GetBalance(db, 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *balance != (Balance{UsersID: 1, Available: 700, Held: 300, Total: 1000}) {
+		t.Fatalf("got %+v, want 700 available and 300 held", balance)
+	}
+}
+
+func TestTransactionRetriesSerializationFailures(t *testing.T) {
+	db, _ := ledger(t, nil)
+	serialization := &pgconn.PgError{Code: "40001"}
+
+	cases := []struct {
+		name     string
+		failures int
+		err      error
+		attempts int
+		fails    bool
+	}{
+		{name: "committed at once", attempts: 1},
+		{name: "committed on the last attempt", failures: maxAttempts - 1, err: serialization, attempts: maxAttempts},
+		{name: "gave up", failures: maxAttempts, err: serialization, attempts: maxAttempts, fails: true},
+		{name: "other errors are not retried", failures: maxAttempts, err: ErrInsufficientFunds, attempts: 1, fails: true},
+	}
+
+	for _, c := range cases {
+		attempts := 0
+
+		err := Transaction(context.Background(), db, func(tx *gorm.DB) error {
+			attempts++
+
+			if attempts <= c.failures {
+				return c.err
+			}
+
+			return nil
+		})
+
+		if attempts != c.attempts || (err != nil) != c.fails {
+			t.Errorf("%s: ran %d times with %v, want %d times", c.name, attempts, err, c.attempts)
+		}
+	}
+}